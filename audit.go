@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AuditEvent records a single action taken on a team service account.
+type AuditEvent struct {
+	Time           time.Time `json:"time"`
+	Operator       string    `json:"operator"`
+	Host           string    `json:"host,omitempty"`
+	Team           string    `json:"team"`
+	Cluster        string    `json:"cluster"`
+	Namespace      string    `json:"namespace"`
+	ServiceAccount string    `json:"serviceAccount"`
+	Action         string    `json:"action"`
+	Result         string    `json:"result"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// Auditor appends audit events to a file and/or posts them to an HTTP endpoint.
+// It is safe for concurrent use.
+type Auditor struct {
+	File     *os.File
+	URL      string
+	Operator string
+	Host     string
+	Client   *http.Client
+
+	mutex sync.Mutex
+}
+
+// auditor is nil unless audit logging is enabled.
+var auditor *Auditor
+
+// operatorIdentity returns the name of the person or system running teamconfig.
+func (c *Config) operatorIdentity() string {
+	if len(c.AuditOperator) > 0 {
+		return c.AuditOperator
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "unknown"
+}
+
+// NewAuditor opens the audit log for appending. The file is created if it does not exist.
+func NewAuditor(path, url string) (*Auditor, error) {
+	a := &Auditor{
+		URL:      url,
+		Operator: config.operatorIdentity(),
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	a.Host, _ = os.Hostname()
+
+	if len(path) > 0 {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		a.File = file
+	}
+
+	return a, nil
+}
+
+// Record writes an audit event for an action on a team service account in a cluster.
+// Failures to write the event are logged, since the action itself has already happened.
+func (a *Auditor) Record(ctx context.Context, team, cluster, action string, actionErr error) {
+	config := configFrom(ctx)
+	event := AuditEvent{
+		Time:           time.Now().UTC(),
+		Operator:       a.Operator,
+		Host:           a.Host,
+		Team:           team,
+		Cluster:        cluster,
+		Namespace:      config.serviceAccountNamespace(cluster),
+		ServiceAccount: ServiceAccountName(team),
+		Action:         action,
+		Result:         "success",
+	}
+	if actionErr != nil {
+		event.Result = "failure"
+		event.Error = actionErr.Error()
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.File != nil {
+		line, err := json.Marshal(event)
+		if err == nil {
+			_, err = a.File.Write(append(line, '\n'))
+		}
+		if err == nil {
+			err = a.File.Sync()
+		}
+		if err != nil {
+			log.Errorf("%s: while writing audit log: %s", cluster, err)
+		}
+	}
+
+	if len(a.URL) > 0 {
+		err := jsonRequest(ctx, a.Client, http.MethodPost, a.URL, nil, event, nil)
+		if err != nil {
+			log.Errorf("%s: while sending audit event: %s", cluster, err)
+		}
+	}
+}
+
+// Close closes the audit log file.
+func (a *Auditor) Close() error {
+	if a.File == nil {
+		return nil
+	}
+	return a.File.Close()
+}
+
+// auditAction returns the name of the mutating action performed by this run,
+// or an empty string if no changes are made.
+func (c *Config) auditAction() string {
+	switch {
+	case c.DryRun:
+		return ""
+	case c.Revoke:
+		return "revoke"
+	case c.Rotate:
+		return "rotate"
+	case c.Create:
+		return "create"
+	default:
+		return ""
+	}
+}
+
+// openAuditor enables audit logging if configured.
+func openAuditor() error {
+	if len(config.AuditLog) == 0 && len(config.AuditURL) == 0 {
+		return nil
+	}
+	a, err := NewAuditor(config.AuditLog, config.AuditURL)
+	if err != nil {
+		return fmt.Errorf("while opening audit log: %s", err)
+	}
+	auditor = a
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	log "github.com/sirupsen/logrus"
+)
+
+// NewSecretsManagerClient returns an AWS Secrets Manager client using the default credential chain,
+// including the shared configuration in ~/.aws and the AWS_PROFILE and AWS_REGION environment variables.
+func NewSecretsManagerClient() (*secretsmanager.SecretsManager, error) {
+	options := session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}
+	if len(config.AWS.Region) > 0 {
+		options.Config.Region = aws.String(config.AWS.Region)
+	}
+
+	sess, err := session.NewSessionWithOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return secretsmanager.New(sess), nil
+}
+
+// awsSecretTags returns the tags set on secrets created by teamconfig.
+func awsSecretTags(team string) []*secretsmanager.Tag {
+	return []*secretsmanager.Tag{
+		{Key: aws.String(TeamLabel), Value: aws.String(team)},
+		{Key: aws.String(ManagedByLabel), Value: aws.String(ManagedByValue)},
+	}
+}
+
+// writeSecretsManager stores the Kubeconfig file as the current version of an AWS Secrets Manager
+// secret, creating the secret if it does not exist. Previous versions are kept by Secrets Manager
+// until they are rotated out.
+func writeSecretsManager(ctx context.Context, team string, kubeconfig []byte) error {
+	config := configFrom(ctx)
+	client, err := NewSecretsManagerClient()
+	if err != nil {
+		return fmt.Errorf("while configuring AWS session: %s", err)
+	}
+
+	name := strings.Replace(config.AWS.Secret, "{team}", team, -1)
+	value := aws.String(string(kubeconfig))
+
+	output, err := client.PutSecretValueWithContext(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:     aws.String(name),
+		SecretString: value,
+	})
+	if err == nil {
+		log.Infof("credentials stored in AWS Secrets Manager as %s, version %s", aws.StringValue(output.ARN), aws.StringValue(output.VersionId))
+		return nil
+	}
+
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != secretsmanager.ErrCodeResourceNotFoundException {
+		return err
+	}
+
+	log.Debugf("secret %s not found, creating it", name)
+	input := &secretsmanager.CreateSecretInput{
+		Name:         aws.String(name),
+		Description:  aws.String(fmt.Sprintf("Kubeconfig file for team %s", team)),
+		SecretString: value,
+		Tags:         awsSecretTags(team),
+	}
+	if len(config.AWS.KMSKeyID) > 0 {
+		input.KmsKeyId = aws.String(config.AWS.KMSKeyID)
+	}
+
+	created, err := client.CreateSecretWithContext(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("credentials stored in AWS Secrets Manager as %s", aws.StringValue(created.ARN))
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	azureActiveDirectoryEndpoint = "https://login.microsoftonline.com/"
+	azureKeyVaultResource        = "https://vault.azure.net"
+	azureKeyVaultAPIVersion      = "7.0"
+)
+
+// Key Vault secret names may only contain alphanumeric characters and dashes.
+var azureSecretNameInvalid = regexp.MustCompile("[^0-9a-zA-Z-]")
+
+// AzureKeyVaultClient writes secrets to an Azure Key Vault.
+type AzureKeyVaultClient struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+// azureCLIToken retrieves an access token from the Azure CLI, using the account of the logged in user.
+func azureCLIToken(ctx context.Context, resource string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "az", "account", "get-access-token", "--resource", resource, "--output", "json")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("az: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	token := struct {
+		AccessToken string `json:"accessToken"`
+	}{}
+	err = json.Unmarshal(stdout.Bytes(), &token)
+	if err != nil {
+		return "", fmt.Errorf("while parsing output from az: %s", err)
+	}
+
+	return token.AccessToken, nil
+}
+
+// azureToken returns an access token for the given resource. If AZURE_TENANT_ID, AZURE_CLIENT_ID
+// and AZURE_CLIENT_SECRET are set, the token is issued to that service principal. Otherwise, the
+// Azure CLI is used, in the same way as the azure auth plugin relies on an existing login.
+func azureToken(ctx context.Context, resource string) (string, error) {
+	tenantID := os.Getenv("AZURE_TENANT_ID")
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+	clientSecret := os.Getenv("AZURE_CLIENT_SECRET")
+
+	if len(tenantID) == 0 || len(clientID) == 0 || len(clientSecret) == 0 {
+		log.Debugf("Azure service principal not configured, using Azure CLI credentials")
+		return azureCLIToken(ctx, resource)
+	}
+
+	oauthConfig, err := adal.NewOAuthConfig(azureActiveDirectoryEndpoint, tenantID)
+	if err != nil {
+		return "", err
+	}
+	spt, err := adal.NewServicePrincipalToken(*oauthConfig, clientID, clientSecret, resource)
+	if err != nil {
+		return "", err
+	}
+	err = spt.EnsureFreshWithContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return spt.OAuthToken(), nil
+}
+
+// NewAzureKeyVaultClient returns a client for a Key Vault, given by its name or URL.
+func NewAzureKeyVaultClient(ctx context.Context, vault string) (*AzureKeyVaultClient, error) {
+	token, err := azureToken(ctx, azureKeyVaultResource)
+	if err != nil {
+		return nil, fmt.Errorf("while retrieving Azure credentials: %s", err)
+	}
+
+	url := vault
+	if !strings.HasPrefix(url, "https://") {
+		url = fmt.Sprintf("https://%s.vault.azure.net", vault)
+	}
+
+	return &AzureKeyVaultClient{
+		URL:    strings.TrimSuffix(url, "/"),
+		Token:  token,
+		Client: &http.Client{},
+	}, nil
+}
+
+// SetSecret stores a new version of a secret.
+func (a *AzureKeyVaultClient) SetSecret(ctx context.Context, name, value, contentType string, tags map[string]string) error {
+	url := fmt.Sprintf("%s/secrets/%s?api-version=%s", a.URL, name, azureKeyVaultAPIVersion)
+	headers := map[string]string{
+		"Authorization": "Bearer " + a.Token,
+	}
+	body := map[string]interface{}{
+		"value":       value,
+		"contentType": contentType,
+		"tags":        tags,
+	}
+	return jsonRequest(ctx, a.Client, http.MethodPut, url, headers, body, nil)
+}
+
+// azureSecretName returns the name of the Key Vault secret holding the Kubeconfig file for a team.
+func azureSecretName(team string) string {
+	name := strings.Replace(config.Azure.SecretName, "{team}", team, -1)
+	return azureSecretNameInvalid.ReplaceAllString(name, "-")
+}
+
+// writeAzureKeyVault stores the Kubeconfig file and/or the token of each cluster as Key Vault secrets.
+func writeAzureKeyVault(ctx context.Context, team string, kubeconfig []byte, credentials []*Credentials) error {
+	config := configFrom(ctx)
+	client, err := NewAzureKeyVaultClient(ctx, config.Azure.KeyVault)
+	if err != nil {
+		return err
+	}
+
+	name := azureSecretName(team)
+	tags := map[string]string{
+		TeamLabel:      team,
+		ManagedByLabel: ManagedByValue,
+	}
+
+	for _, field := range config.Azure.Fields {
+		switch field {
+		case "kubeconfig":
+			err = client.SetSecret(ctx, name, string(kubeconfig), "application/yaml", tags)
+			if err != nil {
+				return fmt.Errorf("while storing secret %s: %s", name, err)
+			}
+		case "tokens":
+			for _, c := range credentials {
+				if c == nil {
+					continue
+				}
+				tokenName := azureSecretNameInvalid.ReplaceAllString(fmt.Sprintf("%s-token-%s", name, c.Cluster), "-")
+				err = client.SetSecret(ctx, tokenName, c.Token, "text/plain", tags)
+				if err != nil {
+					return fmt.Errorf("while storing secret %s: %s", tokenName, err)
+				}
+			}
+		default:
+			return fmt.Errorf("unknown Azure Key Vault field '%s'; must be one of kubeconfig, tokens", field)
+		}
+	}
+
+	log.Infof("credentials stored in Azure Key Vault %s as %s", client.URL, name)
+	return nil
+}
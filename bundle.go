@@ -0,0 +1,158 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BundleMetadata describes the contents of a bundle, in metadata.json.
+type BundleMetadata struct {
+	Team        string          `json:"team"`
+	Generated   time.Time       `json:"generated"`
+	GeneratedBy string          `json:"generatedBy"`
+	Clusters    []BundleCluster `json:"clusters"`
+}
+
+// BundleCluster describes a cluster in a bundle.
+type BundleCluster struct {
+	Name           string `json:"name"`
+	Server         string `json:"server"`
+	Namespace      string `json:"namespace"`
+	ServiceAccount string `json:"serviceAccount"`
+	Kubeconfig     string `json:"kubeconfig"`
+}
+
+// bundleReadme is README.md in a bundle. %s is replaced with the team.
+const bundleReadme = `# Kubernetes credentials of team %s
+
+- kubeconfig.yaml contains all clusters, with a context per cluster.
+- clusters/<cluster>.yaml contains a single cluster each.
+- metadata.json describes when the credentials were generated, and for which clusters.
+
+Use them with e.g.:
+
+    export KUBECONFIG=$PWD/kubeconfig.yaml
+    kubectl config get-contexts
+
+These files contain secret tokens. Do not commit them or share them outside the team.
+`
+
+// bundlePath returns the path of the bundle of a team.
+func bundlePath(team string) string {
+	return strings.Replace(config.Bundle, "{team}", team, -1)
+}
+
+// tarFile is a file in a bundle.
+type tarFile struct {
+	Name string
+	Data []byte
+}
+
+// writeTarGz returns a gzip compressed tar archive of the files, readable only by their owner.
+func writeTarGz(files []tarFile, modified time.Time) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, file := range files {
+		header := &tar.Header{
+			Name:    file.Name,
+			Mode:    0600,
+			Size:    int64(len(file.Data)),
+			ModTime: modified,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(file.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// BundleSink writes a gzip compressed tar archive per team to --bundle, with the merged
+// Kubeconfig file, a Kubeconfig file per cluster, a README and metadata.json. The archive is
+// encrypted with --encrypt-gpg or --encrypt-age if given.
+type BundleSink struct{}
+
+func (s *BundleSink) Paths(ctx context.Context, team string) []string {
+	return []string{bundlePath(team)}
+}
+
+func (s *BundleSink) Write(ctx context.Context, team string, kubeconfig []byte, credentials []*Credentials) error {
+	config := configFrom(ctx)
+	now := time.Now().UTC()
+	metadata := BundleMetadata{
+		Team:        team,
+		Generated:   now,
+		GeneratedBy: configFrom(ctx).operatorIdentity(),
+		Clusters:    make([]BundleCluster, 0, len(credentials)),
+	}
+	files := []tarFile{
+		{Name: "README.md", Data: []byte(fmt.Sprintf(bundleReadme, team))},
+		{Name: "kubeconfig.yaml", Data: kubeconfig},
+	}
+
+	for _, c := range credentials {
+		if c == nil {
+			continue
+		}
+		data, err := renderKubeconfig(ctx, team, []*Credentials{c}, false)
+		if err != nil {
+			return fmt.Errorf("while generating output for %s: %s", c.Cluster, err)
+		}
+		name := fmt.Sprintf("clusters/%s.yaml", c.Cluster)
+		files = append(files, tarFile{Name: name, Data: data})
+		metadata.Clusters = append(metadata.Clusters, BundleCluster{
+			Name:           c.Cluster,
+			Server:         c.Server,
+			Namespace:      config.serviceAccountNamespace(c.Cluster),
+			ServiceAccount: ServiceAccountName(team),
+			Kubeconfig:     name,
+		})
+	}
+
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	files = append(files, tarFile{Name: "metadata.json", Data: append(data, '\n')})
+
+	archive, err := writeTarGz(files, now)
+	if err == nil {
+		archive, err = encryptOutput(archive)
+	}
+	if err != nil {
+		return fmt.Errorf("while creating bundle: %s", err)
+	}
+
+	path := bundlePath(team)
+	err = WriteFileAtomic(path, archive, config.Force)
+	if err != nil {
+		return err
+	}
+	log.Infof("bundle written to %s", path)
+	return nil
+}
+
+// validateBundle checks the bundle flags before any changes are made.
+func validateBundle() error {
+	if len(config.Bundle) > 0 && config.EncryptSOPS {
+		return fmt.Errorf("--bundle cannot be encrypted with --encrypt-sops; use --encrypt-gpg or --encrypt-age")
+	}
+	return nil
+}
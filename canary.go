@@ -0,0 +1,43 @@
+package main
+
+import "fmt"
+
+// canaryClusters splits the configured clusters into those matching --canary-clusters and the
+// rest, as indices into c.Clusters. Without --canary-clusters, every cluster is in the rest.
+func (c *Config) canaryClusters() ([]int, []int, error) {
+	canaries := make([]int, 0)
+	others := make([]int, 0)
+	for i, cluster := range c.Clusters {
+		canary, err := matchesAny(c.CanaryClusters, cluster)
+		if err != nil {
+			return nil, nil, err
+		}
+		if canary {
+			canaries = append(canaries, i)
+		} else {
+			others = append(others, i)
+		}
+	}
+	return canaries, others, nil
+}
+
+// validateCanary checks --canary-clusters before any changes are made.
+func validateCanary() error {
+	if len(config.CanaryClusters) == 0 {
+		return nil
+	}
+	if !config.Rotate {
+		return fmt.Errorf("--canary-clusters can only be used when rotating")
+	}
+	if !config.Verify {
+		return fmt.Errorf("--canary-clusters requires --verify, which decides whether the canary succeeded")
+	}
+	canaries, _, err := config.canaryClusters()
+	if err != nil {
+		return fmt.Errorf("invalid --canary-clusters: %s", err)
+	}
+	if len(canaries) == 0 {
+		return fmt.Errorf("none of the clusters match --canary-clusters")
+	}
+	return nil
+}
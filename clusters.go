@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// knownClusters returns the clusters that patterns in --clusters are matched against:
+// all contexts in the Kubeconfig file and the --in-cluster cluster with --all-contexts,
+// or the default cluster list.
+func knownClusters() ([]string, error) {
+	if !config.AllContexts {
+		return DefaultConfig().Clusters, nil
+	}
+
+	contexts, err := KubeconfigContexts(config.Kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("while reading contexts: %s", err)
+	}
+	if len(config.InCluster) > 0 && !contains(contexts, config.InCluster) {
+		contexts = append(contexts, config.InCluster)
+	}
+	if len(contexts) == 0 {
+		return nil, fmt.Errorf("no contexts found in Kubeconfig")
+	}
+
+	return contexts, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// matchesAny returns true if the cluster matches any of the given names or glob patterns.
+func matchesAny(patterns []string, cluster string) (bool, error) {
+	for _, pattern := range patterns {
+		ok, err := path.Match(pattern, cluster)
+		if err != nil {
+			return false, fmt.Errorf("invalid cluster pattern '%s': %s", pattern, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// resolveClusters determines which clusters to operate on. Entries in --clusters may be glob
+// patterns such as 'dev-*', which are matched against the known clusters. With --all-contexts,
+// every context in the Kubeconfig file is used unless --clusters selects a subset of them.
+// Finally, --clusters-regex and --exclude-clusters filter the result.
+func resolveClusters(clustersChanged bool) error {
+	known, err := knownClusters()
+	if err != nil {
+		return err
+	}
+
+	isKnown := make(map[string]bool)
+	for _, cluster := range known {
+		isKnown[cluster] = true
+	}
+
+	selected := known
+	if clustersChanged {
+		selected = make([]string, 0)
+		for _, pattern := range config.Clusters {
+			if !isGlob(pattern) {
+				if config.AllContexts && !isKnown[pattern] {
+					return fmt.Errorf("context '%s' not found in Kubeconfig", pattern)
+				}
+				selected = append(selected, pattern)
+				continue
+			}
+
+			matched := false
+			for _, cluster := range known {
+				ok, err := path.Match(pattern, cluster)
+				if err != nil {
+					return fmt.Errorf("invalid cluster pattern '%s': %s", pattern, err)
+				}
+				if ok {
+					selected = append(selected, cluster)
+					matched = true
+				}
+			}
+			if !matched {
+				return fmt.Errorf("no clusters match '%s'", pattern)
+			}
+		}
+	}
+
+	if len(config.ClustersRegex) > 0 {
+		re, err := regexp.Compile(config.ClustersRegex)
+		if err != nil {
+			return fmt.Errorf("invalid --clusters-regex: %s", err)
+		}
+		filtered := make([]string, 0)
+		for _, cluster := range selected {
+			if re.MatchString(cluster) {
+				filtered = append(filtered, cluster)
+			}
+		}
+		selected = filtered
+	}
+
+	if len(config.ExcludeClusters) > 0 {
+		filtered := make([]string, 0)
+		for _, cluster := range selected {
+			excluded, err := matchesAny(config.ExcludeClusters, cluster)
+			if err != nil {
+				return err
+			}
+			if excluded {
+				log.Debugf("%s: excluded", cluster)
+			} else {
+				filtered = append(filtered, cluster)
+			}
+		}
+		selected = filtered
+	}
+
+	// remove duplicates from overlapping patterns, keeping the first occurrence
+	seen := make(map[string]bool)
+	config.Clusters = make([]string, 0, len(selected))
+	for _, cluster := range selected {
+		if !seen[cluster] {
+			config.Clusters = append(config.Clusters, cluster)
+			seen[cluster] = true
+		}
+	}
+
+	if len(config.Clusters) == 0 {
+		return fmt.Errorf("no clusters selected")
+	}
+
+	log.Debugf("operating on clusters: %s", strings.Join(config.Clusters, ", "))
+	return nil
+}
+
+// clusterClient returns the client configuration and a client for a cluster. All requests
+// made by the client are cancelled when the context is done.
+func clusterClient(ctx context.Context, cluster string) (*rest.Config, kubernetes.Interface, error) {
+	config := configFrom(ctx)
+	var clientConfig *rest.Config
+	var err error
+	if len(config.InCluster) > 0 && cluster == config.InCluster {
+		clientConfig, err = rest.InClusterConfig()
+	} else {
+		clientConfig, err = buildConfigFromFlags(cluster, config.Kubeconfig)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if config.QPS > 0 {
+		clientConfig.QPS = config.QPS
+	}
+	if config.Burst > 0 {
+		clientConfig.Burst = config.Burst
+	}
+	if config.RequestTimeout > 0 {
+		clientConfig.Timeout = config.RequestTimeout
+	}
+	if len(config.As) > 0 || len(config.AsGroups) > 0 {
+		clientConfig.Impersonate = rest.ImpersonationConfig{
+			UserName: config.As,
+			Groups:   config.AsGroups,
+		}
+	}
+
+	client, err := newClusterClient(ctx, cluster, clientConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return clientConfig, client, nil
+}
+
+// newClusterClient returns a client for a cluster using clientConfig,
+// which connects through the proxy given for the cluster with --cluster-proxy.
+func newClusterClient(ctx context.Context, cluster string, clientConfig *rest.Config) (kubernetes.Interface, error) {
+	config := configFrom(ctx)
+	if proxy, ok := config.Proxies[cluster]; ok {
+		var err error
+		clientConfig, err = proxyConfig(clientConfig, proxy)
+		if err != nil {
+			return nil, fmt.Errorf("while configuring proxy: %s", err)
+		}
+	}
+	return KubeClient(ctx, clientConfig, config.Retries, config.RetryInterval)
+}
+
+// forEachCluster calls fn for every configured cluster, running at most
+// config.Concurrency calls in parallel. Each call gets its own context,
+// which expires after config.ClusterTimeout. Every cluster is visited even
+// if some of them fail. Errors are logged, and the first one is returned.
+func forEachCluster(ctx context.Context, fn func(ctx context.Context, i int, cluster string) error) error {
+	config := configFrom(ctx)
+	indices := make([]int, len(config.Clusters))
+	for i := range indices {
+		indices[i] = i
+	}
+	return forClusters(ctx, indices, fn)
+}
+
+// forClusters is forEachCluster for the configured clusters with the given indices.
+func forClusters(ctx context.Context, indices []int, fn func(ctx context.Context, i int, cluster string) error) error {
+	config := configFrom(ctx)
+	var group errgroup.Group
+	semaphore := make(chan struct{}, config.Concurrency)
+
+	for _, i := range indices {
+		i, cluster := i, config.Clusters[i]
+		group.Go(func() error {
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+			case <-ctx.Done():
+				log.Errorf("%s: %s", cluster, ctx.Err())
+				return ctx.Err()
+			}
+
+			clusterCtx, cancel := ctx, context.CancelFunc(func() {})
+			if config.ClusterTimeout > 0 {
+				clusterCtx, cancel = context.WithTimeout(ctx, config.ClusterTimeout)
+			}
+			defer cancel()
+
+			err := fn(clusterCtx, i, cluster)
+			if err != nil {
+				if clusterCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+					err = fmt.Errorf("timed out after %s: %s", config.ClusterTimeout, err)
+				}
+				log.Errorf("%s: %s", cluster, err)
+			}
+			return err
+		})
+	}
+
+	return group.Wait()
+}
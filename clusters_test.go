@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveClusters(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		clusters []string
+		regex    string
+		exclude  []string
+		expected []string
+		err      bool
+	}{
+		{
+			name:     "defaults",
+			expected: []string{"dev-fss", "dev-sbs", "prod-fss", "prod-sbs"},
+		},
+		{
+			name:     "glob",
+			clusters: []string{"dev-*"},
+			expected: []string{"dev-fss", "dev-sbs"},
+		},
+		{
+			name:     "overlapping globs are deduplicated",
+			clusters: []string{"*-fss", "prod-*"},
+			expected: []string{"dev-fss", "prod-fss", "prod-sbs"},
+		},
+		{
+			name:     "character class",
+			clusters: []string{"prod-[fs]*"},
+			expected: []string{"prod-fss", "prod-sbs"},
+		},
+		{
+			name:     "names are kept as given",
+			clusters: []string{"prod-gcp", "dev-fss"},
+			expected: []string{"prod-gcp", "dev-fss"},
+		},
+		{
+			name:     "glob matching nothing",
+			clusters: []string{"staging-*"},
+			err:      true,
+		},
+		{
+			name:     "invalid glob",
+			clusters: []string{"dev-["},
+			err:      true,
+		},
+		{
+			name:     "regex",
+			regex:    "fss$",
+			expected: []string{"dev-fss", "prod-fss"},
+		},
+		{
+			name:  "invalid regex",
+			regex: "(",
+			err:   true,
+		},
+		{
+			name:     "exclude",
+			exclude:  []string{"prod-*"},
+			expected: []string{"dev-fss", "dev-sbs"},
+		},
+		{
+			name:     "exclude after glob",
+			clusters: []string{"*-fss"},
+			exclude:  []string{"dev-fss"},
+			expected: []string{"prod-fss"},
+		},
+		{
+			name:    "everything excluded",
+			exclude: []string{"*"},
+			err:     true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := DefaultConfig()
+			if test.clusters != nil {
+				c.Clusters = test.clusters
+			}
+			c.ClustersRegex = test.regex
+			c.ExcludeClusters = test.exclude
+			setConfig(t, c)
+
+			err := resolveClusters(test.clusters != nil)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", config.Clusters)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config.Clusters, test.expected) {
+				t.Errorf("got %v, expected %v", config.Clusters, test.expected)
+			}
+		})
+	}
+}
+
+func TestMatchesAny(t *testing.T) {
+	for _, test := range []struct {
+		patterns []string
+		cluster  string
+		expected bool
+		err      bool
+	}{
+		{patterns: nil, cluster: "dev-fss", expected: false},
+		{patterns: []string{"dev-fss"}, cluster: "dev-fss", expected: true},
+		{patterns: []string{"prod-*", "dev-*"}, cluster: "dev-fss", expected: true},
+		{patterns: []string{"dev-?ss"}, cluster: "dev-fss", expected: true},
+		{patterns: []string{"prod-*"}, cluster: "dev-fss", expected: false},
+		{patterns: []string{"["}, cluster: "dev-fss", err: true},
+	} {
+		got, err := matchesAny(test.patterns, test.cluster)
+		if (err != nil) != test.err {
+			t.Errorf("%v %s: unexpected error %v", test.patterns, test.cluster, err)
+			continue
+		}
+		if got != test.expected {
+			t.Errorf("%v %s: got %t, expected %t", test.patterns, test.cluster, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+)
+
+// Command is a teamconfig subcommand, such as `teamconfig rotate`.
+type Command struct {
+	Name        string
+	Description string
+
+	// NoTeam is set for commands that do not operate on a single team.
+	NoTeam bool
+
+	// Flags adds any command specific flags to the flag set.
+	Flags func(fs *flag.FlagSet)
+
+	// Run executes the command after flags have been parsed.
+	Run func(ctx context.Context) error
+}
+
+var commands = []*Command{
+	{
+		Name:        "get",
+		Description: "Generate a Kubeconfig file for an existing team service user.",
+		Flags: func(fs *flag.FlagSet) {
+			config.addGenerateFlags(fs)
+			config.addBatchFlags(fs)
+			config.addReportFlags(fs)
+		},
+		Run: generate,
+	},
+	{
+		Name:        "create",
+		Description: "Create team service users that do not exist, and generate a Kubeconfig file.",
+		Flags: func(fs *flag.FlagSet) {
+			config.addGenerateFlags(fs)
+			config.addMutateFlags(fs)
+			config.addRBACFlags(fs)
+			config.addBatchFlags(fs)
+			config.addReportFlags(fs)
+		},
+		Run: func(ctx context.Context) error {
+			config.Create = true
+			return generate(ctx)
+		},
+	},
+	{
+		Name:        "rotate",
+		Description: "Rotate secret tokens that are already present in cluster, and generate a Kubeconfig file. This will invalidate old tokens.",
+		Flags: func(fs *flag.FlagSet) {
+			config.addGenerateFlags(fs)
+			config.addMutateFlags(fs)
+			config.addRBACFlags(fs)
+			config.addBatchFlags(fs)
+			config.addReportFlags(fs)
+			fs.BoolVar(&config.Create, "create", config.Create, "Create team service users that do not exist.")
+			fs.Var((*dayDuration)(&config.RotateIfOlderThan), "rotate-if-older-than", "Only rotate service users created or rotated longer ago than this, e.g. 90d.")
+			config.addRotationFlags(fs)
+			fs.BoolVar(&config.TwoPhase, "two-phase", config.TwoPhase, "Issue a new token secret and keep the previous token valid until finish-rotation is run, instead of invalidating it immediately.")
+			fs.Var((*dayDuration)(&config.GracePeriod), "grace-period", "Mark the previous token as expiring after this long, e.g. 24h. It is invalidated by the next run of finish-rotation --expired-only, reconcile or rotate-daemon after that. Implies --two-phase.")
+		},
+		Run: func(ctx context.Context) error {
+			config.Rotate = true
+			config.TwoPhase = config.TwoPhase || config.GracePeriod > 0
+			return generate(ctx)
+		},
+	},
+	{
+		Name:        "finish-rotation",
+		Description: "Invalidate the previous tokens kept valid by rotate --two-phase, once the team uses the new Kubeconfig file.",
+		Flags: func(fs *flag.FlagSet) {
+			config.addMutateFlags(fs)
+			fs.BoolVar(&config.ExpiredOnly, "expired-only", config.ExpiredOnly, "Only invalidate previous tokens whose --grace-period has ended, e.g. from a scheduled job.")
+		},
+		Run: func(ctx context.Context) error {
+			config.FinishRotation = true
+			return finishRotation(ctx)
+		},
+	},
+	{
+		Name:        "revoke",
+		Description: "Delete any tokens that belongs to this team. No configuration will be generated.",
+		Flags: func(fs *flag.FlagSet) {
+			config.addMutateFlags(fs)
+			config.addRBACFlags(fs)
+			config.addBatchFlags(fs)
+			config.addReportFlags(fs)
+		},
+		Run: func(ctx context.Context) error {
+			config.Revoke = true
+			return generate(ctx)
+		},
+	},
+	{
+		Name:        "rotate-all",
+		Description: "Rotate every team service user found in the clusters, e.g. after a suspected leak. This will invalidate old tokens.",
+		NoTeam:      true,
+		Flags: func(fs *flag.FlagSet) {
+			config.addGenerateFlags(fs)
+			config.addMutateFlags(fs)
+			config.addRBACFlags(fs)
+			config.addSpreadFlags(fs)
+			config.addReportFlags(fs)
+			config.addRotationFlags(fs)
+		},
+		Run: rotateAll,
+	},
+	{
+		Name:        "reconcile",
+		Description: "Create and revoke team service users so that the clusters match the teams listed in a file.",
+		NoTeam:      true,
+		Flags: func(fs *flag.FlagSet) {
+			config.addGenerateFlags(fs)
+			config.addMutateFlags(fs)
+			config.addRBACFlags(fs)
+			config.addReportFlags(fs)
+			config.addStateFlags(fs)
+		},
+		Run: reconcile,
+	},
+	{
+		Name:        "plan",
+		Description: "Show the changes reconcile would make, and optionally save them to a plan file for review.",
+		NoTeam:      true,
+		Flags: func(fs *flag.FlagSet) {
+			config.addStateFlags(fs)
+			fs.Var((*dayDuration)(&config.RotateIfOlderThan), "rotate-if-older-than", "Also plan rotating service users created or rotated longer ago than this, e.g. 90d.")
+			fs.StringVar(&config.PlanFile, "plan-file", config.PlanFile, "Write the plan as JSON to this file, for apply.")
+		},
+		Run: plan,
+	},
+	{
+		Name:        "apply",
+		Description: "Make the changes in a plan file, if the clusters have not changed since it was made.",
+		NoTeam:      true,
+		Flags: func(fs *flag.FlagSet) {
+			config.addGenerateFlags(fs)
+			config.addMutateFlags(fs)
+			config.addRBACFlags(fs)
+			config.addReportFlags(fs)
+			fs.StringVar(&config.PlanFile, "plan-file", config.PlanFile, "Plan file written by plan.")
+		},
+		Run: apply,
+	},
+	{
+		Name:        "gitops",
+		Description: "Write the manifests of the team service user to a Git repository, for a GitOps tool to apply, instead of changing the clusters.",
+		Flags: func(fs *flag.FlagSet) {
+			config.addRBACFlags(fs)
+			fs.StringVar(&config.TeamsFile, "teams-file", config.TeamsFile, "Write the manifests of all teams listed in this file, instead of --team.")
+			fs.StringVar(&config.GitOps.Repo, "gitops-repo", config.GitOps.Repo, "Path of a checked out Git repository to write manifests to.")
+			fs.StringVar(&config.GitOps.Path, "gitops-path", config.GitOps.Path, "Path of the manifest file of each team in each cluster, relative to --gitops-repo. {team} and {cluster} are replaced.")
+			fs.BoolVar(&config.GitOps.Commit, "gitops-commit", config.GitOps.Commit, "Commit the changed manifests.")
+			fs.BoolVar(&config.GitOps.Push, "gitops-push", config.GitOps.Push, "Push the commit made with --gitops-commit.")
+			fs.BoolVar(&config.Revoke, "revoke", config.Revoke, "Remove the manifests of the team instead, so that the GitOps tool deletes the service user.")
+		},
+		Run: gitops,
+	},
+	{
+		Name:        "status",
+		Description: "Show the state of the team service user in each cluster, without making any changes.",
+		Flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&config.ShowClaims, "claims", config.ShowClaims, "Show the type, issuer, audiences and bound objects of each token.")
+		},
+		Run: status,
+	},
+	{
+		Name:        "compare",
+		Description: "Check that the team service user, its token and its bindings are the same in all clusters, and show the differences.",
+		Flags: func(fs *flag.FlagSet) {
+			config.addRBACFlags(fs)
+		},
+		Run: compare,
+	},
+	{
+		Name:        "history",
+		Description: "Show when the team service user was created and rotated in each cluster, and by whom.",
+		Run:         history,
+	},
+	{
+		Name:        "token",
+		Description: "Print a short-lived token for the team service user in one cluster, for use as a credential plugin.",
+		Flags: func(fs *flag.FlagSet) {
+			config.addTokenRequestFlags(fs)
+		},
+		Run: token,
+	},
+	{
+		Name:        "doctor",
+		Description: "Check that each cluster is reachable, and that you are allowed to manage team service users in it.",
+		NoTeam:      true,
+		Run:         doctor,
+	},
+	{
+		Name:        "list",
+		Description: "List all team service users in each cluster.",
+		NoTeam:      true,
+		Flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&config.AllNamespaces, "all-namespaces", config.AllNamespaces, "Look for team service users in all namespaces, instead of only --service-account-namespace.")
+		},
+		Run: list,
+	},
+	{
+		Name:        "operator",
+		Description: "Continuously reconcile TeamAccess resources, creating and rotating team service users and storing their Kubeconfig files in secrets.",
+		NoTeam:      true,
+		Flags: func(fs *flag.FlagSet) {
+			config.addCredentialFlags(fs)
+			config.addRBACFlags(fs)
+			fs.StringVar(&config.OperatorCluster, "operator-cluster", config.OperatorCluster, "Cluster holding the TeamAccess resources and the secrets with Kubeconfig files. Usually the same as --in-cluster.")
+			fs.StringVar(&config.OperatorNamespace, "operator-namespace", config.OperatorNamespace, "Only reconcile TeamAccess resources in this namespace. Defaults to all namespaces.")
+			fs.StringSliceVar(&config.OperatorAdminNamespaces, "operator-admin-namespaces", config.OperatorAdminNamespaces, "Namespaces whose TeamAccess resources may grant access to any team. Elsewhere, spec.team must be the namespace of the resource.")
+			fs.DurationVar(&config.ResyncInterval, "resync-interval", config.ResyncInterval, "How often to reconcile all TeamAccess resources.")
+			fs.StringVar(&config.ListenAddress, "listen-address", config.ListenAddress, "Address to serve health checks on.")
+			config.addLeaderElectionFlags(fs)
+			config.addDebugFlags(fs)
+		},
+		Run: operator,
+	},
+	{
+		Name:        "expiring",
+		Description: "List team credentials in each cluster that expire soon, for alerting.",
+		NoTeam:      true,
+		Flags: func(fs *flag.FlagSet) {
+			fs.Var((*dayDuration)(&config.ExpiringWithin), "within", "List credentials expiring within this long, e.g. 14d.")
+			fs.Var((*dayDuration)(&config.MaxTokenAge), "max-age", "Treat credentials without a known expiry as expiring this long after they were last rotated, e.g. 90d.")
+			fs.BoolVar(&config.AllNamespaces, "all-namespaces", config.AllNamespaces, "Look for team service users in all namespaces, instead of only --service-account-namespace.")
+			fs.BoolVar(&config.OutputJSON, "json", config.OutputJSON, "Print the credentials as JSON instead of a table.")
+		},
+		Run: expiring,
+	},
+	{
+		Name:        "serve",
+		Description: "Serve an HTTP API for creating, rotating, revoking and inspecting team service users.",
+		NoTeam:      true,
+		Flags: func(fs *flag.FlagSet) {
+			config.addGenerateFlags(fs)
+			config.addMutateFlags(fs)
+			config.addRBACFlags(fs)
+			fs.StringVar(&config.ListenAddress, "listen-address", config.ListenAddress, "Address to serve the API on.")
+			fs.StringVar(&config.GRPCAddress, "grpc-address", config.GRPCAddress, "Also serve the gRPC API described in api/teamconfig.proto on this address, e.g. :9090.")
+			config.addServerAuthFlags(fs)
+			config.addDebugFlags(fs)
+		},
+		Run: serve,
+	},
+	{
+		Name:        "rotate-daemon",
+		Description: "Rotate teams automatically on a cron schedule, until interrupted.",
+		NoTeam:      true,
+		Flags: func(fs *flag.FlagSet) {
+			config.addGenerateFlags(fs)
+			config.addMutateFlags(fs)
+			config.addRBACFlags(fs)
+			config.addBatchFlags(fs)
+			config.addReportFlags(fs)
+			fs.StringVar(&config.Schedule, "schedule", config.Schedule, "When to rotate, as a cron expression in local time, e.g. '0 6 1 */3 *' for quarterly. @daily, @weekly and @monthly are also accepted.")
+			fs.BoolVar(&config.DiscoverTeams, "discover-teams", config.DiscoverTeams, "Rotate all teams with a service user in any of the clusters, instead of those in --teams-file.")
+			fs.Var((*dayDuration)(&config.RotateIfOlderThan), "rotate-if-older-than", "Only rotate service users created or rotated longer ago than this, e.g. 90d.")
+			config.addRotationFlags(fs)
+			fs.StringVar(&config.ListenAddress, "listen-address", config.ListenAddress, "Address to serve health checks on.")
+			config.addDebugFlags(fs)
+		},
+		Run: rotateDaemon,
+	},
+	{
+		Name:        "webhook",
+		Description: "Serve a validating admission webhook rejecting manual changes to team service users and their token secrets.",
+		NoTeam:      true,
+		Flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&config.WebhookCluster, "webhook-cluster", config.WebhookCluster, "Cluster the webhook is registered in. Usually the same as --in-cluster.")
+			fs.StringVar(&config.ListenAddress, "listen-address", config.ListenAddress, "Address to serve the webhook on.")
+			fs.StringVar(&config.TLSCertFile, "tls-cert-file", config.TLSCertFile, "File containing the TLS certificate of the webhook.")
+			fs.StringVar(&config.TLSKeyFile, "tls-key-file", config.TLSKeyFile, "File containing the TLS private key of the webhook.")
+			fs.StringSliceVar(&config.TrustedUsers, "trusted-users", config.TrustedUsers, "Always admit changes by these users, such as the identity teamconfig runs as, e.g. system:serviceaccount:nais:teamconfig.")
+			config.addDebugFlags(fs)
+		},
+		Run: webhook,
+	},
+	{
+		Name:        "exporter",
+		Description: "Periodically scan all clusters, and expose the age of team tokens as Prometheus metrics.",
+		NoTeam:      true,
+		Flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&config.ListenAddress, "listen-address", config.ListenAddress, "Address to serve metrics on.")
+			fs.DurationVar(&config.ScanInterval, "scan-interval", config.ScanInterval, "How often to scan the clusters.")
+			fs.BoolVar(&config.AllNamespaces, "all-namespaces", config.AllNamespaces, "Look for team service users in all namespaces, instead of only --service-account-namespace.")
+			fs.StringVar(&config.TeamsFile, "teams-file", config.TeamsFile, "Report teams listed in this file as missing in clusters where they have no service user.")
+			config.addDebugFlags(fs)
+		},
+		Run: exporter,
+	},
+}
+
+func findCommand(name string) *Command {
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", cmd.Name, cmd.Description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> --help' for more information on a command.\n", os.Args[0])
+}
+
+// parseFlags sets up logging and validates the common configuration
+// after a flag set has been parsed.
+func parseFlags(fs *flag.FlagSet, args []string, requireTeam bool) error {
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if config.Debug {
+		log.SetLevel(log.TraceLevel)
+	} else {
+		log.SetLevel(log.InfoLevel)
+	}
+
+	if requireTeam && len(config.Team) == 0 && len(config.TeamsFile) == 0 {
+		fs.Usage()
+		return fmt.Errorf("team name must be specified")
+	}
+
+	if config.Concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1")
+	}
+
+	if config.BatchSize < 1 {
+		return fmt.Errorf("--batch-size must be at least 1")
+	}
+
+	if config.Retries < 0 {
+		return fmt.Errorf("--retries must not be negative")
+	}
+
+	if config.TokenTTL != 0 && config.TokenTTL < MinTokenTTL {
+		return fmt.Errorf("--token-ttl must be at least %s", MinTokenTTL)
+	}
+
+	if config.QPS < 0 || config.Burst < 0 || config.RequestTimeout < 0 {
+		return fmt.Errorf("--kube-qps, --kube-burst and --request-timeout must not be negative")
+	}
+
+	if len(config.AsGroups) > 0 && len(config.As) == 0 {
+		return fmt.Errorf("--as-group requires --as")
+	}
+
+	for cluster, proxy := range config.Proxies {
+		if _, err := parseProxyURL(proxy); err != nil {
+			return fmt.Errorf("invalid --cluster-proxy for %s: %s", cluster, err)
+		}
+	}
+
+	err = resolveClusters(fs.Changed("clusters"))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// signalContext returns a context that is cancelled when the process is
+// interrupted. A second interrupt terminates the process immediately.
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		log.Warnf("received %s, cancelling outstanding work", sig)
+		signal.Stop(signals)
+		cancel()
+	}()
+
+	return ctx
+}
+
+func runCommand(cmd *Command, args []string) error {
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s\n\nUsage of %s %s:\n", cmd.Description, os.Args[0], cmd.Name)
+		fs.PrintDefaults()
+	}
+
+	config.addFlags(fs)
+	if cmd.Flags != nil {
+		cmd.Flags(fs)
+	}
+
+	err := parseFlags(fs, args, !cmd.NoTeam)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return validationError(err)
+	}
+
+	ctx := signalContext()
+	shutdown, err := initTracing(ctx)
+	if err != nil {
+		return fmt.Errorf("while setting up tracing: %s", err)
+	}
+	defer shutdown()
+
+	ctx, span := tracer.Start(ctx, cmd.Name)
+	err = cmd.Run(ctx)
+	endSpan(span, err)
+
+	return err
+}
+
+// runLegacy runs teamconfig using the deprecated --create, --rotate and --revoke flags.
+func runLegacy(args []string) error {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.Usage = usage
+
+	config.addFlags(fs)
+	config.addGenerateFlags(fs)
+	config.addMutateFlags(fs)
+	config.addRBACFlags(fs)
+	config.addBatchFlags(fs)
+	config.addReportFlags(fs)
+	config.addLegacyFlags(fs)
+
+	err := parseFlags(fs, args, true)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return validationError(err)
+	}
+
+	ctx := signalContext()
+	shutdown, err := initTracing(ctx)
+	if err != nil {
+		return fmt.Errorf("while setting up tracing: %s", err)
+	}
+	defer shutdown()
+
+	ctx, span := tracer.Start(ctx, "generate")
+	err = generate(ctx)
+	endSpan(span, err)
+
+	return err
+}
+
+func run(args []string) error {
+	log.SetOutput(os.Stderr)
+
+	if len(args) == 0 {
+		usage()
+		return validationError(fmt.Errorf("no command specified"))
+	}
+
+	if strings.HasPrefix(args[0], "-") {
+		return runLegacy(args)
+	}
+
+	if args[0] == "help" {
+		if len(args) > 1 && findCommand(args[1]) != nil {
+			return runCommand(findCommand(args[1]), []string{"--help"})
+		}
+		usage()
+		return nil
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		usage()
+		return validationError(fmt.Errorf("unknown command '%s'", args[0]))
+	}
+
+	return runCommand(cmd, args[1:])
+}
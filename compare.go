@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Items compared across clusters, besides bindings.
+const (
+	ItemServiceAccount = "service account"
+	ItemToken          = "token"
+)
+
+// bindingItem describes a binding of the team service account, e.g. "binding RoleBinding/team-aura to ClusterRole/edit".
+func bindingItem(kind, name, roleKind, roleName string) string {
+	return fmt.Sprintf("binding %s/%s to %s/%s", kind, name, roleKind, roleName)
+}
+
+// configuredItems returns the items the RBAC flags say the team service account should have.
+func configuredItems(serviceAccountName string) []string {
+	items := []string{ItemServiceAccount, ItemToken}
+	if config.CreateRole || len(config.Role) > 0 {
+		items = append(items, bindingItem("RoleBinding", serviceAccountName, "Role", config.roleName(serviceAccountName)))
+	}
+	if len(config.ClusterRole) > 0 {
+		kind := "RoleBinding"
+		if config.ClusterWide {
+			kind = "ClusterRoleBinding"
+		}
+		items = append(items, bindingItem(kind, config.clusterRoleBindingName(serviceAccountName), "ClusterRole", config.ClusterRole))
+	}
+	return items
+}
+
+// clusterItems returns the items found for the team service account in a single cluster: the
+// service account, its token, and the bindings of the service account.
+func clusterItems(ctx context.Context, cluster string) (map[string]bool, error) {
+	items := make(map[string]bool)
+
+	status, err := clusterStatus(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+	if status.State == "missing" {
+		return items, nil
+	}
+	items[ItemServiceAccount] = true
+	if status.State == "ok" {
+		items[ItemToken] = true
+	}
+
+	_, client, err := clusterClient(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+	namespace := config.serviceAccountNamespace(cluster)
+	serviceAccountName := ServiceAccountName(config.Team)
+
+	roleBindings, err := ServiceAccountRoleBindings(client, namespace, serviceAccountName)
+	if err != nil {
+		return nil, fmt.Errorf("while listing role bindings: %s", err)
+	}
+	for _, binding := range roleBindings {
+		items[bindingItem("RoleBinding", binding.Name, binding.RoleRef.Kind, binding.RoleRef.Name)] = true
+	}
+
+	// Listing cluster role bindings requires more permissions than teamconfig otherwise needs.
+	clusterRoleBindings, err := ServiceAccountClusterRoleBindings(client, namespace, serviceAccountName)
+	if errors.IsForbidden(err) {
+		log.Warnf("%s: not allowed to list cluster role bindings; comparing role bindings only", cluster)
+	} else if err != nil {
+		return nil, fmt.Errorf("while listing cluster role bindings: %s", err)
+	}
+	for _, binding := range clusterRoleBindings {
+		items[bindingItem("ClusterRoleBinding", binding.Name, binding.RoleRef.Kind, binding.RoleRef.Name)] = true
+	}
+
+	return items, nil
+}
+
+// Difference is an item that is missing from, or unexpectedly present in, a cluster.
+type Difference struct {
+	Cluster string
+	Item    string
+	Missing bool
+}
+
+func (d Difference) String() string {
+	if d.Missing {
+		return "missing " + d.Item
+	}
+	return "extra " + d.Item
+}
+
+// compareItems returns the differences between the items found in each cluster. An item is
+// expected in every cluster if it is configured with the RBAC flags, or if it is found in more
+// than half of the clusters. Clusters without items, i.e. nil, could not be inspected and are
+// left out.
+func compareItems(clusters []string, found []map[string]bool, configured []string) []Difference {
+	counts := make(map[string]int)
+	inspected := 0
+	for _, items := range found {
+		if items == nil {
+			continue
+		}
+		inspected++
+		for item := range items {
+			counts[item]++
+		}
+	}
+
+	expected := make(map[string]bool)
+	for _, item := range configured {
+		expected[item] = true
+	}
+	for item, count := range counts {
+		if count*2 > inspected {
+			expected[item] = true
+		}
+	}
+
+	all := make([]string, 0, len(counts))
+	for item := range expected {
+		all = append(all, item)
+	}
+	for item := range counts {
+		if !expected[item] {
+			all = append(all, item)
+		}
+	}
+	sort.Strings(all)
+
+	differences := make([]Difference, 0)
+	for i, cluster := range clusters {
+		if found[i] == nil {
+			continue
+		}
+		// Everything else is missing along with the service account, so only report that.
+		if expected[ItemServiceAccount] && !found[i][ItemServiceAccount] {
+			differences = append(differences, Difference{Cluster: cluster, Item: ItemServiceAccount, Missing: true})
+			continue
+		}
+		for _, item := range all {
+			if found[i][item] != expected[item] {
+				differences = append(differences, Difference{Cluster: cluster, Item: item, Missing: expected[item]})
+			}
+		}
+	}
+	return differences
+}
+
+// compare checks that the team service account, its token and its bindings are the same in
+// all clusters, and prints the differences to standard output. No changes are made to any cluster.
+func compare(ctx context.Context) error {
+	found := make([]map[string]bool, len(config.Clusters))
+	failed := make([]error, len(config.Clusters))
+
+	err := forEachCluster(ctx, func(ctx context.Context, i int, cluster string) error {
+		items, err := clusterItems(ctx, cluster)
+		found[i] = items
+		failed[i] = err
+		return err
+	})
+
+	differences := compareItems(config.Clusters, found, configuredItems(ServiceAccountName(config.Team)))
+
+	if err != nil || len(differences) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "CLUSTER\tDIFFERENCE\n")
+		for i, cluster := range config.Clusters {
+			if failed[i] != nil {
+				fmt.Fprintf(w, "%s\terror: %s\n", cluster, failed[i])
+			}
+		}
+		for _, difference := range differences {
+			fmt.Fprintf(w, "%s\t%s\n", difference.Cluster, difference)
+		}
+		w.Flush()
+	}
+
+	if err != nil {
+		return fmt.Errorf("exiting due to errors")
+	}
+	if len(differences) > 0 {
+		return fmt.Errorf("team %s differs between clusters in %d ways", config.Team, len(differences))
+	}
+
+	log.Infof("team %s is the same in all %d clusters", config.Team, len(config.Clusters))
+	return nil
+}
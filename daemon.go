@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/robfig/cron"
+	log "github.com/sirupsen/logrus"
+)
+
+// daemonTeams returns the teams to rotate: those listed in --teams-file, or all teams
+// with a service account in any of the clusters if --discover-teams is given.
+func daemonTeams(ctx context.Context) ([]string, error) {
+	if config.DiscoverTeams {
+		teams, err := discoverTeams(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("while discovering teams: %s", err)
+		}
+		return teams, nil
+	}
+	teams, err := ReadTeamsFile(config.TeamsFile)
+	if err != nil {
+		return nil, fmt.Errorf("while reading teams: %s", err)
+	}
+	return teams, nil
+}
+
+// clusterErrors returns the last error in each cluster in the report, for health checks.
+func clusterErrors(report *Report) map[string]error {
+	clusters := make(map[string]error)
+	for _, cluster := range config.Clusters {
+		clusters[cluster] = nil
+	}
+	for _, team := range report.Teams {
+		for _, result := range team.Clusters {
+			if len(result.Error) > 0 {
+				clusters[result.Cluster] = fmt.Errorf("team %s: %s", team.Team, result.Error)
+			}
+		}
+	}
+	return clusters
+}
+
+// scheduledRotation invalidates expired previous tokens, rotates all teams once, and reports the outcome.
+func scheduledRotation(ctx context.Context, health *Health) {
+	report := NewReport(ctx)
+
+	teams, err := daemonTeams(ctx)
+	if err == nil {
+		finishExpiredRotations(ctx, teams)
+		log.Infof("rotating %d teams", len(teams))
+		err = generateTeamList(ctx, teams, report)
+	}
+	report.Finish()
+
+	writeReport(report, true)
+
+	if err != nil {
+		log.Errorf("scheduled rotation: %s", err)
+	} else {
+		log.Infof("scheduled rotation of %d teams complete", len(teams))
+	}
+	health.Completed(clusterErrors(report))
+}
+
+// validateRotateDaemon checks the configuration before the daemon starts.
+func validateRotateDaemon() (cron.Schedule, error) {
+	if len(config.Schedule) == 0 {
+		return nil, fmt.Errorf("--schedule must be specified")
+	}
+	schedule, err := cron.ParseStandard(config.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --schedule: %s", err)
+	}
+	hasTeamsFile := len(config.TeamsFile) > 0
+	if hasTeamsFile == config.DiscoverTeams {
+		return nil, fmt.Errorf("exactly one of --teams-file and --discover-teams must be specified")
+	}
+	if err := validateGenerate(); err != nil {
+		return nil, err
+	}
+	if err := validatePerTeamSinks(); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// rotateDaemon rotates teams on --schedule until interrupted.
+func rotateDaemon(ctx context.Context) error {
+	config.Rotate = true
+
+	schedule, err := validateRotateDaemon()
+	if err != nil {
+		return validationError(err)
+	}
+
+	if err := openAuditor(); err != nil {
+		return err
+	}
+	if auditor != nil {
+		defer auditor.Close()
+	}
+	if err := openNotifiers(); err != nil {
+		return err
+	}
+	defer closeNotifiers()
+
+	startDebugServer(ctx)
+
+	health := NewHealth()
+	mux := http.NewServeMux()
+	health.Register(mux)
+	server := &http.Server{
+		Addr:    config.ListenAddress,
+		Handler: mux,
+	}
+	go func() {
+		log.Infof("serving health checks on %s", config.ListenAddress)
+		err := server.ListenAndServe()
+		if err != http.ErrServerClosed {
+			log.Errorf("while serving health checks: %s", err)
+		}
+	}()
+	defer server.Shutdown(context.Background())
+
+	for {
+		next := schedule.Next(time.Now())
+		log.Infof("next rotation at %s", next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-timer.C:
+			scheduledRotation(ctx, health)
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+	}
+}
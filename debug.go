@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// startDebugServer serves pprof profiles and Go runtime metrics on --debug-address until the
+// context is done. It does nothing unless --debug-address is set, as profiles may reveal secrets.
+func startDebugServer(ctx context.Context) {
+	if len(config.DebugAddress) == 0 {
+		return
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGoCollector())
+	registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	server := &http.Server{
+		Addr:    config.DebugAddress,
+		Handler: mux,
+	}
+
+	go func() {
+		log.Infof("serving debug endpoints on %s", config.DebugAddress)
+		err := server.ListenAndServe()
+		if err != http.ErrServerClosed {
+			log.Errorf("while serving debug endpoints: %s", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// Results of a single doctor check.
+const (
+	CheckPassed  = "ok"
+	CheckSkipped = "-"
+)
+
+// ClusterDiagnosis holds the outcome of each doctor check in a single cluster.
+type ClusterDiagnosis struct {
+	Context     string
+	API         string
+	Permissions string
+
+	// Failed is set if any check failed.
+	Failed bool
+}
+
+// fail records the outcome of a failed check.
+func (d *ClusterDiagnosis) fail(check *string, format string, args ...interface{}) *ClusterDiagnosis {
+	*check = fmt.Sprintf(format, args...)
+	d.Failed = true
+	return d
+}
+
+// diagnoseCluster checks that a cluster is configured, that its API server is reachable,
+// and that we are allowed to manage team service accounts in it. Later checks are skipped
+// once a check has failed.
+func diagnoseCluster(ctx context.Context, cluster string, contexts []string) *ClusterDiagnosis {
+	diagnosis := &ClusterDiagnosis{
+		Context:     CheckPassed,
+		API:         CheckSkipped,
+		Permissions: CheckSkipped,
+	}
+
+	if cluster != config.InCluster && !contains(contexts, cluster) {
+		return diagnosis.fail(&diagnosis.Context, "not found in Kubeconfig")
+	}
+
+	_, client, err := clusterClient(ctx, cluster)
+	if err != nil {
+		return diagnosis.fail(&diagnosis.Context, "error: %s", err)
+	}
+
+	version, err := client.Discovery().ServerVersion()
+	if err != nil {
+		return diagnosis.fail(&diagnosis.API, "error: %s", err)
+	}
+	diagnosis.API = fmt.Sprintf("%s (%s)", CheckPassed, version.GitVersion)
+
+	missing, err := MissingPermissions(client, config.serviceAccountNamespace(cluster), ManagePermissions)
+	if err != nil {
+		return diagnosis.fail(&diagnosis.Permissions, "error: %s", err)
+	}
+	if len(missing) > 0 {
+		return diagnosis.fail(&diagnosis.Permissions, "missing: %s", joinPermissions(missing))
+	}
+	diagnosis.Permissions = CheckPassed
+
+	return diagnosis
+}
+
+// doctor checks every cluster before running a command that makes changes,
+// and prints the outcome of each check to standard output.
+func doctor(ctx context.Context) error {
+	contexts, err := KubeconfigContexts(config.Kubeconfig)
+	if err != nil && len(config.InCluster) == 0 {
+		return fmt.Errorf("while reading contexts: %s", err)
+	}
+
+	diagnoses := make([]*ClusterDiagnosis, len(config.Clusters))
+	forEachCluster(ctx, func(ctx context.Context, i int, cluster string) error {
+		diagnoses[i] = diagnoseCluster(ctx, cluster, contexts)
+		return nil
+	})
+
+	failures := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "CLUSTER\tNAMESPACE\tCONTEXT\tAPI\tPERMISSIONS\n")
+	for i, cluster := range config.Clusters {
+		diagnosis := diagnoses[i]
+		if diagnosis == nil {
+			diagnosis = &ClusterDiagnosis{Context: ActionSkipped, API: CheckSkipped, Permissions: CheckSkipped, Failed: true}
+		}
+		if diagnosis.Failed {
+			failures++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			cluster,
+			config.serviceAccountNamespace(cluster),
+			diagnosis.Context,
+			diagnosis.API,
+			diagnosis.Permissions,
+		)
+	}
+	w.Flush()
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d clusters failed checks", failures, len(config.Clusters))
+	}
+
+	return nil
+}
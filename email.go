@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+// EmailConfig configures emailing teams when their credentials are rotated.
+type EmailConfig struct {
+	SMTPServer      string
+	From            string
+	Addresses       []string
+	AddressesFile   string
+	AddressTemplate string
+}
+
+// EmailNotifier emails the owners of a team when its credentials are rotated, telling them
+// where to fetch the new Kubeconfig file. The credentials themselves are never sent.
+type EmailNotifier struct {
+	Server   string
+	From     string
+	Username string
+	Password string
+
+	// Addresses maps teams to the email addresses of their owners.
+	Addresses map[string][]string
+
+	// Template gives the address of teams not in Addresses, with {team} replaced by the team name.
+	Template string
+}
+
+// readTeamEmails reads team email addresses from a YAML file mapping teams to lists of addresses,
+// and from team=address pairs given as flags.
+func readTeamEmails(path string, pairs []string) (map[string][]string, error) {
+	addresses := make(map[string][]string)
+
+	if len(path) > 0 {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		err = yaml.Unmarshal(data, &addresses)
+		if err != nil {
+			return nil, fmt.Errorf("while parsing %s: %s", path, err)
+		}
+	}
+
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || !strings.Contains(parts[1], "@") {
+			return nil, fmt.Errorf("invalid --team-email '%s'; must be team=address", pair)
+		}
+		addresses[parts[0]] = append(addresses[parts[0]], parts[1])
+	}
+
+	return addresses, nil
+}
+
+func NewEmailNotifier(cfg EmailConfig) (*EmailNotifier, error) {
+	if len(cfg.From) == 0 {
+		return nil, fmt.Errorf("--smtp-server requires --smtp-from")
+	}
+	if len(cfg.Addresses) == 0 && len(cfg.AddressesFile) == 0 && len(cfg.AddressTemplate) == 0 {
+		return nil, fmt.Errorf("--smtp-server requires --team-email, --team-emails-file or --team-email-template")
+	}
+	addresses, err := readTeamEmails(cfg.AddressesFile, cfg.Addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EmailNotifier{
+		Server:    cfg.SMTPServer,
+		From:      cfg.From,
+		Username:  os.Getenv("SMTP_USERNAME"),
+		Password:  os.Getenv("SMTP_PASSWORD"),
+		Addresses: addresses,
+		Template:  cfg.AddressTemplate,
+	}, nil
+}
+
+// recipients returns the email addresses of the owners of a team.
+func (e *EmailNotifier) recipients(team string) []string {
+	if addresses, ok := e.Addresses[team]; ok {
+		return addresses
+	}
+	if len(e.Template) > 0 {
+		return []string{strings.Replace(e.Template, "{team}", team, -1)}
+	}
+	return nil
+}
+
+// message returns the email telling the team that its credentials were rotated, and whether
+// the previous tokens still work.
+func (e *EmailNotifier) message(n *Notification, to []string) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", e.From)
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(buf, "Subject: Kubernetes credentials of team %s were rotated\r\n", n.Team)
+	fmt.Fprintf(buf, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	fmt.Fprintf(buf, "The Kubernetes credentials of team %s were rotated by %s at %s in the following clusters:\r\n\r\n",
+		n.Team, n.Operator, n.Time.Format(time.RFC3339))
+	for _, cluster := range n.Clusters {
+		fmt.Fprintf(buf, "  - %s\r\n", cluster)
+	}
+	switch {
+	case n.PreviousTokensExpire != nil:
+		fmt.Fprintf(buf, "\r\nThe previous tokens keep working in these clusters until %s, and stop working shortly after.\r\n", n.PreviousTokensExpire.Format(time.RFC3339))
+	case n.PreviousTokensValid:
+		fmt.Fprintf(buf, "\r\nThe previous tokens keep working in these clusters until the platform team finishes the rotation, so switch to the new Kubeconfig file soon.\r\n")
+	default:
+		fmt.Fprintf(buf, "\r\nThe previous tokens no longer work in these clusters.\r\n")
+	}
+
+	locations := credentialLocations(n.Team)
+	if len(locations) > 0 {
+		fmt.Fprintf(buf, "Fetch the new Kubeconfig file from:\r\n\r\n")
+		for _, location := range locations {
+			fmt.Fprintf(buf, "  - %s\r\n", location)
+		}
+	} else {
+		fmt.Fprintf(buf, "Ask the platform team for the new Kubeconfig file.\r\n")
+	}
+
+	return buf.Bytes()
+}
+
+// send delivers a message over SMTP, using STARTTLS if the server supports it.
+func (e *EmailNotifier) send(ctx context.Context, to []string, message []byte) error {
+	host, _, err := net.SplitHostPort(e.Server)
+	if err != nil {
+		return fmt.Errorf("invalid --smtp-server: %s", err)
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", e.Server)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(time.Minute))
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("while starting TLS: %s", err)
+		}
+	}
+	if len(e.Username) > 0 {
+		if err := client.Auth(smtp.PlainAuth("", e.Username, e.Password, host)); err != nil {
+			return fmt.Errorf("while authenticating: %s", err)
+		}
+	}
+
+	if err := client.Mail(e.From); err != nil {
+		return err
+	}
+	for _, address := range to {
+		if err := client.Rcpt(address); err != nil {
+			return fmt.Errorf("recipient %s: %s", address, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Notify emails the owners of the team if its credentials were rotated in any cluster.
+func (e *EmailNotifier) Notify(ctx context.Context, n *Notification) error {
+	if n.Action != "rotate" || len(n.Clusters) == 0 {
+		return nil
+	}
+	to := e.recipients(n.Team)
+	if len(to) == 0 {
+		log.Warnf("team %s: no email address configured; owners were not told about the rotation", n.Team)
+		return nil
+	}
+
+	err := e.send(ctx, to, e.message(n, to))
+	if err != nil {
+		return err
+	}
+	log.Infof("team %s: rotation notice emailed to %s", n.Team, strings.Join(to, ", "))
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailMessage(t *testing.T) {
+	rotated := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	expires := rotated.Add(24 * time.Hour)
+
+	for _, test := range []struct {
+		name       string
+		valid      bool
+		expires    *time.Time
+		expected   string
+		unexpected string
+	}{
+		{
+			name:       "rotated",
+			expected:   "The previous tokens no longer work in these clusters.",
+			unexpected: "keep working",
+		},
+		{
+			name:       "two-phase",
+			valid:      true,
+			expected:   "The previous tokens keep working in these clusters until the platform team finishes the rotation",
+			unexpected: "no longer work",
+		},
+		{
+			name:       "grace period",
+			valid:      true,
+			expires:    &expires,
+			expected:   "The previous tokens keep working in these clusters until 2018-10-02T12:00:00Z",
+			unexpected: "no longer work",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			notifier := &EmailNotifier{From: "platform@example.com"}
+			n := &Notification{
+				Time:                 rotated,
+				Action:               "rotate",
+				Team:                 "aura",
+				Operator:             "alice",
+				Clusters:             []string{"dev-fss"},
+				PreviousTokensValid:  test.valid,
+				PreviousTokensExpire: test.expires,
+			}
+			message := string(notifier.message(n, []string{"aura@example.com"}))
+			if !strings.Contains(message, test.expected) {
+				t.Errorf("message does not contain %q:\n%s", test.expected, message)
+			}
+			if strings.Contains(message, test.unexpected) {
+				t.Errorf("message contains %q:\n%s", test.unexpected, message)
+			}
+		})
+	}
+}
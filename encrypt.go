@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"filippo.io/age/armor"
+)
+
+// EncryptGPG encrypts data for a recipient using the gpg binary. The recipient is either
+// a path to a file containing the public key, or a key ID or user ID in the local keyring.
+// The output is ASCII armored.
+func EncryptGPG(data []byte, recipient string) ([]byte, error) {
+	args := []string{"--batch", "--yes", "--armor", "--trust-model", "always", "--encrypt"}
+	if _, err := os.Stat(recipient); err == nil {
+		args = append(args, "--recipient-file", recipient)
+	} else {
+		args = append(args, "--recipient", recipient)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("gpg", args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("gpg: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// ParseAgeRecipient parses an age recipient, which is either a native X25519
+// recipient starting with "age1", or an SSH public key.
+func ParseAgeRecipient(recipient string) (age.Recipient, error) {
+	if strings.HasPrefix(recipient, "age1") {
+		return age.ParseX25519Recipient(recipient)
+	}
+	if strings.HasPrefix(recipient, "ssh-") {
+		return agessh.ParseRecipient(recipient)
+	}
+	return nil, fmt.Errorf("unknown age recipient type: '%s'", recipient)
+}
+
+// EncryptAge encrypts data for one or more age recipients. The output is ASCII armored.
+func EncryptAge(data []byte, recipients []string) ([]byte, error) {
+	parsed := make([]age.Recipient, 0, len(recipients))
+	for _, recipient := range recipients {
+		r, err := ParseAgeRecipient(recipient)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, r)
+	}
+
+	var buf bytes.Buffer
+	armored := armor.NewWriter(&buf)
+	encrypted, err := age.Encrypt(armored, parsed...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = encrypted.Write(data); err != nil {
+		return nil, err
+	}
+	if err = encrypted.Close(); err != nil {
+		return nil, err
+	}
+	if err = armored.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// SOPSKeys are the master keys used to encrypt a SOPS document.
+type SOPSKeys struct {
+	KMS     []string
+	GCPKMS  []string
+	AzureKV []string
+	Age     []string
+	PGP     []string
+}
+
+func (k SOPSKeys) empty() bool {
+	return len(k.KMS)+len(k.GCPKMS)+len(k.AzureKV)+len(k.Age)+len(k.PGP) == 0
+}
+
+// EncryptSOPS encrypts a YAML or JSON document with SOPS, using the sops binary. documentType is
+// "yaml" or "json", and the result is a document of the same type. It can be committed to a
+// repository and decrypted with `sops --decrypt` by anyone holding one of the keys.
+func EncryptSOPS(data []byte, keys SOPSKeys, documentType string) ([]byte, error) {
+	// sops reads its input from a file; keep it in a private directory and remove it right away.
+	dir, err := ioutil.TempDir("", "teamconfig-sops")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "kubeconfig."+documentType)
+	err = ioutil.WriteFile(path, data, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"--encrypt", "--input-type", documentType, "--output-type", documentType}
+	for _, key := range []struct {
+		flag   string
+		values []string
+	}{
+		{"--kms", keys.KMS},
+		{"--gcp-kms", keys.GCPKMS},
+		{"--azure-kv", keys.AzureKV},
+		{"--age", keys.Age},
+		{"--pgp", keys.PGP},
+	} {
+		if len(key.values) > 0 {
+			args = append(args, key.flag, strings.Join(key.values, ","))
+		}
+	}
+	args = append(args, path)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("sops", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("sops: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// encryptOutput encrypts a generated file if encryption is configured.
+func encryptOutput(data []byte) ([]byte, error) {
+	switch {
+	case len(config.EncryptGPG) > 0:
+		return EncryptGPG(data, config.EncryptGPG)
+	case len(config.EncryptAge) > 0:
+		return EncryptAge(data, config.EncryptAge)
+	case config.EncryptSOPS:
+		return EncryptSOPS(data, config.SOPSKeys, sopsDocumentType())
+	}
+	return data, nil
+}
+
+// sopsDocumentType returns the SOPS document type of generated files. sops --decrypt infers it
+// from the file extension, so JSON formats must stay JSON when encrypted.
+func sopsDocumentType() string {
+	if format, err := outputFormat(); err == nil && format.Extension == ".json" {
+		return "json"
+	}
+	return "yaml"
+}
+
+// outputExtension returns the file extension of generated files.
+func outputExtension() string {
+	extension := ".yaml"
+	if format, err := outputFormat(); err == nil {
+		extension = format.Extension
+	}
+	switch {
+	case len(config.EncryptGPG) > 0:
+		return extension + ".asc"
+	case len(config.EncryptAge) > 0:
+		return extension + ".age"
+	case config.EncryptSOPS:
+		return ".enc" + extension
+	}
+	return extension
+}
+
+// validateEncryption checks the encryption flags before any changes are made.
+func validateEncryption() error {
+	modes := 0
+	for _, enabled := range []bool{len(config.EncryptGPG) > 0, len(config.EncryptAge) > 0, config.EncryptSOPS} {
+		if enabled {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return fmt.Errorf("--encrypt-gpg, --encrypt-age and --encrypt-sops are mutually exclusive")
+	}
+	if config.EncryptSOPS && config.SOPSKeys.empty() {
+		return fmt.Errorf("--encrypt-sops requires at least one of --sops-kms, --sops-gcp-kms, --sops-azure-kv, --sops-age or --sops-pgp")
+	}
+	// SOPS only encrypts structured documents, and JSON is read as YAML
+	if format, err := outputFormat(); config.EncryptSOPS && err == nil && format.Extension != ".yaml" && format.Extension != ".json" {
+		return fmt.Errorf("--output-format %s cannot be encrypted with --encrypt-sops; use --encrypt-gpg or --encrypt-age", format.Name)
+	}
+	for _, recipient := range config.EncryptAge {
+		if _, err := ParseAgeRecipient(recipient); err != nil {
+			return fmt.Errorf("invalid --encrypt-age recipient: %s", err)
+		}
+	}
+	return nil
+}
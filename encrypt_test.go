@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/ssh"
+)
+
+func ageRecipient(t *testing.T) string {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity.Recipient().String()
+}
+
+func sshRecipient(t *testing.T) string {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+func TestParseAgeRecipient(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		recipient string
+		err       bool
+	}{
+		{"x25519", ageRecipient(t), false},
+		{"ssh", sshRecipient(t), false},
+		{"invalid x25519", "age1invalid", true},
+		{"invalid ssh", "ssh-ed25519 invalid", true},
+		{"unknown", "AGE-SECRET-KEY-1", true},
+		{"empty", "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseAgeRecipient(test.recipient)
+			if (err != nil) != test.err {
+				t.Errorf("ParseAgeRecipient() error = %v, want error %t", err, test.err)
+			}
+		})
+	}
+}
+
+func TestEncryptAge(t *testing.T) {
+	output, err := EncryptAge([]byte("apiVersion: v1\n"), []string{ageRecipient(t), sshRecipient(t)})
+	if err != nil {
+		t.Fatalf("EncryptAge() error: %s", err)
+	}
+	if !strings.HasPrefix(string(output), "-----BEGIN AGE ENCRYPTED FILE-----") {
+		t.Errorf("EncryptAge() output is not armored: %q", output)
+	}
+	if _, err := EncryptAge([]byte("apiVersion: v1\n"), []string{"unknown"}); err == nil {
+		t.Errorf("EncryptAge() accepted an unknown recipient")
+	}
+}
+
+func TestOutputExtension(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		format   string
+		gpg      string
+		age      []string
+		sops     bool
+		expected string
+	}{
+		{name: "kubeconfig", format: "kubeconfig", expected: ".yaml"},
+		{name: "terraform", format: "terraform", expected: ".json"},
+		{name: "token", format: "token", expected: ".token"},
+		{name: "unknown format", format: "unknown", expected: ".yaml"},
+		{name: "gpg", format: "kubeconfig", gpg: "team@example.com", expected: ".yaml.asc"},
+		{name: "age", format: "env", age: []string{"age1"}, expected: ".env.age"},
+		{name: "sops", format: "kubeconfig", sops: true, expected: ".enc.yaml"},
+		{name: "sops json", format: "terraform", sops: true, expected: ".enc.json"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := DefaultConfig()
+			c.OutputFormat = test.format
+			c.EncryptGPG = test.gpg
+			c.EncryptAge = test.age
+			c.EncryptSOPS = test.sops
+			setConfig(t, c)
+
+			if extension := outputExtension(); extension != test.expected {
+				t.Errorf("outputExtension() = %s, want %s", extension, test.expected)
+			}
+		})
+	}
+}
+
+func TestValidateEncryption(t *testing.T) {
+	recipient := ageRecipient(t)
+	keys := SOPSKeys{Age: []string{recipient}}
+
+	for _, test := range []struct {
+		name   string
+		format string
+		gpg    string
+		age    []string
+		sops   bool
+		keys   SOPSKeys
+		err    bool
+	}{
+		{name: "none", format: "kubeconfig"},
+		{name: "gpg", format: "kubeconfig", gpg: "team@example.com"},
+		{name: "gpg raw", format: "raw", gpg: "team@example.com"},
+		{name: "age", format: "kubeconfig", age: []string{recipient}},
+		{name: "age raw", format: "raw", age: []string{recipient}},
+		{name: "invalid age recipient", format: "kubeconfig", age: []string{"age1invalid"}, err: true},
+		{name: "gpg and age", format: "kubeconfig", gpg: "team@example.com", age: []string{recipient}, err: true},
+		{name: "sops", format: "kubeconfig", sops: true, keys: keys},
+		{name: "sops helm-values", format: "helm-values", sops: true, keys: keys},
+		{name: "sops terraform", format: "terraform", sops: true, keys: keys},
+		{name: "sops without keys", format: "kubeconfig", sops: true, err: true},
+		{name: "age and sops", format: "kubeconfig", age: []string{recipient}, sops: true, keys: keys, err: true},
+		{name: "sops env", format: "env", sops: true, keys: keys, err: true},
+		{name: "sops token", format: "token", sops: true, keys: keys, err: true},
+		{name: "sops raw", format: "raw", sops: true, keys: keys, err: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := DefaultConfig()
+			c.OutputFormat = test.format
+			c.EncryptGPG = test.gpg
+			c.EncryptAge = test.age
+			c.EncryptSOPS = test.sops
+			c.SOPSKeys = test.keys
+			setConfig(t, c)
+
+			err := validateEncryption()
+			if (err != nil) != test.err {
+				t.Errorf("validateEncryption() error = %v, want error %t", err, test.err)
+			}
+		})
+	}
+}
+
+// fakeSOPS puts a sops binary on the PATH that prints its arguments and input file.
+func fakeSOPS(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\nfor arg in \"$@\"; do echo \"$arg\"; done\n"
+	err := ioutil.WriteFile(filepath.Join(dir, "sops"), []byte(script), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestEncryptSOPS(t *testing.T) {
+	fakeSOPS(t)
+	keys := SOPSKeys{GCPKMS: []string{"projects/aura/cryptoKeys/sops"}}
+
+	for _, test := range []struct {
+		format   string
+		expected string
+	}{
+		{"kubeconfig", "--input-type yaml --output-type yaml --gcp-kms projects/aura/cryptoKeys/sops kubeconfig.yaml"},
+		{"helm-values", "--input-type yaml --output-type yaml --gcp-kms projects/aura/cryptoKeys/sops kubeconfig.yaml"},
+		{"terraform", "--input-type json --output-type json --gcp-kms projects/aura/cryptoKeys/sops kubeconfig.json"},
+	} {
+		t.Run(test.format, func(t *testing.T) {
+			c := DefaultConfig()
+			c.OutputFormat = test.format
+			c.EncryptSOPS = true
+			c.SOPSKeys = keys
+			setConfig(t, c)
+
+			output, err := encryptOutput([]byte("{}"))
+			if err != nil {
+				t.Fatalf("encryptOutput() error: %s", err)
+			}
+			args := strings.Fields(string(output))
+			args[len(args)-1] = filepath.Base(args[len(args)-1])
+			if got := strings.Join(args[1:], " "); got != test.expected {
+				t.Errorf("sops arguments = %s, want %s", got, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ExecSink runs external programs to distribute credentials. Each program receives the
+// unencrypted Kubeconfig file on standard input, and the team and clusters in the
+// TEAMCONFIG_TEAM, TEAMCONFIG_SERVICE_ACCOUNT and TEAMCONFIG_CLUSTERS environment variables.
+// Output from the programs is written to standard error.
+type ExecSink struct {
+	Commands []string
+}
+
+func (s *ExecSink) Write(ctx context.Context, team string, kubeconfig []byte, credentials []*Credentials) error {
+	clusters := make([]string, 0, len(credentials))
+	for _, c := range credentials {
+		if c != nil {
+			clusters = append(clusters, c.Cluster)
+		}
+	}
+
+	env := append(os.Environ(),
+		"TEAMCONFIG_TEAM="+team,
+		"TEAMCONFIG_SERVICE_ACCOUNT="+ServiceAccountName(team),
+		"TEAMCONFIG_CLUSTERS="+strings.Join(clusters, ","),
+	)
+
+	for _, command := range s.Commands {
+		log.Debugf("running %s for team %s", command, team)
+
+		cmd := exec.CommandContext(ctx, command)
+		cmd.Stdin = bytes.NewReader(kubeconfig)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		cmd.Env = env
+
+		err := cmd.Run()
+		if err != nil {
+			return fmt.Errorf("%s: %s", command, err)
+		}
+
+		log.Infof("credentials passed to %s", command)
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// Exit codes, so that wrapper scripts can tell failures apart.
+const (
+	// ExitFailure means that the run failed in every cluster, or for reasons not covered below.
+	ExitFailure = 1
+
+	// ExitValidation means that the command line was invalid. No changes were made.
+	ExitValidation = 2
+
+	// ExitPartialFailure means that the run succeeded in some clusters, but failed in others.
+	// Running the same command again retries the failed clusters.
+	ExitPartialFailure = 3
+
+	// ExitAuthFailure means that every cluster failed, and at least one of them rejected
+	// teamconfig's credentials or permissions.
+	ExitAuthFailure = 4
+)
+
+// ExitError is an error that causes teamconfig to exit with a specific exit code.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+// validationError marks an error as caused by invalid flags or arguments.
+func validationError(err error) error {
+	return &ExitError{Code: ExitValidation, Err: err}
+}
+
+// exitCode returns the process exit code for an error returned by run.
+func exitCode(err error) int {
+	if e, ok := err.(*ExitError); ok {
+		return e.Code
+	}
+	return ExitFailure
+}
+
+type authTrackerKey struct{}
+
+// authTracker records whether any Kubernetes API request was rejected as unauthorized or forbidden.
+type authTracker struct {
+	rejected int32
+}
+
+// withAuthTracker returns a context in which rejected Kubernetes API requests are recorded.
+func withAuthTracker(ctx context.Context) (context.Context, *authTracker) {
+	tracker := &authTracker{}
+	return context.WithValue(ctx, authTrackerKey{}, tracker), tracker
+}
+
+// observeAuth records the response to a request made with ctx.
+func observeAuth(ctx context.Context, resp *http.Response) {
+	tracker, ok := ctx.Value(authTrackerKey{}).(*authTracker)
+	if !ok {
+		return
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		atomic.StoreInt32(&tracker.rejected, 1)
+	}
+}
+
+// rejectAuth records that ctx lacks the permissions it needs, without a request being rejected.
+func rejectAuth(ctx context.Context) {
+	tracker, ok := ctx.Value(authTrackerKey{}).(*authTracker)
+	if ok {
+		atomic.StoreInt32(&tracker.rejected, 1)
+	}
+}
+
+func (t *authTracker) Rejected() bool {
+	return atomic.LoadInt32(&t.rejected) == 1
+}
+
+// ExitCode classifies the outcome of a failed run. Skipped teams have no clusters, so only the
+// teams that were processed count.
+func (r *Report) ExitCode() int {
+	succeeded, failed, authFailed := 0, 0, 0
+	for _, team := range r.Teams {
+		for _, c := range team.Clusters {
+			switch {
+			case c.AuthFailure:
+				authFailed++
+				failed++
+			case len(c.Error) > 0 || c.Action == ActionSkipped:
+				failed++
+			default:
+				succeeded++
+			}
+		}
+	}
+
+	switch {
+	case succeeded > 0 && failed > 0:
+		return ExitPartialFailure
+	case succeeded == 0 && authFailed > 0:
+		return ExitAuthFailure
+	default:
+		return ExitFailure
+	}
+}
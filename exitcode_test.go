@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestExitCode(t *testing.T) {
+	succeeded := &ClusterResult{Action: ActionRotated}
+	failed := &ClusterResult{Action: ActionRotated, Error: "timed out"}
+	rejected := &ClusterResult{Error: "forbidden", AuthFailure: true}
+	skippedCluster := &ClusterResult{Action: ActionSkipped}
+
+	for _, test := range []struct {
+		name  string
+		teams []*TeamReport
+		want  int
+	}{
+		{"partial failure", []*TeamReport{
+			{Team: "aura", Clusters: []*ClusterResult{succeeded, failed}},
+		}, ExitPartialFailure},
+		{"halted after failure", []*TeamReport{
+			{Team: "aura", Clusters: []*ClusterResult{failed}},
+			{Team: "bris", Skipped: true},
+		}, ExitFailure},
+		{"halted after partial failure", []*TeamReport{
+			{Team: "aura", Clusters: []*ClusterResult{succeeded}},
+			{Team: "bris", Clusters: []*ClusterResult{failed}},
+			{Team: "tbd", Skipped: true},
+		}, ExitPartialFailure},
+		{"skipped cluster", []*TeamReport{
+			{Team: "aura", Clusters: []*ClusterResult{succeeded, skippedCluster}},
+		}, ExitPartialFailure},
+		{"auth failure", []*TeamReport{
+			{Team: "aura", Clusters: []*ClusterResult{rejected}},
+			{Team: "bris", Skipped: true},
+		}, ExitAuthFailure},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			report := &Report{Teams: test.teams}
+			if got := report.ExitCode(); got != test.want {
+				t.Errorf("ExitCode() = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Where the expiry of a team credential was found.
+const (
+	ExpirySourceToken      = "token"
+	ExpirySourceAnnotation = "annotation"
+	ExpirySourcePolicy     = "policy"
+)
+
+// ExpiringCredential is a team credential that expires within the --within window.
+type ExpiringCredential struct {
+	Cluster        string    `json:"cluster"`
+	Namespace      string    `json:"namespace"`
+	Team           string    `json:"team"`
+	ServiceAccount string    `json:"serviceAccount"`
+	Expires        time.Time `json:"expires"`
+	Source         string    `json:"source"`
+}
+
+// credentialExpiry returns when the credentials of a team service account expire, and how this
+// was determined: from the exp claim of its token secret, from the expiry recorded when a token
+// was last minted, or from its last rotation and --max-age. The zero time is returned if the
+// expiry is unknown.
+func credentialExpiry(client kubernetes.Interface, serviceAccount v1.ServiceAccount) (time.Time, string) {
+	secret, err := ServiceAccountSecret(client, serviceAccount)
+	if err == nil {
+		claims, err := ParseTokenClaims(string(secret.Data["token"]))
+		if err == nil && !claims.ExpiresAt().IsZero() {
+			return claims.ExpiresAt(), ExpirySourceToken
+		}
+	}
+
+	if expires := annotatedTime(serviceAccount, TokenExpiresAnnotation); !expires.IsZero() {
+		return expires, ExpirySourceAnnotation
+	}
+
+	if config.MaxTokenAge > 0 {
+		return LastRotated(serviceAccount).Add(config.MaxTokenAge), ExpirySourcePolicy
+	}
+
+	return time.Time{}, ""
+}
+
+// scanExpiring finds all team credentials in a cluster that expire before the deadline.
+func scanExpiring(ctx context.Context, cluster string, deadline time.Time) ([]*ExpiringCredential, error) {
+	_, client, err := clusterClient(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceAccounts, err := ListServiceAccounts(client, listNamespace(cluster))
+	if err != nil {
+		return nil, fmt.Errorf("while listing service accounts: %s", err)
+	}
+
+	expiring := make([]*ExpiringCredential, 0)
+	for _, serviceAccount := range serviceAccounts {
+		if !isTeamServiceAccount(serviceAccount) {
+			continue
+		}
+		expires, source := credentialExpiry(client, serviceAccount)
+		if expires.IsZero() || expires.After(deadline) {
+			continue
+		}
+		expiring = append(expiring, &ExpiringCredential{
+			Cluster:        cluster,
+			Namespace:      serviceAccount.Namespace,
+			Team:           serviceAccountTeam(serviceAccount),
+			ServiceAccount: serviceAccount.Name,
+			Expires:        expires,
+			Source:         source,
+		})
+	}
+
+	return expiring, nil
+}
+
+// expiring prints all team credentials in all clusters that expire within --within,
+// soonest first, as a table or as JSON.
+func expiring(ctx context.Context) error {
+	deadline := time.Now().Add(config.ExpiringWithin)
+	found := make([][]*ExpiringCredential, len(config.Clusters))
+
+	err := forEachCluster(ctx, func(ctx context.Context, i int, cluster string) error {
+		var err error
+		found[i], err = scanExpiring(ctx, cluster, deadline)
+		return err
+	})
+
+	credentials := make([]*ExpiringCredential, 0)
+	for _, clusterCredentials := range found {
+		credentials = append(credentials, clusterCredentials...)
+	}
+	sort.SliceStable(credentials, func(a, b int) bool {
+		return credentials[a].Expires.Before(credentials[b].Expires)
+	})
+
+	if config.OutputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if encodeErr := encoder.Encode(credentials); encodeErr != nil {
+			return encodeErr
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "CLUSTER\tNAMESPACE\tTEAM\tSERVICE ACCOUNT\tEXPIRES\tEXPIRES IN\tSOURCE\n")
+		for _, credential := range credentials {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				credential.Cluster,
+				credential.Namespace,
+				credential.Team,
+				credential.ServiceAccount,
+				formatTime(credential.Expires),
+				formatRemaining(credential.Expires),
+				credential.Source,
+			)
+		}
+		w.Flush()
+	}
+
+	if err != nil {
+		return fmt.Errorf("exiting due to errors")
+	}
+
+	return nil
+}
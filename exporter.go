@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	tokenAgeDesc = prometheus.NewDesc(
+		"teamconfig_token_age_seconds",
+		"Time since the token of the team service account was created or last rotated.",
+		[]string{"cluster", "namespace", "team"}, nil,
+	)
+	serviceAccountExistsDesc = prometheus.NewDesc(
+		"teamconfig_serviceaccount_exists",
+		"Whether the team service account exists. Teams listed in --teams-file are reported as 0 when missing.",
+		[]string{"cluster", "namespace", "team"}, nil,
+	)
+	scanSuccessDesc = prometheus.NewDesc(
+		"teamconfig_scan_success",
+		"Whether the last scan of the cluster succeeded.",
+		[]string{"cluster"}, nil,
+	)
+	lastScanDesc = prometheus.NewDesc(
+		"teamconfig_last_scan_timestamp_seconds",
+		"When the cluster was last scanned.",
+		[]string{"cluster"}, nil,
+	)
+)
+
+// teamToken is the state of a team service account found during a scan.
+type teamToken struct {
+	Namespace string
+	Team      string
+	Created   time.Time
+}
+
+// clusterScan holds the result of the last scan of a cluster.
+type clusterScan struct {
+	Time    time.Time
+	Success bool
+	Tokens  []teamToken
+}
+
+// Exporter periodically scans all clusters for team service accounts,
+// and exposes the results as Prometheus metrics.
+type Exporter struct {
+	Teams []string
+
+	mutex sync.Mutex
+	scans map[string]clusterScan
+}
+
+func NewExporter(teams []string) *Exporter {
+	return &Exporter{
+		Teams: teams,
+		scans: make(map[string]clusterScan),
+	}
+}
+
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tokenAgeDesc
+	ch <- serviceAccountExistsDesc
+	ch <- scanSuccessDesc
+	ch <- lastScanDesc
+}
+
+// Collect reports the results of the last scan. Token ages are computed at collection time.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for cluster, scan := range e.scans {
+		success := 0.0
+		if scan.Success {
+			success = 1
+		}
+		ch <- prometheus.MustNewConstMetric(scanSuccessDesc, prometheus.GaugeValue, success, cluster)
+		ch <- prometheus.MustNewConstMetric(lastScanDesc, prometheus.GaugeValue, float64(scan.Time.Unix()), cluster)
+
+		if !scan.Success {
+			continue
+		}
+
+		found := make(map[string]bool)
+		for _, token := range scan.Tokens {
+			found[token.Team] = true
+			ch <- prometheus.MustNewConstMetric(serviceAccountExistsDesc, prometheus.GaugeValue, 1, cluster, token.Namespace, token.Team)
+			ch <- prometheus.MustNewConstMetric(tokenAgeDesc, prometheus.GaugeValue, time.Since(token.Created).Seconds(), cluster, token.Namespace, token.Team)
+		}
+		for _, team := range e.Teams {
+			if !found[team] {
+				ch <- prometheus.MustNewConstMetric(serviceAccountExistsDesc, prometheus.GaugeValue, 0, cluster, config.serviceAccountNamespace(cluster), team)
+			}
+		}
+	}
+}
+
+// tokenCreated returns when the token secret of a service account was created. For service
+// accounts without a token secret, such as those using the TokenRequest API, the time of the
+// last rotation is used instead.
+func tokenCreated(client kubernetes.Interface, serviceAccount v1.ServiceAccount) time.Time {
+	secret, err := ServiceAccountSecret(client, serviceAccount)
+	if err != nil {
+		return LastRotated(serviceAccount)
+	}
+	return secret.CreationTimestamp.Time
+}
+
+// scanCluster finds all team service accounts in a cluster, and when their tokens were created.
+func scanCluster(ctx context.Context, cluster string) ([]teamToken, error) {
+	_, client, err := clusterClient(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceAccounts, err := ListServiceAccounts(client, listNamespace(cluster))
+	if err != nil {
+		return nil, fmt.Errorf("while listing service accounts: %s", err)
+	}
+
+	tokens := make([]teamToken, 0)
+	for _, serviceAccount := range serviceAccounts {
+		if !isTeamServiceAccount(serviceAccount) {
+			continue
+		}
+		tokens = append(tokens, teamToken{
+			Namespace: serviceAccount.Namespace,
+			Team:      serviceAccountTeam(serviceAccount),
+			Created:   tokenCreated(client, serviceAccount),
+		})
+	}
+
+	return tokens, nil
+}
+
+// Scan scans all clusters once, and replaces the results of the previous scan.
+// It returns the outcome of the scan in each cluster.
+func (e *Exporter) Scan(ctx context.Context) map[string]error {
+	errs := make(map[string]error)
+	forEachCluster(ctx, func(ctx context.Context, i int, cluster string) error {
+		tokens, err := scanCluster(ctx, cluster)
+
+		e.mutex.Lock()
+		e.scans[cluster] = clusterScan{
+			Time:    time.Now(),
+			Success: err == nil,
+			Tokens:  tokens,
+		}
+		errs[cluster] = err
+		e.mutex.Unlock()
+
+		return err
+	})
+	return errs
+}
+
+// exporter runs the exporter until interrupted.
+func exporter(ctx context.Context) error {
+	var teams []string
+	if len(config.TeamsFile) > 0 {
+		var err error
+		teams, err = ReadTeamsFile(config.TeamsFile)
+		if err != nil {
+			return fmt.Errorf("while reading teams: %s", err)
+		}
+	}
+
+	startDebugServer(ctx)
+
+	e := NewExporter(teams)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+
+	health := NewHealth()
+	health.Start(config.ScanInterval)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	health.Register(mux)
+	server := &http.Server{
+		Addr:    config.ListenAddress,
+		Handler: mux,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Infof("serving metrics on %s/metrics", config.ListenAddress)
+		errs <- server.ListenAndServe()
+	}()
+
+	ticker := time.NewTicker(config.ScanInterval)
+	defer ticker.Stop()
+
+	for {
+		log.Debugf("scanning %d clusters", len(config.Clusters))
+		health.Completed(e.Scan(ctx))
+
+		select {
+		case <-ticker.C:
+		case err := <-errs:
+			return fmt.Errorf("while serving metrics: %s", err)
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			return server.Shutdown(shutdownCtx)
+		}
+	}
+}
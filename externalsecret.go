@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// ExternalSecretConfig configures writing an External Secrets Operator manifest for each team,
+// pointing at the credentials stored in Vault or a cloud secret store.
+type ExternalSecretConfig struct {
+	Dir             string
+	Store           string
+	StoreKind       string
+	Namespace       string
+	Name            string
+	RefreshInterval string
+}
+
+// ExternalSecret is an external-secrets.io ExternalSecret.
+type ExternalSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              ExternalSecretSpec `json:"spec"`
+}
+
+type ExternalSecretSpec struct {
+	RefreshInterval string               `json:"refreshInterval"`
+	SecretStoreRef  SecretStoreRef       `json:"secretStoreRef"`
+	Target          ExternalSecretTarget `json:"target"`
+	Data            []ExternalSecretData `json:"data"`
+}
+
+type SecretStoreRef struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+type ExternalSecretTarget struct {
+	Name           string `json:"name"`
+	CreationPolicy string `json:"creationPolicy"`
+}
+
+type ExternalSecretData struct {
+	SecretKey string                  `json:"secretKey"`
+	RemoteRef ExternalSecretRemoteRef `json:"remoteRef"`
+}
+
+type ExternalSecretRemoteRef struct {
+	Key      string `json:"key"`
+	Property string `json:"property,omitempty"`
+}
+
+// externalSecretSources lists the sinks an ExternalSecret can point at, with the remote
+// reference to the Kubeconfig file of a team in each.
+var externalSecretSources = map[string]func(team string) ExternalSecretRemoteRef{
+	// The secret store points at the KV mount, which is the first element of --vault-path.
+	"vault": func(team string) ExternalSecretRemoteRef {
+		parts := strings.SplitN(vaultPath(team), "/", 2)
+		return ExternalSecretRemoteRef{Key: parts[len(parts)-1], Property: "kubeconfig"}
+	},
+	"aws": func(team string) ExternalSecretRemoteRef {
+		return ExternalSecretRemoteRef{Key: strings.Replace(config.AWS.Secret, "{team}", team, -1)}
+	},
+	// The secret store holds the project, so only the secret name is referenced.
+	"gcp": func(team string) ExternalSecretRemoteRef {
+		name := strings.Replace(config.GCPSecret, "{team}", team, -1)
+		return ExternalSecretRemoteRef{Key: name[strings.LastIndex(name, "/")+1:]}
+	},
+	"azure": func(team string) ExternalSecretRemoteRef {
+		return ExternalSecretRemoteRef{Key: "secret/" + azureSecretName(team)}
+	},
+}
+
+// externalSecretSource returns the name of the selected sink the ExternalSecret points at.
+func externalSecretSource() (string, error) {
+	sinkTypes, err := selectedSinkTypes()
+	if err != nil {
+		return "", err
+	}
+	sources := make([]string, 0)
+	for _, sinkType := range sinkTypes {
+		if _, ok := externalSecretSources[sinkType.Name]; ok {
+			sources = append(sources, sinkType.Name)
+		}
+	}
+	switch len(sources) {
+	case 0:
+		return "", fmt.Errorf("--external-secret-dir requires --vault-path, --aws-secret, --gcp-secret or --azure-key-vault")
+	case 1:
+		return sources[0], nil
+	default:
+		return "", fmt.Errorf("--external-secret-dir can only point at one secret store, but %s are selected", strings.Join(sources, " and "))
+	}
+}
+
+// externalSecretPath returns the path of the ExternalSecret manifest of a team.
+func externalSecretPath(team string) string {
+	return filepath.Join(config.ExternalSecret.Dir, team+"-externalsecret.yaml")
+}
+
+// renderExternalSecret returns the ExternalSecret manifest pointing at the Kubeconfig file of a team.
+func renderExternalSecret(team string) ([]byte, error) {
+	source, err := externalSecretSource()
+	if err != nil {
+		return nil, err
+	}
+
+	replacer := strings.NewReplacer("{team}", team)
+	name := replacer.Replace(config.ExternalSecret.Name)
+	secret := ExternalSecret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "external-secrets.io/v1beta1", Kind: "ExternalSecret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: replacer.Replace(config.ExternalSecret.Namespace),
+			Labels: map[string]string{
+				ManagedByLabel: ManagedByValue,
+				TeamLabel:      team,
+			},
+		},
+		Spec: ExternalSecretSpec{
+			RefreshInterval: config.ExternalSecret.RefreshInterval,
+			SecretStoreRef: SecretStoreRef{
+				Name: config.ExternalSecret.Store,
+				Kind: config.ExternalSecret.StoreKind,
+			},
+			Target: ExternalSecretTarget{
+				Name:           name,
+				CreationPolicy: "Owner",
+			},
+			Data: []ExternalSecretData{
+				{
+					SecretKey: "kubeconfig",
+					RemoteRef: externalSecretSources[source](team),
+				},
+			},
+		},
+	}
+	return yaml.Marshal(secret)
+}
+
+// ExternalSecretSink writes an ExternalSecret manifest for each team to --external-secret-dir, so
+// that teams using External Secrets Operator can sync the Kubeconfig file into their namespace.
+type ExternalSecretSink struct{}
+
+func (s *ExternalSecretSink) Paths(ctx context.Context, team string) []string {
+	return []string{externalSecretPath(team)}
+}
+
+func (s *ExternalSecretSink) Write(ctx context.Context, team string, kubeconfig []byte, credentials []*Credentials) error {
+	config := configFrom(ctx)
+	data, err := renderExternalSecret(team)
+	if err != nil {
+		return err
+	}
+	path := externalSecretPath(team)
+	err = WriteFileAtomic(path, data, config.Force)
+	if err != nil {
+		return err
+	}
+	log.Infof("ExternalSecret written to %s", path)
+	return nil
+}
+
+// validateExternalSecret checks the ExternalSecret flags before any changes are made.
+func validateExternalSecret() error {
+	if len(config.ExternalSecret.Dir) == 0 {
+		return nil
+	}
+	if len(config.ExternalSecret.Store) == 0 {
+		return fmt.Errorf("--external-secret-dir requires --external-secret-store")
+	}
+	if kind := config.ExternalSecret.StoreKind; kind != "SecretStore" && kind != "ClusterSecretStore" {
+		return fmt.Errorf("--external-secret-store-kind must be SecretStore or ClusterSecretStore")
+	}
+	if config.ExternalSecret.Namespace == "" || config.ExternalSecret.Name == "" {
+		return fmt.Errorf("--external-secret-namespace and --external-secret-name must not be empty")
+	}
+	source, err := externalSecretSource()
+	if err != nil {
+		return err
+	}
+	if source == "vault" && !contains(config.VaultFields, "kubeconfig") || source == "azure" && !contains(config.Azure.Fields, "kubeconfig") {
+		return fmt.Errorf("--external-secret-dir requires the Kubeconfig file to be stored in %s", source)
+	}
+	return nil
+}
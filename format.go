@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// OutputFormat is a format of the files written to --output, --output-dir, --split-output
+// and standard output, selected with --output-format.
+type OutputFormat struct {
+	Name string
+
+	// Extension is the file extension of unencrypted files in this format.
+	Extension string
+
+	// Render converts the Kubeconfig file of a team to this format. credentials holds the token
+	// of each cluster in the Kubeconfig file, or nil for clusters that failed.
+	Render func(team string, kubeconfig []byte, credentials []*Credentials) ([]byte, error)
+
+	// Validate checks the flags of this format before any changes are made. It may be nil.
+	Validate func() error
+}
+
+// OutputFormats lists all output formats. The first is the default.
+var OutputFormats = []OutputFormat{
+	{
+		Name:      "kubeconfig",
+		Extension: ".yaml",
+		Render: func(team string, kubeconfig []byte, credentials []*Credentials) ([]byte, error) {
+			return kubeconfig, nil
+		},
+	},
+	{
+		Name:      "sealedsecret",
+		Extension: ".yaml",
+		Render:    renderSealedSecret,
+		Validate:  validateSealedSecret,
+	},
+	{
+		Name:      "terraform",
+		Extension: ".json",
+		Render:    renderTerraform,
+		Validate:  requireTokenAuth("terraform"),
+	},
+	{
+		Name:      "helm-values",
+		Extension: ".yaml",
+		Render:    renderHelmValues,
+		Validate:  requireTokenAuth("helm-values"),
+	},
+	{
+		Name:      "env",
+		Extension: ".env",
+		Render:    renderEnv,
+		Validate:  requireTokenAuth("env"),
+	},
+	{
+		Name:      "token",
+		Extension: ".token",
+		Render:    renderToken,
+		Validate:  validateTokenFormat,
+	},
+	{
+		Name:      "raw",
+		Extension: ".txt",
+		Render:    renderRaw,
+		Validate:  validateRawFormat,
+	},
+}
+
+func outputFormatNames() []string {
+	names := make([]string, len(OutputFormats))
+	for i, format := range OutputFormats {
+		names[i] = format.Name
+	}
+	return names
+}
+
+// outputFormat returns the format selected with --output-format.
+func outputFormat() (OutputFormat, error) {
+	for _, format := range OutputFormats {
+		if format.Name == config.OutputFormat {
+			return format, nil
+		}
+	}
+	return OutputFormat{}, fmt.Errorf("unknown --output-format '%s'; must be one of %s", config.OutputFormat, strings.Join(outputFormatNames(), ", "))
+}
+
+// renderOutput converts the Kubeconfig file of a team to --output-format, and encrypts it if configured.
+func renderOutput(team string, kubeconfig []byte, credentials []*Credentials) ([]byte, error) {
+	format, err := outputFormat()
+	if err != nil {
+		return nil, err
+	}
+	output, err := format.Render(team, kubeconfig, credentials)
+	if err != nil {
+		return nil, err
+	}
+	return encryptOutput(output)
+}
+
+// validateOutputFormat checks the output format flags before any changes are made.
+func validateOutputFormat() error {
+	format, err := outputFormat()
+	if err != nil {
+		return err
+	}
+	if format.Validate != nil {
+		return format.Validate()
+	}
+	return nil
+}
+
+// requireTokenAuth returns a validator for formats that contain the tokens themselves.
+func requireTokenAuth(format string) func() error {
+	return func() error {
+		if config.Auth != AuthToken {
+			return fmt.Errorf("--output-format %s requires --auth %s", format, AuthToken)
+		}
+		return nil
+	}
+}
+
+// ClusterOutput holds the credentials of a single cluster in output formats other than Kubeconfig.
+type ClusterOutput struct {
+	Server   string `json:"server"`
+	Token    string `json:"token"`
+	CA       string `json:"ca"`
+	Insecure bool   `json:"insecure"`
+}
+
+// clusterOutputs maps each cluster that succeeded to its server, token and PEM encoded CA certificate.
+func clusterOutputs(credentials []*Credentials) map[string]ClusterOutput {
+	clusters := make(map[string]ClusterOutput)
+	for _, c := range credentials {
+		if c == nil {
+			continue
+		}
+		clusters[c.Cluster] = ClusterOutput{
+			Server:   c.Server,
+			Token:    c.Token,
+			CA:       string(c.CAData),
+			Insecure: c.Insecure,
+		}
+	}
+	return clusters
+}
+
+// terraformOutputs returns the credentials of each cluster that succeeded as a flat map of strings,
+// which is what the external data source of Terraform accepts: <cluster>_server, <cluster>_token,
+// the PEM encoded <cluster>_ca and <cluster>_insecure, which is "true" or "false".
+func terraformOutputs(credentials []*Credentials) map[string]string {
+	outputs := make(map[string]string)
+	for cluster, c := range clusterOutputs(credentials) {
+		outputs[cluster+"_server"] = c.Server
+		outputs[cluster+"_token"] = c.Token
+		outputs[cluster+"_ca"] = c.CA
+		outputs[cluster+"_insecure"] = strconv.FormatBool(c.Insecure)
+	}
+	return outputs
+}
+
+// renderTerraform returns a flat JSON object with the server, token and PEM encoded CA certificate
+// of each cluster, to be read with the external data source or jsondecode in Terraform.
+func renderTerraform(team string, kubeconfig []byte, credentials []*Credentials) ([]byte, error) {
+	data, err := json.MarshalIndent(terraformOutputs(credentials), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// HelmValues is the values file written with --output-format helm-values.
+type HelmValues struct {
+	Team     string                   `json:"team"`
+	Clusters map[string]ClusterOutput `json:"clusters"`
+}
+
+// renderHelmValues returns a Helm values file with the server, token and PEM encoded CA
+// certificate of each cluster under clusters.<cluster>.
+func renderHelmValues(team string, kubeconfig []byte, credentials []*Credentials) ([]byte, error) {
+	return yaml.Marshal(HelmValues{
+		Team:     team,
+		Clusters: clusterOutputs(credentials),
+	})
+}
+
+// renderEnv returns a dotenv file with KUBE_SERVER_<CLUSTER>, KUBE_TOKEN_<CLUSTER> and
+// KUBE_CA_<CLUSTER> for each cluster, in the order of --clusters. The CA certificate is base64
+// encoded, like in Kubeconfig files, so that every value fits on a single line.
+func renderEnv(team string, kubeconfig []byte, credentials []*Credentials) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	for _, c := range credentials {
+		if c == nil {
+			continue
+		}
+		name := secretName(c.Cluster)
+		fmt.Fprintf(buf, "KUBE_SERVER_%s=%s\n", name, c.Server)
+		fmt.Fprintf(buf, "KUBE_TOKEN_%s=%s\n", name, c.Token)
+		fmt.Fprintf(buf, "KUBE_CA_%s=%s\n", name, base64.StdEncoding.EncodeToString(c.CAData))
+		if c.Insecure {
+			fmt.Fprintf(buf, "KUBE_INSECURE_%s=true\n", name)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// renderToken returns the bearer token of the only cluster, without a trailing newline, so that
+// it can be passed on as is.
+func renderToken(team string, kubeconfig []byte, credentials []*Credentials) ([]byte, error) {
+	for _, c := range credentials {
+		if c != nil {
+			return []byte(c.Token), nil
+		}
+	}
+	return nil, fmt.Errorf("no token")
+}
+
+func validateTokenFormat() error {
+	if err := requireTokenAuth("token")(); err != nil {
+		return err
+	}
+	if len(config.Clusters) != 1 {
+		return fmt.Errorf("--output-format token requires exactly one cluster, selected with --clusters")
+	}
+	return nil
+}
+
+// rawFields returns the fields of the token secret of a cluster that can be selected with --field.
+func rawFields(c *Credentials) map[string][]byte {
+	return map[string][]byte{
+		"token":     []byte(c.Token),
+		"ca.crt":    c.CAData,
+		"namespace": []byte(config.serviceAccountNamespace(c.Cluster)),
+		"server":    []byte(c.Server),
+	}
+}
+
+// renderRaw returns the fields selected with --field of the only cluster, base64 encoded with
+// --base64. A single field is written without a trailing newline; several fields are written one
+// per line.
+func renderRaw(team string, kubeconfig []byte, credentials []*Credentials) ([]byte, error) {
+	for _, c := range credentials {
+		if c == nil {
+			continue
+		}
+		fields := rawFields(c)
+		values := make([][]byte, len(config.RawFields))
+		for i, field := range config.RawFields {
+			values[i] = fields[field]
+			if config.RawBase64 {
+				values[i] = []byte(base64.StdEncoding.EncodeToString(values[i]))
+			}
+		}
+		if len(values) == 1 {
+			return values[0], nil
+		}
+		return append(bytes.Join(values, []byte("\n")), '\n'), nil
+	}
+	return nil, fmt.Errorf("no credentials")
+}
+
+func validateRawFormat() error {
+	if err := requireTokenAuth("raw")(); err != nil {
+		return err
+	}
+	if len(config.Clusters) != 1 {
+		return fmt.Errorf("--output-format raw requires exactly one cluster, selected with --clusters")
+	}
+	if len(config.RawFields) == 0 {
+		return fmt.Errorf("--output-format raw requires --field")
+	}
+	for _, field := range config.RawFields {
+		if _, ok := rawFields(&Credentials{})[field]; !ok {
+			return fmt.Errorf("unknown --field '%s'; must be one of token, ca.crt, namespace, server", field)
+		}
+		// a PEM certificate spans several lines, so it could not be told apart from the next field
+		if field == "ca.crt" && len(config.RawFields) > 1 && !config.RawBase64 {
+			return fmt.Errorf("--field ca.crt can only be combined with other fields with --base64")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+const testCA = "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"
+
+func testCredentials() []*Credentials {
+	return []*Credentials{
+		{Team: "aura", Cluster: "dev-fss", Server: "https://dev-fss.example.com", Token: "dev-token", CAData: []byte(testCA)},
+		nil,
+		{Team: "aura", Cluster: "prod-sbs", Server: "https://prod-sbs.example.com", Token: "prod-token", Insecure: true},
+	}
+}
+
+func TestRenderTerraform(t *testing.T) {
+	output, err := renderTerraform("aura", nil, testCredentials())
+	if err != nil {
+		t.Fatalf("renderTerraform() error: %s", err)
+	}
+	// The external data source of Terraform only accepts a flat object of strings.
+	values := make(map[string]interface{})
+	if err := json.Unmarshal(output, &values); err != nil {
+		t.Fatalf("renderTerraform() returned invalid JSON: %s", err)
+	}
+	for key, value := range values {
+		if _, ok := value.(string); !ok {
+			t.Errorf("%s is %T, not a string", key, value)
+		}
+	}
+
+	outputs := make(map[string]string)
+	if err := json.Unmarshal(output, &outputs); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"dev-fss_server":    "https://dev-fss.example.com",
+		"dev-fss_token":     "dev-token",
+		"dev-fss_ca":        testCA,
+		"dev-fss_insecure":  "false",
+		"prod-sbs_server":   "https://prod-sbs.example.com",
+		"prod-sbs_token":    "prod-token",
+		"prod-sbs_ca":       "",
+		"prod-sbs_insecure": "true",
+	}
+	if !reflect.DeepEqual(outputs, expected) {
+		t.Errorf("renderTerraform() = %v, want %v", outputs, expected)
+	}
+}
+
+func TestRenderHelmValues(t *testing.T) {
+	output, err := renderHelmValues("aura", nil, testCredentials())
+	if err != nil {
+		t.Fatalf("renderHelmValues() error: %s", err)
+	}
+	values := HelmValues{}
+	if err := yaml.Unmarshal(output, &values); err != nil {
+		t.Fatalf("renderHelmValues() returned invalid YAML: %s", err)
+	}
+	if values.Team != "aura" {
+		t.Errorf("team = %s, want aura", values.Team)
+	}
+	if len(values.Clusters) != 2 {
+		t.Fatalf("renderHelmValues() returned %d clusters, want 2", len(values.Clusters))
+	}
+	if cluster := values.Clusters["dev-fss"]; cluster.Token != "dev-token" || cluster.CA != testCA {
+		t.Errorf("cluster dev-fss = %+v", cluster)
+	}
+}
+
+func TestRenderEnv(t *testing.T) {
+	output, err := renderEnv("aura", nil, testCredentials())
+	if err != nil {
+		t.Fatalf("renderEnv() error: %s", err)
+	}
+	expected := strings.Join([]string{
+		"KUBE_SERVER_DEV_FSS=https://dev-fss.example.com",
+		"KUBE_TOKEN_DEV_FSS=dev-token",
+		"KUBE_CA_DEV_FSS=" + base64.StdEncoding.EncodeToString([]byte(testCA)),
+		"KUBE_SERVER_PROD_SBS=https://prod-sbs.example.com",
+		"KUBE_TOKEN_PROD_SBS=prod-token",
+		"KUBE_CA_PROD_SBS=",
+		"KUBE_INSECURE_PROD_SBS=true",
+	}, "\n") + "\n"
+	if string(output) != expected {
+		t.Errorf("renderEnv() = %q, want %q", output, expected)
+	}
+}
+
+func TestRenderToken(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		credentials []*Credentials
+		expected    string
+		err         bool
+	}{
+		{"first cluster", testCredentials(), "dev-token", false},
+		{"after failed cluster", testCredentials()[1:], "prod-token", false},
+		{"all failed", []*Credentials{nil}, "", true},
+		{"none", nil, "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := renderToken("aura", nil, test.credentials)
+			if (err != nil) != test.err {
+				t.Fatalf("renderToken() error = %v, want error %t", err, test.err)
+			}
+			if string(output) != test.expected {
+				t.Errorf("renderToken() = %q, want %q", output, test.expected)
+			}
+		})
+	}
+}
+
+func TestRenderRaw(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		fields   []string
+		base64   bool
+		expected string
+	}{
+		{"token", []string{"token"}, false, "dev-token"},
+		{"ca", []string{"ca.crt"}, false, testCA},
+		{"namespace", []string{"namespace"}, false, "kube-system"},
+		{"several fields", []string{"server", "token"}, false, "https://dev-fss.example.com\ndev-token\n"},
+		{"base64", []string{"token", "ca.crt"}, true,
+			base64.StdEncoding.EncodeToString([]byte("dev-token")) + "\n" + base64.StdEncoding.EncodeToString([]byte(testCA)) + "\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			setConfig(t, &Config{
+				ServiceAccountNamespace: "kube-system",
+				RawFields:               test.fields,
+				RawBase64:               test.base64,
+			})
+
+			output, err := renderRaw("aura", nil, testCredentials())
+			if err != nil {
+				t.Fatalf("renderRaw() error: %s", err)
+			}
+			if string(output) != test.expected {
+				t.Errorf("renderRaw() = %q, want %q", output, test.expected)
+			}
+		})
+	}
+
+	if _, err := renderRaw("aura", nil, []*Credentials{nil}); err == nil {
+		t.Errorf("renderRaw() without credentials did not fail")
+	}
+}
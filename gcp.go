@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	secretManagerAPI   = "https://secretmanager.googleapis.com/v1/"
+	cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+var (
+	// gcpSecretPattern matches the resource name of a secret in Secret Manager.
+	gcpSecretPattern = regexp.MustCompile("^(projects/[^/]+)/secrets/([^/]+)$")
+
+	// GCP label keys and values may only contain lowercase letters, digits, dashes and underscores.
+	gcpLabelInvalid = regexp.MustCompile("[^a-z0-9_-]")
+)
+
+// SecretManagerClient adds secret versions to Google Secret Manager.
+type SecretManagerClient struct {
+	Client *http.Client
+}
+
+// NewSecretManagerClient returns a client using Application Default Credentials.
+func NewSecretManagerClient(ctx context.Context) (*SecretManagerClient, error) {
+	client, err := google.DefaultClient(ctx, cloudPlatformScope)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretManagerClient{Client: client}, nil
+}
+
+func (s *SecretManagerClient) request(ctx context.Context, method, path string, body, result interface{}) error {
+	return jsonRequest(ctx, s.Client, method, secretManagerAPI+path, nil, body, result)
+}
+
+// EnsureSecret creates the secret if it does not exist. Labels are set on the secret
+// if any are given, replacing existing labels.
+func (s *SecretManagerClient) EnsureSecret(ctx context.Context, name string, labels map[string]string) error {
+	match := gcpSecretPattern.FindStringSubmatch(name)
+	if match == nil {
+		return fmt.Errorf("invalid secret name '%s'; must be projects/<project>/secrets/<name>", name)
+	}
+
+	err := s.request(ctx, http.MethodGet, name, nil, nil)
+	if err != nil {
+		log.Debugf("secret %s not found, creating it: %s", name, err)
+		body := map[string]interface{}{
+			"replication": map[string]interface{}{
+				"automatic": map[string]interface{}{},
+			},
+			"labels": labels,
+		}
+		return s.request(ctx, http.MethodPost, fmt.Sprintf("%s/secrets?secretId=%s", match[1], match[2]), body, nil)
+	}
+
+	if len(labels) == 0 {
+		return nil
+	}
+	body := map[string]interface{}{
+		"labels": labels,
+	}
+	return s.request(ctx, http.MethodPatch, name+"?updateMask=labels", body, nil)
+}
+
+// AddSecretVersion stores data as the latest version of a secret.
+func (s *SecretManagerClient) AddSecretVersion(ctx context.Context, name string, data []byte) (string, error) {
+	body := map[string]interface{}{
+		"payload": map[string]string{
+			"data": base64.StdEncoding.EncodeToString(data),
+		},
+	}
+	version := struct {
+		Name string `json:"name"`
+	}{}
+	err := s.request(ctx, http.MethodPost, name+":addVersion", body, &version)
+	return version.Name, err
+}
+
+// gcpLabel turns a string into a valid label key or value.
+func gcpLabel(s string) string {
+	label := gcpLabelInvalid.ReplaceAllString(strings.ToLower(s), "_")
+	if len(label) > 63 {
+		label = label[:63]
+	}
+	return label
+}
+
+// gcpSecretLabels returns labels identifying the team and clusters of a Kubeconfig file.
+func gcpSecretLabels(team string, credentials []*Credentials) map[string]string {
+	labels := map[string]string{
+		"team":       gcpLabel(team),
+		"managed-by": ManagedByValue,
+	}
+	for _, c := range credentials {
+		if c != nil {
+			labels[gcpLabel("cluster-"+c.Cluster)] = "true"
+		}
+	}
+	return labels
+}
+
+// writeSecretManager stores the Kubeconfig file as a new version of a Google Secret Manager secret.
+func writeSecretManager(ctx context.Context, team string, kubeconfig []byte, credentials []*Credentials) error {
+	config := configFrom(ctx)
+	client, err := NewSecretManagerClient(ctx)
+	if err != nil {
+		return fmt.Errorf("while retrieving Google credentials: %s", err)
+	}
+
+	var labels map[string]string
+	if config.GCPSecretLabels {
+		labels = gcpSecretLabels(team, credentials)
+	}
+
+	name := strings.Replace(config.GCPSecret, "{team}", team, -1)
+	err = client.EnsureSecret(ctx, name, labels)
+	if err != nil {
+		return err
+	}
+
+	version, err := client.AddSecretVersion(ctx, name, kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("credentials stored in Google Secret Manager as %s", version)
+	return nil
+}
@@ -0,0 +1,889 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// serviceAccountToken returns a token for the service account. In TokenRequest mode,
+// a new token is minted by the API server, falling back to the token secret on
+// clusters that do not support the TokenRequest API. If the service account was
+// just created, wait for the token controller to populate its token secret.
+// The token secret is returned as well, unless the token was minted.
+func serviceAccountToken(ctx context.Context, cluster string, client kubernetes.Interface, namespace, serviceAccountName string, created bool) (string, *v1.Secret, error) {
+	config := configFrom(ctx)
+	if config.TokenRequest {
+		status, err := RequestServiceAccountToken(client, namespace, serviceAccountName, config.TokenAudiences, config.TokenTTL)
+		if err == nil {
+			expires := map[string]string{
+				TokenExpiresAnnotation: status.ExpirationTimestamp.UTC().Format(time.RFC3339),
+			}
+			if err := AnnotateServiceAccount(client, namespace, serviceAccountName, expires); err != nil {
+				log.Warnf("%s: while recording token expiry: %s", cluster, err)
+			}
+			return status.Token, nil, nil
+		}
+		if !TokenRequestUnsupported(err) {
+			return "", nil, fmt.Errorf("while requesting token: %s", err)
+		}
+		if len(config.TokenAudiences) > 0 {
+			return "", nil, fmt.Errorf("TokenRequest API not supported; secret tokens cannot be bound to --token-audience")
+		}
+		log.Debugf("%s: TokenRequest API not supported, falling back to secret token", cluster)
+	}
+
+	timeout := time.Duration(0)
+	if created {
+		timeout = config.TokenTimeout
+	}
+
+	// get service account secret token
+	secret, err := WaitForServiceAccountSecret(ctx, client, namespace, serviceAccountName, timeout)
+	if err != nil {
+		return "", nil, fmt.Errorf("while retrieving secret token: %s", err)
+	}
+
+	return string(secret.Data["token"]), secret, nil
+}
+
+// roleName returns the name of the role bound by --create-role or --role.
+func (c *Config) roleName(serviceAccountName string) string {
+	if len(c.Role) > 0 {
+		return c.Role
+	}
+	return serviceAccountName
+}
+
+// clusterRoleBindingName returns the name of the binding created by --cluster-role.
+func (c *Config) clusterRoleBindingName(serviceAccountName string) string {
+	return fmt.Sprintf("%s-%s", serviceAccountName, c.ClusterRole)
+}
+
+// createRBAC creates the role and role binding for a service account, if configured.
+// The role and role binding are named after the service account. Existing objects are left untouched.
+func createRBAC(ctx context.Context, cluster string, client kubernetes.Interface, namespace, serviceAccountName string) error {
+	config := configFrom(ctx)
+	if config.CreateRole {
+		_, err := CreateRole(client, namespace, serviceAccountName, DeployRules)
+		if errors.IsAlreadyExists(err) {
+			log.Debugf("%s: role '%s' already exists", cluster, serviceAccountName)
+		} else if err != nil {
+			return fmt.Errorf("while creating role: %s", err)
+		} else {
+			log.Infof("%s: created role '%s'", cluster, serviceAccountName)
+		}
+	}
+
+	if config.CreateRole || len(config.Role) > 0 {
+		_, err := CreateRoleBinding(client, namespace, serviceAccountName, "Role", config.roleName(serviceAccountName), serviceAccountName)
+		if errors.IsAlreadyExists(err) {
+			log.Debugf("%s: role binding '%s' already exists", cluster, serviceAccountName)
+		} else if err != nil {
+			return fmt.Errorf("while creating role binding: %s", err)
+		} else {
+			log.Infof("%s: bound service account '%s' to role '%s'", cluster, serviceAccountName, config.roleName(serviceAccountName))
+		}
+	}
+
+	if len(config.ClusterRole) > 0 {
+		var err error
+		name := config.clusterRoleBindingName(serviceAccountName)
+		if config.ClusterWide {
+			_, err = CreateClusterRoleBinding(client, name, config.ClusterRole, namespace, serviceAccountName)
+		} else {
+			_, err = CreateRoleBinding(client, namespace, name, "ClusterRole", config.ClusterRole, serviceAccountName)
+		}
+		if errors.IsAlreadyExists(err) {
+			log.Debugf("%s: binding '%s' already exists", cluster, name)
+		} else if err != nil {
+			return fmt.Errorf("while binding cluster role: %s", err)
+		} else {
+			log.Infof("%s: bound service account '%s' to cluster role '%s'", cluster, serviceAccountName, config.ClusterRole)
+		}
+	}
+
+	return nil
+}
+
+// deleteRBAC deletes the role binding for a service account, and the role if it was created by teamconfig.
+// Roles referenced with --role are never deleted.
+func deleteRBAC(ctx context.Context, cluster string, client kubernetes.Interface, namespace, serviceAccountName string) error {
+	config := configFrom(ctx)
+	if config.CreateRole || len(config.Role) > 0 {
+		err := DeleteRoleBinding(client, namespace, serviceAccountName)
+		if errors.IsNotFound(err) {
+			log.Debugf("%s: role binding '%s' not found", cluster, serviceAccountName)
+		} else if err != nil {
+			return fmt.Errorf("while deleting role binding: %s", err)
+		} else {
+			log.Infof("%s: deleted role binding '%s'", cluster, serviceAccountName)
+		}
+	}
+
+	if config.CreateRole {
+		err := DeleteRole(client, namespace, serviceAccountName)
+		if errors.IsNotFound(err) {
+			log.Debugf("%s: role '%s' not found", cluster, serviceAccountName)
+		} else if err != nil {
+			return fmt.Errorf("while deleting role: %s", err)
+		} else {
+			log.Infof("%s: deleted role '%s'", cluster, serviceAccountName)
+		}
+	}
+
+	if len(config.ClusterRole) > 0 {
+		var err error
+		name := config.clusterRoleBindingName(serviceAccountName)
+		if config.ClusterWide {
+			err = DeleteClusterRoleBinding(client, name)
+		} else {
+			err = DeleteRoleBinding(client, namespace, name)
+		}
+		if errors.IsNotFound(err) {
+			log.Debugf("%s: binding '%s' not found", cluster, name)
+		} else if err != nil {
+			return fmt.Errorf("while deleting cluster role binding: %s", err)
+		} else {
+			log.Infof("%s: deleted binding '%s'", cluster, name)
+		}
+	}
+
+	return nil
+}
+
+// rotationDue returns true if the service account should be rotated. With --rotate-if-older-than,
+// service accounts created or rotated more recently are left alone. Missing service accounts
+// are always due, so that they can be created with --create.
+func rotationDue(ctx context.Context, cluster string, client kubernetes.Interface, namespace, serviceAccountName string) (bool, error) {
+	config := configFrom(ctx)
+	if !config.Rotate {
+		return false, nil
+	}
+	if config.RotateIfOlderThan == 0 {
+		return true, nil
+	}
+
+	serviceAccount, err := ServiceAccount(client, namespace, serviceAccountName)
+	if errors.IsNotFound(err) {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("while retrieving service account: %s", err)
+	}
+
+	age := time.Since(LastRotated(*serviceAccount))
+	if age < config.RotateIfOlderThan {
+		log.Infof("%s: service account '%s' was rotated %s ago; not rotating", cluster, serviceAccountName, formatDuration(age))
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// serviceAccountAnnotations returns the annotations to set on a newly created service account.
+// When rotating, the creation time and history of the previous service account are carried over.
+func serviceAccountAnnotations(ctx context.Context, client kubernetes.Interface, namespace, serviceAccountName string, rotate bool) (map[string]string, error) {
+	now := time.Now().UTC()
+	entry := HistoryEntry{
+		Time:     now,
+		Operator: configFrom(ctx).operatorIdentity(),
+		Action:   ActionCreated,
+	}
+	annotations := map[string]string{
+		CreatedAtAnnotation: now.Format(time.RFC3339),
+	}
+	if !rotate {
+		annotations[HistoryAnnotation] = appendHistory(nil, entry)
+		return annotations, nil
+	}
+
+	previous, err := ServiceAccount(client, namespace, serviceAccountName)
+	if errors.IsNotFound(err) {
+		annotations[HistoryAnnotation] = appendHistory(nil, entry)
+		return annotations, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("while retrieving service account: %s", err)
+	}
+
+	history, err := serviceAccountHistory(*previous)
+	if err != nil {
+		log.Warnf("discarding rotation history of service account '%s': %s", serviceAccountName, err)
+	}
+	entry.Action = ActionRotated
+	annotations[CreatedAtAnnotation] = CreatedAt(*previous).UTC().Format(time.RFC3339)
+	annotations[RotatedAtAnnotation] = now.Format(time.RFC3339)
+	annotations[HistoryAnnotation] = appendHistory(history, entry)
+
+	return annotations, nil
+}
+
+// planClusterExec reports what clusterExec would do in a single cluster, without making any changes.
+func planClusterExec(ctx context.Context, cluster string, client kubernetes.Interface, namespace, serviceAccountName string, rotate bool) error {
+	config := configFrom(ctx)
+	_, err := ServiceAccount(client, namespace, serviceAccountName)
+	exists := err == nil
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("while retrieving service account: %s", err)
+	}
+
+	var plan string
+	switch {
+	case config.Revoke && exists:
+		plan = "would revoke access for service account '%s' in namespace %s"
+	case config.Revoke:
+		plan = "service account '%s' not found in namespace %s; nothing to revoke"
+	case rotate && exists && config.TwoPhase:
+		plan = "would issue a new token for service account '%s' in namespace %s, keeping the previous token valid"
+	case rotate && exists:
+		plan = "would rotate token for service account '%s' in namespace %s"
+	case (rotate || config.Create) && !exists:
+		plan = "would create service account '%s' in namespace %s"
+	case exists:
+		plan = "service account '%s' in namespace %s is unchanged"
+	default:
+		return fmt.Errorf("service account '%s' not found in namespace %s", serviceAccountName, namespace)
+	}
+
+	log.Infof("%s: [dry run] "+plan, cluster, serviceAccountName, namespace)
+
+	if config.CreateRole || len(config.Role) > 0 {
+		switch {
+		case config.Revoke:
+			log.Infof("%s: [dry run] would delete role binding '%s'", cluster, serviceAccountName)
+		case rotate || config.Create:
+			log.Infof("%s: [dry run] would bind service account '%s' to role '%s'", cluster, serviceAccountName, config.roleName(serviceAccountName))
+		}
+	}
+
+	if len(config.ClusterRole) > 0 {
+		switch {
+		case config.Revoke:
+			log.Infof("%s: [dry run] would delete binding '%s'", cluster, config.clusterRoleBindingName(serviceAccountName))
+		case rotate || config.Create:
+			log.Infof("%s: [dry run] would bind service account '%s' to cluster role '%s'", cluster, serviceAccountName, config.ClusterRole)
+		}
+	}
+
+	return nil
+}
+
+// clusterCredentials returns credentials for a cluster, with everything except the token
+// filled in. The CA certificate is taken from --cluster-ca-file or --ca-file, or otherwise
+// found using clusterCA.
+func clusterCredentials(ctx context.Context, team, cluster string, clientConfig *rest.Config, client kubernetes.Interface, namespace string, secret *v1.Secret) (*Credentials, error) {
+	config := configFrom(ctx)
+	insecure := contains(config.InsecureClusters, cluster)
+	caData, err := config.configuredCA(cluster)
+	if err != nil {
+		return nil, err
+	}
+	if len(caData) == 0 && !insecure {
+		caData, err = clusterCA(clientConfig, client, namespace, secret)
+		if err != nil {
+			return nil, fmt.Errorf("while retrieving cluster CA certificate: %s", err)
+		}
+		if len(caData) == 0 {
+			log.Warnf("%s: no CA certificate found; clients will use the system trust store", cluster)
+		}
+	}
+
+	return &Credentials{
+		Team:     team,
+		Cluster:  cluster,
+		Server:   config.server(cluster, clientConfig.Host),
+		CAData:   caData,
+		Insecure: insecure,
+	}, nil
+}
+
+// eventReasons maps the actions that change a team service account to the reason of the event recorded on it.
+var eventReasons = map[string]string{
+	ActionCreated:    "Created",
+	ActionRotated:    "Rotated",
+	ActionRevoked:    "Revoked",
+	ActionRolledBack: "RolledBack",
+}
+
+// recordEvent records a Kubernetes event on the team service account if it was changed. Failures are
+// only logged, since the change itself has already been made.
+func recordEvent(ctx context.Context, cluster string, client kubernetes.Interface, namespace, serviceAccountName string, result *ClusterResult) {
+	reason, ok := eventReasons[result.Action]
+	if !ok {
+		return
+	}
+	message := fmt.Sprintf("Service account %s by %s using teamconfig", result.Action, configFrom(ctx).operatorIdentity())
+	err := RecordServiceAccountEvent(client, namespace, serviceAccountName, reason, message)
+	if err != nil {
+		log.Warnf("%s: while recording event: %s", cluster, err)
+	}
+}
+
+// clusterExec runs the configured service account operation in a single cluster,
+// and returns credentials for the team service user. When revoking access,
+// no credentials are returned. The action taken is recorded in result.
+func clusterExec(ctx context.Context, team, cluster string, result *ClusterResult) (*Credentials, error) {
+	config := configFrom(ctx)
+	clientConfig, client, err := clusterClient(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := config.serviceAccountNamespace(cluster)
+	serviceAccountName := ServiceAccountName(team)
+
+	// OIDC users log in as themselves, so there is no service account to look up.
+	if config.Auth == AuthOIDC {
+		result.Action = ActionRetrieved
+		return clusterCredentials(ctx, team, cluster, clientConfig, client, namespace, nil)
+	}
+
+	if (config.Create || config.Rotate || config.Revoke) && !config.SkipPermissionCheck {
+		err = checkPermissions(ctx, client, namespace)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rotate, err := rotationDue(ctx, cluster, client, namespace, serviceAccountName)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.DryRun {
+		result.Action = ActionPlanned
+		return nil, planClusterExec(ctx, cluster, client, namespace, serviceAccountName, rotate)
+	}
+
+	if config.Rotate && !rotate {
+		result.Action = ActionUnchanged
+	}
+
+	defer recordEvent(ctx, cluster, client, namespace, serviceAccountName, result)
+
+	deleted := false
+	created := false
+
+	// clean up access control before the service account itself
+	if config.Revoke {
+		err = deleteRBAC(ctx, cluster, client, namespace, serviceAccountName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// with --two-phase or --rollback-on-failure, the service account is kept, so that its current token stays valid
+	if rotate && (config.TwoPhase || config.RollbackOnFailure) {
+		result.rollback, err = rotateTwoPhase(ctx, cluster, client, namespace, serviceAccountName, team)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rotatedTwoPhase := result.rollback != nil
+	if rotatedTwoPhase && !config.TwoPhase {
+		log.Infof("%s: issued new token for service account '%s'; the previous token is kept until all clusters have succeeded", cluster, serviceAccountName)
+	} else if rotatedTwoPhase && config.GracePeriod > 0 {
+		log.Infof("%s: issued new token for service account '%s'; the previous token is kept for a grace period of %s", cluster, serviceAccountName, formatDuration(config.GracePeriod))
+	} else if rotatedTwoPhase {
+		log.Infof("%s: issued new token for service account '%s'; the previous token is valid until finish-rotation is run", cluster, serviceAccountName)
+	}
+	if rotatedTwoPhase {
+		result.Action = ActionRotated
+		rotate = false
+		err = createRBAC(ctx, cluster, client, namespace, serviceAccountName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	annotations, err := serviceAccountAnnotations(ctx, client, namespace, serviceAccountName, rotate)
+	if err != nil {
+		return nil, err
+	}
+
+	// if revoking access or rotating keys, delete the service account if it exists
+	if rotate || config.Revoke {
+		err = DeleteServiceAccount(client, namespace, serviceAccountName)
+		if err == nil {
+			if config.Revoke {
+				log.Infof("%s: revoked access for service account '%s'", cluster, serviceAccountName)
+				result.Action = ActionRevoked
+				return nil, nil
+			}
+			deleted = true
+		} else {
+			if errors.IsNotFound(err) && !config.Create {
+				log.Debugf("%s: service account '%s' not found", cluster, serviceAccountName)
+				result.Action = ActionNotFound
+			} else {
+				return nil, fmt.Errorf("while deleting service account: %s", err)
+			}
+		}
+	}
+
+	// create service account
+	if (rotate || config.Create) && !rotatedTwoPhase {
+		_, err = CreateServiceAccount(client, namespace, serviceAccountName, team, annotations)
+		if err != nil {
+			if errors.IsAlreadyExists(err) {
+				log.Debugf("%s: service account '%s' already exists", cluster, serviceAccountName)
+				result.Action = ActionUnchanged
+			} else {
+				return nil, fmt.Errorf("while creating service account: %s", err)
+			}
+		} else {
+			created = true
+			if rotate && deleted {
+				log.Infof("%s: rotated token for service account '%s'", cluster, serviceAccountName)
+				result.Action = ActionRotated
+			} else if config.Create {
+				log.Infof("%s: created service account '%s'", cluster, serviceAccountName)
+				result.Action = ActionCreated
+			}
+		}
+
+		err = createRBAC(ctx, cluster, client, namespace, serviceAccountName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// get service account for this team
+	_, err = ServiceAccount(client, namespace, serviceAccountName)
+	if err != nil {
+		return nil, fmt.Errorf("while retrieving service account: %s", err)
+	}
+
+	token, secret, err := serviceAccountToken(ctx, cluster, client, namespace, serviceAccountName, created)
+	if err != nil {
+		return nil, err
+	}
+	if secret != nil {
+		result.Secret = secret.Name
+	}
+
+	if len(result.Action) == 0 {
+		result.Action = ActionRetrieved
+	}
+
+	credentials, err := clusterCredentials(ctx, team, cluster, clientConfig, client, namespace, secret)
+	if err != nil {
+		return nil, err
+	}
+	credentials.Token = token
+
+	if config.Verify {
+		err = verifyCredentials(ctx, clientConfig, credentials, namespace)
+		if err != nil {
+			return nil, err
+		}
+		log.Debugf("%s: verified credentials for service account '%s'", cluster, serviceAccountName)
+	}
+
+	return credentials, nil
+}
+
+// generateTeam runs the configured service account operation for a single team in all clusters,
+// and writes the resulting Kubeconfig file. The outcome in each cluster is added to report,
+// and sent to the configured notifiers.
+func generateTeam(ctx context.Context, team string, report *TeamReport) error {
+	err := generateTeamClusters(ctx, team, report)
+	if err != nil {
+		report.Error = err.Error()
+	}
+	notify(ctx, report)
+	return err
+}
+
+func generateTeamClusters(ctx context.Context, team string, report *TeamReport) error {
+	config := configFrom(ctx)
+
+	// Fail before making any changes, so that rotated tokens are not lost.
+	if !config.Revoke && !config.DryRun {
+		if err := checkOutputPaths(ctx, team); err != nil {
+			return err
+		}
+	}
+
+	credentials, err := teamCredentials(ctx, team, report)
+	if err != nil {
+		if config.RollbackOnFailure {
+			rollBackRotations(ctx, report)
+		}
+		return err
+	}
+
+	if config.DryRun || config.Revoke {
+		return nil
+	}
+
+	err = writeKubeconfigs(ctx, team, credentials)
+	if config.RollbackOnFailure && !config.TwoPhase {
+		// Sinks may already hold the new tokens, so they are not rolled back. Keep both valid instead.
+		if err != nil {
+			log.Warnf("team %s: previous tokens are kept valid, since not all destinations were written; run finish-rotation once the new Kubeconfig file has been delivered", team)
+		} else {
+			completeRotations(ctx, report)
+		}
+	}
+	return err
+}
+
+// teamCredentials runs the configured service account operation for a single team in all
+// clusters, and returns the credentials from each cluster. The outcome in each cluster is
+// added to report. With --canary-clusters, the canary clusters are done first, and the
+// remaining clusters are skipped if any of them fail.
+func teamCredentials(ctx context.Context, team string, report *TeamReport) ([]*Credentials, error) {
+	config := configFrom(ctx)
+	credentials := make([]*Credentials, len(config.Clusters))
+	report.Clusters = make([]*ClusterResult, len(config.Clusters))
+
+	canaries, others, err := config.canaryClusters()
+	if err == nil && len(canaries) > 0 {
+		err = clusterListCredentials(ctx, team, canaries, credentials, report)
+		if err != nil {
+			err = fmt.Errorf("canary %s; remaining clusters were skipped", err)
+		} else {
+			log.Infof("team %s: canary clusters succeeded; continuing with the remaining clusters", team)
+		}
+	}
+	if err == nil {
+		err = clusterListCredentials(ctx, team, others, credentials, report)
+	}
+
+	for i, result := range report.Clusters {
+		if result == nil {
+			report.Clusters[i] = NewClusterResult(team, config.Clusters[i])
+			report.Clusters[i].Action = ActionSkipped
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+// clusterListCredentials runs the configured service account operation for a single team in the
+// clusters with the given indices, storing the credentials and outcome in each of them.
+func clusterListCredentials(ctx context.Context, team string, indices []int, credentials []*Credentials, report *TeamReport) error {
+	failed := make([]string, 0)
+	var mutex sync.Mutex
+	teamCtx := ctx
+
+	err := forClusters(ctx, indices, func(ctx context.Context, i int, cluster string) error {
+		log.Debugf("%s: entering cluster", cluster)
+
+		result := NewClusterResult(team, cluster)
+		report.Clusters[i] = result
+
+		ctx, tracker := withAuthTracker(ctx)
+		ctx, span := startClusterSpan(ctx, "clusterExec", team, cluster)
+		c, err := clusterExec(ctx, team, cluster, result)
+		endSpan(span, err)
+		if err != nil {
+			result.Error = err.Error()
+			result.AuthFailure = tracker.Rejected()
+		}
+
+		if action := configFrom(ctx).auditAction(); auditor != nil && len(action) > 0 {
+			auditor.Record(teamCtx, team, cluster, action, err)
+		}
+
+		if err == nil {
+			log.Debugf("%s: successfully generated configuration", cluster)
+			credentials[i] = c
+		} else {
+			mutex.Lock()
+			failed = append(failed, cluster)
+			mutex.Unlock()
+		}
+		return err
+	})
+
+	if err != nil {
+		sort.Strings(failed)
+		return fmt.Errorf("failed in %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// generateTeams runs generateTeam for every team in --teams-file.
+func generateTeams(ctx context.Context, report *Report) error {
+	teams, err := ReadTeamsFile(config.TeamsFile)
+	if err != nil {
+		return fmt.Errorf("while reading teams: %s", err)
+	}
+	return generateTeamList(ctx, teams, report)
+}
+
+// generateTeamList runs generateTeam for each of the teams, in batches of --batch-size teams
+// running in parallel. With --spread, the batches are spread evenly over that window, each
+// starting at a random point within its share of it, so that clusters are not hammered and a
+// bad rotation does not break every team at once. Teams not yet processed when the context is
+// cancelled, or after a failed batch with --halt-on-failure, are reported as skipped, and are not
+// counted as failed.
+func generateTeamList(ctx context.Context, teams []string, report *Report) error {
+	config := configFrom(ctx)
+	teamReports := make([]*TeamReport, len(teams))
+	for i, team := range teams {
+		teamReports[i] = &TeamReport{Team: team}
+		report.Teams = append(report.Teams, teamReports[i])
+	}
+
+	batchSize := config.BatchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	batches := (len(teams) + batchSize - 1) / batchSize
+	started := time.Now()
+	failures, skipped := 0, 0
+	halted := false
+
+	for batch := 0; batch < batches; batch++ {
+		first := batch * batchSize
+		last := first + batchSize
+		if last > len(teams) {
+			last = len(teams)
+		}
+
+		if !halted && config.Spread > 0 {
+			waitForBatch(ctx, started, batch, batches)
+		}
+
+		var wg sync.WaitGroup
+		errs := make([]error, last-first)
+		for i := first; i < last; i++ {
+			if halted || ctx.Err() != nil {
+				teamReports[i].Skipped = true
+				skipped++
+				continue
+			}
+
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				log.Infof("team %s: processing %d clusters", teams[i], len(config.Clusters))
+				errs[i-first] = generateTeam(ctx, teams[i], teamReports[i])
+			}(i)
+		}
+		wg.Wait()
+
+		for i, err := range errs {
+			if err != nil {
+				log.Errorf("team %s: %s", teams[first+i], err)
+				failures++
+				if config.HaltOnFailure && !halted {
+					log.Warnf("halting after failure; remaining teams are skipped")
+					halted = true
+				}
+			}
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d teams failed", failures, len(teams))
+	}
+	// Teams are only skipped without failures if the run was interrupted.
+	if skipped > 0 {
+		return fmt.Errorf("interrupted; %d of %d teams were skipped", skipped, len(teams))
+	}
+
+	return nil
+}
+
+// waitForBatch waits until the batch is due, at a random point in its share of --spread.
+func waitForBatch(ctx context.Context, started time.Time, batch, batches int) {
+	slot := configFrom(ctx).Spread / time.Duration(batches)
+	due := started.Add(slot * time.Duration(batch))
+	if slot > 0 {
+		due = due.Add(time.Duration(rand.Int63n(int64(slot))))
+	}
+
+	wait := time.Until(due)
+	if wait < time.Second {
+		time.Sleep(wait)
+		return
+	}
+	log.Infof("waiting %s before batch %d of %d", wait.Round(time.Second), batch+1, batches)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// generateTeamsOrTeam runs generateTeams if --teams-file is given, and generateTeam otherwise.
+func generateTeamsOrTeam(ctx context.Context, report *Report) error {
+	if len(config.TeamsFile) > 0 {
+		return generateTeams(ctx, report)
+	}
+
+	teamReport := &TeamReport{Team: config.Team}
+	report.Teams = append(report.Teams, teamReport)
+	err := generateTeam(ctx, config.Team, teamReport)
+	if err != nil {
+		log.Errorf("%s", err)
+		return fmt.Errorf("exiting due to errors")
+	}
+	return nil
+}
+
+// validateGenerate checks the configuration before any changes are made.
+func validateGenerate() error {
+	if config.Revoke && (config.Create || config.Rotate) {
+		return fmt.Errorf("--revoke is mutually exclusive with --create and --rotate")
+	}
+
+	if config.CreateRole && len(config.Role) > 0 {
+		return fmt.Errorf("--create-role is mutually exclusive with --role")
+	}
+
+	if (len(config.TokenAudiences) > 0 || config.TokenTTL > 0) && !config.TokenRequest {
+		return fmt.Errorf("--token-audience and --token-ttl require --token-request")
+	}
+
+	if config.GracePeriod < 0 {
+		return fmt.Errorf("--grace-period must not be negative")
+	}
+
+	if (config.TwoPhase || config.RollbackOnFailure) && config.TokenRequest {
+		return fmt.Errorf("--two-phase, --grace-period and --rollback-on-failure cannot be used with --token-request, since minted tokens cannot be invalidated one at a time")
+	}
+
+	if len(config.TeamsFile) > 0 {
+		if len(config.Team) > 0 {
+			return fmt.Errorf("--team is mutually exclusive with --teams-file")
+		}
+		if len(config.Output) > 0 {
+			return fmt.Errorf("--output cannot be used with --teams-file; use --output-dir instead")
+		}
+	}
+
+	validators := []func() error{
+		validateAuth,
+		validateCanary,
+		validateContextTemplate,
+		validateEncryption,
+		validateOutputFormat,
+		validateExternalSecret,
+		validateBundle,
+		validateGitHub,
+		validateGitLab,
+		validateKubernetesSecret,
+		validateSinks,
+	}
+	for _, validate := range validators {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeReport prints a summary of the outcome in each cluster, and writes the report to --report if given.
+// If teams is true, the summary includes the team of each row.
+func writeReport(report *Report, teams bool) {
+	report.PrintSummary(os.Stderr, teams)
+	if len(config.Report) > 0 {
+		if err := report.Write(config.Report); err != nil {
+			log.Errorf("while writing report: %s", err)
+		}
+	}
+}
+
+// generate runs the configured service account operation in all clusters,
+// and writes the resulting Kubeconfig files.
+func generate(ctx context.Context) error {
+	if err := validateGenerate(); err != nil {
+		return validationError(err)
+	}
+
+	if err := openAuditor(); err != nil {
+		return err
+	}
+	if auditor != nil {
+		defer auditor.Close()
+	}
+	if err := openNotifiers(); err != nil {
+		return err
+	}
+	defer closeNotifiers()
+
+	report := NewReport(ctx)
+	err := generateTeamsOrTeam(ctx, report)
+	writeReport(report, len(config.TeamsFile) > 0)
+	if err != nil {
+		return &ExitError{Code: report.ExitCode(), Err: err}
+	}
+
+	if config.DryRun {
+		log.Infof("dry run complete; no changes were made")
+	} else if config.Revoke {
+		log.Infof("successfully revoked keys")
+	} else if config.Rotate && config.GracePeriod > 0 {
+		log.Warnf("previous tokens stay valid until the next run of finish-rotation --expired-only, reconcile or rotate-daemon after %s; make sure one of them is scheduled", formatTime(time.Now().Add(config.GracePeriod)))
+	}
+
+	return nil
+}
+
+// rotateAll rotates every team with a service account in any of the clusters, e.g. after a suspected leak.
+func rotateAll(ctx context.Context) error {
+	config.Rotate = true
+
+	if err := validateGenerate(); err != nil {
+		return validationError(err)
+	}
+	if err := validatePerTeamSinks(); err != nil {
+		return validationError(err)
+	}
+
+	teams, err := discoverTeams(ctx)
+	if err != nil {
+		return fmt.Errorf("while discovering teams: %s; use --exclude-clusters to skip unreachable clusters", err)
+	}
+	if len(teams) == 0 {
+		log.Infof("no team service users found")
+		return nil
+	}
+	log.Infof("rotating %d teams: %s", len(teams), strings.Join(teams, ", "))
+
+	if err := openAuditor(); err != nil {
+		return err
+	}
+	if auditor != nil {
+		defer auditor.Close()
+	}
+	if err := openNotifiers(); err != nil {
+		return err
+	}
+	defer closeNotifiers()
+
+	report := NewReport(ctx)
+	err = generateTeamList(ctx, teams, report)
+	writeReport(report, true)
+	if err != nil {
+		return &ExitError{Code: report.ExitCode(), Err: err}
+	}
+
+	if config.DryRun {
+		log.Infof("dry run complete; no changes were made")
+	}
+	return nil
+}
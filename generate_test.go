@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// fakeClient returns a fake clientset holding objects. Like the API server and the token
+// controller, it names secrets created with a generated name, and fills token secrets with a token.
+func fakeClient(objects ...runtime.Object) *fake.Clientset {
+	client := fake.NewSimpleClientset(objects...)
+	generated := 0
+	client.PrependReactor("create", "secrets", func(action ktesting.Action) (bool, runtime.Object, error) {
+		secret := action.(ktesting.CreateAction).GetObject().(*v1.Secret)
+		if len(secret.Name) == 0 && len(secret.GenerateName) > 0 {
+			generated++
+			secret.Name = fmt.Sprintf("%s%05d", secret.GenerateName, generated)
+		}
+		if secret.Type == v1.SecretTypeServiceAccountToken {
+			secret.Data = map[string][]byte{"token": []byte("token-" + secret.Name)}
+		}
+		err := client.Tracker().Create(v1.SchemeGroupVersion.WithResource("secrets"), secret, action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		return true, secret, nil
+	})
+	return client
+}
+
+// teamObjects returns the service account of a team referencing a populated token secret.
+func teamObjects(namespace, team, secret string) []runtime.Object {
+	serviceAccountName := ServiceAccountName(team)
+	return []runtime.Object{
+		&v1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{Name: serviceAccountName, Namespace: namespace},
+			Secrets:    []v1.ObjectReference{{Name: secret}},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        secret,
+				Namespace:   namespace,
+				Annotations: map[string]string{v1.ServiceAccountNameKey: serviceAccountName},
+			},
+			Type: v1.SecretTypeServiceAccountToken,
+			Data: map[string][]byte{"token": []byte("token-" + secret)},
+		},
+	}
+}
+
+func TestConcurrentOperations(t *testing.T) {
+	for _, test := range []struct {
+		operator    string
+		gracePeriod time.Duration
+		role        string
+	}{
+		{operator: "alice", gracePeriod: time.Hour, role: "deploy"},
+		{operator: "bob"},
+	} {
+		test := test
+		t.Run(test.operator, func(t *testing.T) {
+			t.Parallel()
+
+			c := *config
+			c.AuditOperator = test.operator
+			c.GracePeriod = test.gracePeriod
+			c.TwoPhase = true
+			c.Role = test.role
+			c.CreateRole = false
+			c.ClusterRole = ""
+			c.TokenTimeout = 5 * time.Second
+			ctx := withConfig(context.Background(), &c)
+
+			client := fakeClient(teamObjects("default", "aura", "serviceuser-aura-token-abcde")...)
+			rollback, err := rotateTwoPhase(ctx, "dev-fss", client, "default", "serviceuser-aura", "aura")
+			if err != nil {
+				t.Fatalf("rotateTwoPhase() error: %s", err)
+			}
+			if rollback == nil || rollback.Previous != "serviceuser-aura-token-abcde" {
+				t.Fatalf("rotateTwoPhase() rollback = %+v", rollback)
+			}
+			err = createRBAC(ctx, "dev-fss", client, "default", "serviceuser-aura")
+			if err != nil {
+				t.Fatalf("createRBAC() error: %s", err)
+			}
+
+			serviceAccount, err := ServiceAccount(client, "default", "serviceuser-aura")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens, err := previousTokens(*serviceAccount)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tokens) != 1 {
+				t.Fatalf("previous tokens = %v, want one", tokens)
+			}
+			if expires := tokens[0].ExpiresAt != nil; expires != (test.gracePeriod > 0) {
+				t.Errorf("previous token expires = %t with grace period %s", expires, test.gracePeriod)
+			}
+
+			history, err := serviceAccountHistory(*serviceAccount)
+			if err != nil || len(history) == 0 {
+				t.Fatalf("history = %v, %v", history, err)
+			}
+			if operator := history[len(history)-1].Operator; operator != test.operator {
+				t.Errorf("rotation recorded by %s, want %s", operator, test.operator)
+			}
+
+			binding, err := client.RbacV1().RoleBindings("default").Get("serviceuser-aura", metav1.GetOptions{})
+			if len(test.role) == 0 {
+				if err == nil {
+					t.Errorf("role binding created without --role")
+				}
+			} else if err != nil {
+				t.Errorf("role binding not created: %s", err)
+			} else if binding.RoleRef.Name != test.role {
+				t.Errorf("role binding refers to %s, want %s", binding.RoleRef.Name, test.role)
+			}
+		})
+	}
+}
+
+func TestGenerateTeamListInterrupted(t *testing.T) {
+	c := *config
+	c.BatchSize = 2
+	c.Spread = 0
+	ctx, cancel := context.WithCancel(withConfig(context.Background(), &c))
+	cancel()
+
+	report := &Report{}
+	err := generateTeamList(ctx, []string{"aura", "bris", "tbd"}, report)
+	if err == nil || !strings.HasPrefix(err.Error(), "interrupted") {
+		t.Errorf("generateTeamList() error = %v, want interrupted", err)
+	}
+	for _, team := range report.Teams {
+		if !team.Skipped || len(team.Error) > 0 {
+			t.Errorf("team %s: skipped = %t, error = %q", team.Team, team.Skipped, team.Error)
+		}
+	}
+}
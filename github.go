@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const defaultGitHubAPI = "https://api.github.com"
+
+// GitHubClient uploads GitHub Actions secrets to a repository or an organization.
+type GitHubClient struct {
+	API    string
+	Token  string
+	Client *http.Client
+}
+
+func (g *GitHubClient) request(ctx context.Context, method, path string, body, result interface{}) error {
+	headers := map[string]string{
+		"Accept":        "application/vnd.github+json",
+		"Authorization": "Bearer " + g.Token,
+	}
+	return jsonRequest(ctx, g.Client, method, strings.TrimSuffix(g.API, "/")+path, headers, body, result)
+}
+
+// gitHubAppJWT creates a JSON Web Token authenticating as a GitHub App.
+func gitHubAppJWT(appID string, key *rsa.PrivateKey) (string, error) {
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": appID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoding := base64.RawURLEncoding
+	unsigned := encoding.EncodeToString(header) + "." + encoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + encoding.EncodeToString(signature), nil
+}
+
+func parseRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s does not contain an RSA private key", path)
+	}
+	return rsaKey, nil
+}
+
+// NewGitHubClient returns a client authenticated either as a GitHub App installation, if an app ID
+// is configured, or with the token in the GITHUB_TOKEN environment variable.
+func NewGitHubClient(ctx context.Context) (*GitHubClient, error) {
+	client := &GitHubClient{
+		API:    config.GitHub.API,
+		Client: &http.Client{},
+	}
+
+	if len(config.GitHub.AppID) == 0 {
+		client.Token = os.Getenv("GITHUB_TOKEN")
+		if len(client.Token) == 0 {
+			return nil, fmt.Errorf("GITHUB_TOKEN must be set, or GitHub App credentials configured")
+		}
+		return client, nil
+	}
+
+	key, err := parseRSAPrivateKey(config.GitHub.AppPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("while reading GitHub App private key: %s", err)
+	}
+	client.Token, err = gitHubAppJWT(config.GitHub.AppID, key)
+	if err != nil {
+		return nil, err
+	}
+
+	installationToken := struct {
+		Token string `json:"token"`
+	}{}
+	path := fmt.Sprintf("/app/installations/%s/access_tokens", config.GitHub.AppInstallationID)
+	err = client.request(ctx, http.MethodPost, path, nil, &installationToken)
+	if err != nil {
+		return nil, fmt.Errorf("while creating GitHub App installation token: %s", err)
+	}
+	client.Token = installationToken.Token
+
+	return client, nil
+}
+
+// gitHubPublicKey is the key that GitHub Actions secrets must be encrypted with.
+type gitHubPublicKey struct {
+	KeyID string `json:"key_id"`
+	Key   string `json:"key"`
+}
+
+// seal encrypts a secret value with the public key, using a libsodium sealed box.
+func (k gitHubPublicKey) seal(value []byte) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(k.Key)
+	if err != nil || len(decoded) != 32 {
+		return "", fmt.Errorf("invalid public key from GitHub")
+	}
+	var publicKey [32]byte
+	copy(publicKey[:], decoded)
+
+	sealed, err := box.SealAnonymous(nil, value, &publicKey, rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// SetSecrets encrypts and uploads Actions secrets to the secrets endpoint of a repository
+// ("/repos/<owner>/<repo>") or an organization ("/orgs/<org>").
+func (g *GitHubClient) SetSecrets(ctx context.Context, owner string, secrets map[string][]byte, visibility string) error {
+	publicKey := gitHubPublicKey{}
+	err := g.request(ctx, http.MethodGet, owner+"/actions/secrets/public-key", nil, &publicKey)
+	if err != nil {
+		return fmt.Errorf("while retrieving public key: %s", err)
+	}
+
+	for name, value := range secrets {
+		encrypted, err := publicKey.seal(value)
+		if err != nil {
+			return fmt.Errorf("while encrypting secret %s: %s", name, err)
+		}
+		body := map[string]string{
+			"encrypted_value": encrypted,
+			"key_id":          publicKey.KeyID,
+		}
+		if len(visibility) > 0 {
+			body["visibility"] = visibility
+		}
+		err = g.request(ctx, http.MethodPut, owner+"/actions/secrets/"+name, body, nil)
+		if err != nil {
+			return fmt.Errorf("while uploading secret %s: %s", name, err)
+		}
+		log.Debugf("uploaded GitHub Actions secret %s to %s", name, owner)
+	}
+
+	return nil
+}
+
+// secretName turns a cluster name into a valid secret name suffix, e.g. dev-fss into DEV_FSS.
+func secretName(cluster string) string {
+	return strings.ToUpper(strings.Replace(cluster, "-", "_", -1))
+}
+
+// writeGitHub uploads the Kubeconfig file and/or the token of each cluster as GitHub Actions secrets.
+func writeGitHub(ctx context.Context, team string, kubeconfig []byte, credentials []*Credentials) error {
+	config := configFrom(ctx)
+	client, err := NewGitHubClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	secrets := make(map[string][]byte)
+	for _, field := range config.GitHub.Fields {
+		switch field {
+		case "kubeconfig":
+			secrets[config.GitHub.SecretName] = kubeconfig
+		case "tokens":
+			for _, c := range credentials {
+				if c != nil {
+					secrets[fmt.Sprintf("%s_TOKEN_%s", config.GitHub.SecretName, secretName(c.Cluster))] = []byte(c.Token)
+				}
+			}
+		default:
+			return fmt.Errorf("unknown GitHub field '%s'; must be one of kubeconfig, tokens", field)
+		}
+	}
+
+	var owner, visibility string
+	if len(config.GitHub.Repo) > 0 {
+		owner = "/repos/" + strings.Replace(config.GitHub.Repo, "{team}", team, -1)
+	} else {
+		owner = "/orgs/" + config.GitHub.Org
+		visibility = config.GitHub.Visibility
+	}
+
+	err = client.SetSecrets(ctx, owner, secrets, visibility)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("credentials uploaded as GitHub Actions secrets to %s", strings.TrimPrefix(owner, "/"))
+	return nil
+}
+
+// validateGitHub checks the GitHub flags before any changes are made.
+func validateGitHub() error {
+	if len(config.GitHub.Repo) > 0 && len(config.GitHub.Org) > 0 {
+		return fmt.Errorf("--github-repo is mutually exclusive with --github-org")
+	}
+	if len(config.GitHub.AppID) > 0 && (len(config.GitHub.AppInstallationID) == 0 || len(config.GitHub.AppPrivateKey) == 0) {
+		return fmt.Errorf("--github-app-id requires --github-app-installation-id and --github-app-private-key")
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultGitLabAPI = "https://gitlab.com/api/v4"
+
+// GitLabClient manages CI/CD variables of GitLab projects and groups.
+type GitLabClient struct {
+	API    string
+	Token  string
+	Client *http.Client
+}
+
+// GitLabVariable is a CI/CD variable as represented by the GitLab API.
+type GitLabVariable struct {
+	Key          string `json:"key"`
+	Value        string `json:"value"`
+	VariableType string `json:"variable_type"`
+	Protected    bool   `json:"protected"`
+	Masked       bool   `json:"masked"`
+}
+
+// NewGitLabClientFromEnvironment returns a client authenticated with the token in GITLAB_TOKEN.
+func NewGitLabClientFromEnvironment() (*GitLabClient, error) {
+	token := os.Getenv("GITLAB_TOKEN")
+	if len(token) == 0 {
+		return nil, fmt.Errorf("GITLAB_TOKEN must be set")
+	}
+	return &GitLabClient{
+		API:    config.GitLab.API,
+		Token:  token,
+		Client: &http.Client{},
+	}, nil
+}
+
+func (g *GitLabClient) request(ctx context.Context, method, path string, body, result interface{}) error {
+	headers := map[string]string{
+		"PRIVATE-TOKEN": g.Token,
+	}
+	return jsonRequest(ctx, g.Client, method, strings.TrimSuffix(g.API, "/")+path, headers, body, result)
+}
+
+// SetVariable creates or updates a CI/CD variable. The owner is the variables endpoint
+// of a project ("/projects/<id>") or a group ("/groups/<id>").
+func (g *GitLabClient) SetVariable(ctx context.Context, owner string, variable GitLabVariable) error {
+	path := owner + "/variables/" + url.PathEscape(variable.Key)
+
+	err := g.request(ctx, http.MethodGet, path, nil, nil)
+	if err == nil {
+		return g.request(ctx, http.MethodPut, path, variable, nil)
+	}
+	log.Debugf("variable %s not found in %s, creating it: %s", variable.Key, owner, err)
+
+	return g.request(ctx, http.MethodPost, owner+"/variables", variable, nil)
+}
+
+// writeGitLab stores the Kubeconfig file and/or the token of each cluster as GitLab CI/CD variables.
+// The Kubeconfig file is stored as a file variable, since GitLab cannot mask multi-line values.
+func writeGitLab(ctx context.Context, team string, kubeconfig []byte, credentials []*Credentials) error {
+	config := configFrom(ctx)
+	client, err := NewGitLabClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	variables := make([]GitLabVariable, 0)
+	for _, field := range config.GitLab.Fields {
+		switch field {
+		case "kubeconfig":
+			variables = append(variables, GitLabVariable{
+				Key:          config.GitLab.VariableName,
+				Value:        string(kubeconfig),
+				VariableType: "file",
+				Protected:    config.GitLab.Protected,
+			})
+		case "tokens":
+			for _, c := range credentials {
+				if c != nil {
+					variables = append(variables, GitLabVariable{
+						Key:          fmt.Sprintf("%s_TOKEN_%s", config.GitLab.VariableName, secretName(c.Cluster)),
+						Value:        c.Token,
+						VariableType: "env_var",
+						Protected:    config.GitLab.Protected,
+						Masked:       true,
+					})
+				}
+			}
+		default:
+			return fmt.Errorf("unknown GitLab field '%s'; must be one of kubeconfig, tokens", field)
+		}
+	}
+
+	var owner string
+	if len(config.GitLab.Project) > 0 {
+		owner = "/projects/" + url.PathEscape(strings.Replace(config.GitLab.Project, "{team}", team, -1))
+	} else {
+		owner = "/groups/" + url.PathEscape(strings.Replace(config.GitLab.Group, "{team}", team, -1))
+	}
+
+	for _, variable := range variables {
+		err = client.SetVariable(ctx, owner, variable)
+		if err != nil {
+			return fmt.Errorf("while setting variable %s: %s", variable.Key, err)
+		}
+	}
+
+	log.Infof("credentials stored as GitLab CI/CD variables in %s", strings.TrimPrefix(owner, "/"))
+	return nil
+}
+
+// validateGitLab checks the GitLab flags before any changes are made.
+func validateGitLab() error {
+	if len(config.GitLab.Project) > 0 && len(config.GitLab.Group) > 0 {
+		return fmt.Errorf("--gitlab-project is mutually exclusive with --gitlab-group")
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// GitOpsConfig configures rendering team service accounts as manifests in a Git repository,
+// for a GitOps tool such as Argo CD or Flux to apply, instead of creating them directly.
+type GitOpsConfig struct {
+	Repo   string
+	Path   string
+	Commit bool
+	Push   bool
+}
+
+// manifestHeader is written at the top of every rendered manifest file.
+const manifestHeader = "# Generated by teamconfig. Changes will be overwritten.\n"
+
+// teamManifests returns the manifests of a team service account, its RBAC objects and its token
+// secret in a single cluster, as a multi-document YAML file. The token secret is filled in by
+// the token controller once applied, so the manifests contain no credentials.
+func teamManifests(team, cluster string) ([]byte, error) {
+	namespace := config.serviceAccountNamespace(cluster)
+	serviceAccountName := ServiceAccountName(team)
+	rbacTypeMeta := func(kind string) metav1.TypeMeta {
+		return metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: kind}
+	}
+
+	serviceAccount := ServiceAccountObject(namespace, serviceAccountName, team, nil)
+	serviceAccount.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"}
+	objects := []interface{}{serviceAccount}
+
+	if config.CreateRole {
+		role := RoleObject(namespace, serviceAccountName, DeployRules)
+		role.TypeMeta = rbacTypeMeta("Role")
+		objects = append(objects, role)
+	}
+	if config.CreateRole || len(config.Role) > 0 {
+		roleBinding := RoleBindingObject(namespace, serviceAccountName, "Role", config.roleName(serviceAccountName), serviceAccountName)
+		roleBinding.TypeMeta = rbacTypeMeta("RoleBinding")
+		objects = append(objects, roleBinding)
+	}
+	if len(config.ClusterRole) > 0 {
+		name := config.clusterRoleBindingName(serviceAccountName)
+		if config.ClusterWide {
+			binding := ClusterRoleBindingObject(name, config.ClusterRole, namespace, serviceAccountName)
+			binding.TypeMeta = rbacTypeMeta("ClusterRoleBinding")
+			objects = append(objects, binding)
+		} else {
+			binding := RoleBindingObject(namespace, name, "ClusterRole", config.ClusterRole, serviceAccountName)
+			binding.TypeMeta = rbacTypeMeta("RoleBinding")
+			objects = append(objects, binding)
+		}
+	}
+
+	secret := TokenSecretObject(namespace, serviceAccountName, team)
+	secret.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}
+	objects = append(objects, secret)
+
+	buf := bytes.NewBufferString(manifestHeader)
+	for _, object := range objects {
+		data, err := yaml.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString("---\n")
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// gitopsPath returns the path of the manifest file of a team in a cluster, relative to --gitops-repo.
+func gitopsPath(team, cluster string) string {
+	replacer := strings.NewReplacer("{team}", team, "{cluster}", cluster)
+	return filepath.Clean(replacer.Replace(config.GitOps.Path))
+}
+
+// runGit runs git in --gitops-repo. Output from git is written to standard error.
+func runGit(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", config.GitOps.Repo}, args...)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("git %s: %s", args[0], err)
+	}
+	return nil
+}
+
+// gitopsCommitMessage describes the rendered teams in a commit message.
+func gitopsCommitMessage(teams []string) string {
+	verb := "Render"
+	if config.Revoke {
+		verb = "Remove"
+	}
+	if len(teams) > 5 {
+		return fmt.Sprintf("%s service users of %d teams", verb, len(teams))
+	}
+	return fmt.Sprintf("%s service users of team %s", verb, strings.Join(teams, ", "))
+}
+
+// commitManifests commits the rendered files, if they changed, and pushes the commit with --gitops-push.
+func commitManifests(ctx context.Context, teams []string, paths []string) error {
+	err := runGit(ctx, append([]string{"add", "--all", "--"}, paths...)...)
+	if err != nil {
+		return err
+	}
+
+	// diff exits with status 1 if anything is staged
+	err = exec.CommandContext(ctx, "git", "-C", config.GitOps.Repo, "diff", "--cached", "--quiet").Run()
+	if err == nil {
+		log.Infof("manifests are unchanged; nothing to commit")
+		return nil
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		return fmt.Errorf("git diff: %s", err)
+	}
+
+	err = runGit(ctx, "commit", "--quiet", "--message", gitopsCommitMessage(teams))
+	if err != nil {
+		return err
+	}
+	log.Infof("committed manifests to %s", config.GitOps.Repo)
+
+	if !config.GitOps.Push {
+		return nil
+	}
+	err = runGit(ctx, "push", "--quiet")
+	if err != nil {
+		return err
+	}
+	log.Infof("pushed manifests")
+	return nil
+}
+
+// validateGitOps checks the configuration before any files are written.
+func validateGitOps() error {
+	if len(config.GitOps.Repo) == 0 {
+		return fmt.Errorf("--gitops-repo must be specified")
+	}
+	if !strings.Contains(config.GitOps.Path, "{team}") || !strings.Contains(config.GitOps.Path, "{cluster}") {
+		return fmt.Errorf("--gitops-path must contain both {team} and {cluster}")
+	}
+	if config.GitOps.Push && !config.GitOps.Commit {
+		return fmt.Errorf("--gitops-push requires --gitops-commit")
+	}
+	if config.CreateRole && len(config.Role) > 0 {
+		return fmt.Errorf("--create-role is mutually exclusive with --role")
+	}
+	return nil
+}
+
+// gitops writes the manifests of team service accounts in all clusters to --gitops-repo, or
+// removes them with --revoke, instead of changing the clusters directly.
+func gitops(ctx context.Context) error {
+	if err := validateGitOps(); err != nil {
+		return validationError(err)
+	}
+
+	teams := []string{config.Team}
+	if len(config.TeamsFile) > 0 {
+		var err error
+		teams, err = ReadTeamsFile(config.TeamsFile)
+		if err != nil {
+			return fmt.Errorf("while reading teams: %s", err)
+		}
+	}
+
+	paths := make([]string, 0)
+	for _, team := range teams {
+		for _, cluster := range config.Clusters {
+			path := gitopsPath(team, cluster)
+			fullPath := filepath.Join(config.GitOps.Repo, path)
+			paths = append(paths, path)
+
+			if config.Revoke {
+				err := os.Remove(fullPath)
+				if os.IsNotExist(err) {
+					continue
+				} else if err != nil {
+					return err
+				}
+				log.Infof("%s: removed %s", cluster, path)
+				continue
+			}
+
+			data, err := teamManifests(team, cluster)
+			if err != nil {
+				return fmt.Errorf("team %s: while rendering manifests: %s", team, err)
+			}
+			err = os.MkdirAll(filepath.Dir(fullPath), 0755)
+			if err != nil {
+				return err
+			}
+			err = WriteFileAtomic(fullPath, data, true)
+			if err != nil {
+				return err
+			}
+			log.Infof("%s: wrote %s", cluster, path)
+		}
+	}
+
+	if !config.GitOps.Commit {
+		return nil
+	}
+	return commitManifests(ctx, teams, paths)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	grpcstatus "google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// The messages of the gRPC API are declared by hand, with struct tags matching api/teamconfig.proto,
+// so that building teamconfig does not require protoc. TestMessagesMatchProto checks that they are
+// kept in sync with the proto file.
+
+type TeamRequestMessage struct {
+	Team     string   `protobuf:"bytes,1,opt,name=team,proto3" json:"team,omitempty"`
+	Clusters []string `protobuf:"bytes,2,rep,name=clusters,proto3" json:"clusters,omitempty"`
+}
+
+func (m *TeamRequestMessage) Reset()         { *m = TeamRequestMessage{} }
+func (m *TeamRequestMessage) String() string { return fmt.Sprintf("%+v", *m) }
+func (*TeamRequestMessage) ProtoMessage()    {}
+
+type ClusterResultMessage struct {
+	Cluster        string `protobuf:"bytes,1,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	Namespace      string `protobuf:"bytes,2,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	ServiceAccount string `protobuf:"bytes,3,opt,name=service_account,json=serviceAccount,proto3" json:"service_account,omitempty"`
+	Secret         string `protobuf:"bytes,4,opt,name=secret,proto3" json:"secret,omitempty"`
+	Action         string `protobuf:"bytes,5,opt,name=action,proto3" json:"action,omitempty"`
+	Error          string `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
+	AuthFailure    bool   `protobuf:"varint,7,opt,name=auth_failure,json=authFailure,proto3" json:"auth_failure,omitempty"`
+}
+
+func (m *ClusterResultMessage) Reset()         { *m = ClusterResultMessage{} }
+func (m *ClusterResultMessage) String() string { return fmt.Sprintf("%+v", *m) }
+func (*ClusterResultMessage) ProtoMessage()    {}
+
+type TeamReportMessage struct {
+	Team     string                  `protobuf:"bytes,1,opt,name=team,proto3" json:"team,omitempty"`
+	Error    string                  `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	Clusters []*ClusterResultMessage `protobuf:"bytes,3,rep,name=clusters,proto3" json:"clusters,omitempty"`
+}
+
+func (m *TeamReportMessage) Reset()         { *m = TeamReportMessage{} }
+func (m *TeamReportMessage) String() string { return fmt.Sprintf("%+v", *m) }
+func (*TeamReportMessage) ProtoMessage()    {}
+
+type ClusterStatusMessage struct {
+	Cluster        string `protobuf:"bytes,1,opt,name=cluster,proto3" json:"cluster,omitempty"`
+	Namespace      string `protobuf:"bytes,2,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	ServiceAccount string `protobuf:"bytes,3,opt,name=service_account,json=serviceAccount,proto3" json:"service_account,omitempty"`
+	State          string `protobuf:"bytes,4,opt,name=state,proto3" json:"state,omitempty"`
+	LastRotated    int64  `protobuf:"varint,5,opt,name=last_rotated,json=lastRotated,proto3" json:"last_rotated,omitempty"`
+	TokenCreated   int64  `protobuf:"varint,6,opt,name=token_created,json=tokenCreated,proto3" json:"token_created,omitempty"`
+	TokenExpires   int64  `protobuf:"varint,7,opt,name=token_expires,json=tokenExpires,proto3" json:"token_expires,omitempty"`
+}
+
+func (m *ClusterStatusMessage) Reset()         { *m = ClusterStatusMessage{} }
+func (m *ClusterStatusMessage) String() string { return fmt.Sprintf("%+v", *m) }
+func (*ClusterStatusMessage) ProtoMessage()    {}
+
+// unixTime returns t in seconds since the Unix epoch, or zero for the zero time.
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// teamReportMessage converts the report of a single team to its gRPC representation.
+func teamReportMessage(report *TeamReport) *TeamReportMessage {
+	message := &TeamReportMessage{
+		Team:     report.Team,
+		Error:    report.Error,
+		Clusters: make([]*ClusterResultMessage, len(report.Clusters)),
+	}
+	for i, result := range report.Clusters {
+		message.Clusters[i] = &ClusterResultMessage{
+			Cluster:        result.Cluster,
+			Namespace:      result.Namespace,
+			ServiceAccount: result.ServiceAccount,
+			Secret:         result.Secret,
+			Action:         result.Action,
+			Error:          result.Error,
+			AuthFailure:    result.AuthFailure,
+		}
+	}
+	return message
+}
+
+// TeamConfigServer is the TeamConfig service in api/teamconfig.proto.
+type TeamConfigServer interface {
+	CreateTeam(ctx context.Context, req *TeamRequestMessage) (*TeamReportMessage, error)
+	RotateTeam(ctx context.Context, req *TeamRequestMessage) (*TeamReportMessage, error)
+	RevokeTeam(ctx context.Context, req *TeamRequestMessage) (*TeamReportMessage, error)
+	GetStatus(req *TeamRequestMessage, stream grpc.ServerStream) error
+}
+
+// GRPCServer implements the TeamConfig gRPC service on top of Server,
+// so that operations requested over gRPC and HTTP run one at a time.
+type GRPCServer struct {
+	Server *Server
+}
+
+// authorize authenticates the caller of a gRPC request, from its client certificate or the bearer
+// token in its authorization metadata, and checks that it may perform the operation on the team.
+func (g *GRPCServer) authorize(ctx context.Context, req *TeamRequestMessage, operation string) (*Caller, error) {
+	if len(validation.IsDNS1123Label(req.Team)) > 0 {
+		return nil, grpcstatus.Errorf(codes.InvalidArgument, "invalid team name '%s'", req.Team)
+	}
+
+	var state *tls.ConnectionState
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+	authorization := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+		authorization = md.Get("authorization")[0]
+	}
+
+	caller, err := g.Server.auth.Authenticate(ctx, state, authorization)
+	if err != nil {
+		return nil, grpcstatus.Errorf(codes.Unauthenticated, "unauthenticated: %s", err)
+	}
+	err = g.Server.auth.Authorize(caller, req.Team, operation)
+	if err != nil {
+		log.Warnf("%s: %s", caller, err)
+		return nil, grpcstatus.Error(codes.PermissionDenied, err.Error())
+	}
+	return caller, nil
+}
+
+// operate runs a service account operation on a team, and returns the team report.
+func (g *GRPCServer) operate(ctx context.Context, req *TeamRequestMessage, operation string) (*TeamReportMessage, error) {
+	caller, err := g.authorize(ctx, req, operation)
+	if err != nil {
+		return nil, err
+	}
+	report, err := g.Server.run(caller, req.Team, req.Clusters, operation)
+	if err != nil {
+		return nil, grpcstatus.Error(codes.InvalidArgument, err.Error())
+	}
+	return teamReportMessage(report.Teams[0]), nil
+}
+
+func (g *GRPCServer) CreateTeam(ctx context.Context, req *TeamRequestMessage) (*TeamReportMessage, error) {
+	return g.operate(ctx, req, OperationCreate)
+}
+
+func (g *GRPCServer) RotateTeam(ctx context.Context, req *TeamRequestMessage) (*TeamReportMessage, error) {
+	return g.operate(ctx, req, OperationRotate)
+}
+
+func (g *GRPCServer) RevokeTeam(ctx context.Context, req *TeamRequestMessage) (*TeamReportMessage, error) {
+	return g.operate(ctx, req, OperationRevoke)
+}
+
+// GetStatus sends the state of the team service account in each cluster as soon as it is known.
+func (g *GRPCServer) GetStatus(req *TeamRequestMessage, stream grpc.ServerStream) error {
+	caller, err := g.authorize(stream.Context(), req, OperationStatus)
+	if err != nil {
+		return err
+	}
+	c, err := g.Server.configure(caller, req.Team, req.Clusters, OperationStatus)
+	if err != nil {
+		return grpcstatus.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var mutex sync.Mutex
+	var sendErr error
+	forEachCluster(withConfig(stream.Context(), c), func(ctx context.Context, i int, cluster string) error {
+		serviceAccountStatus, err := clusterStatus(ctx, cluster)
+		if err != nil {
+			serviceAccountStatus = &ServiceAccountStatus{State: fmt.Sprintf("error: %s", err)}
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		if sendErr != nil {
+			return sendErr
+		}
+		sendErr = stream.SendMsg(&ClusterStatusMessage{
+			Cluster:        cluster,
+			Namespace:      c.serviceAccountNamespace(cluster),
+			ServiceAccount: ServiceAccountName(req.Team),
+			State:          serviceAccountStatus.State,
+			LastRotated:    unixTime(serviceAccountStatus.Created),
+			TokenCreated:   unixTime(serviceAccountStatus.TokenCreated),
+			TokenExpires:   unixTime(serviceAccountStatus.TokenExpires),
+		})
+		return err
+	})
+
+	return sendErr
+}
+
+// unaryHandler adapts a method of TeamConfigServer to a gRPC method handler.
+func unaryHandler(method string, call func(srv TeamConfigServer, ctx context.Context, req *TeamRequestMessage) (*TeamReportMessage, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := &TeamRequestMessage{}
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(TeamConfigServer), ctx, req.(*TeamRequestMessage))
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/teamconfig.v1.TeamConfig/" + method,
+			}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+// teamConfigServiceDesc describes the TeamConfig service in api/teamconfig.proto.
+var teamConfigServiceDesc = grpc.ServiceDesc{
+	ServiceName: "teamconfig.v1.TeamConfig",
+	HandlerType: (*TeamConfigServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler("CreateTeam", TeamConfigServer.CreateTeam),
+		unaryHandler("RotateTeam", TeamConfigServer.RotateTeam),
+		unaryHandler("RevokeTeam", TeamConfigServer.RevokeTeam),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetStatus",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				req := &TeamRequestMessage{}
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(TeamConfigServer).GetStatus(req, stream)
+			},
+		},
+	},
+	Metadata: "api/teamconfig.proto",
+}
+
+// serveGRPC serves the gRPC API on --grpc-address until the context is done.
+// It uses the same TLS configuration as the HTTP API, if any.
+func serveGRPC(ctx context.Context, server *Server, tlsConfig *tls.Config) error {
+	listener, err := net.Listen("tcp", config.GRPCAddress)
+	if err != nil {
+		return err
+	}
+
+	options := make([]grpc.ServerOption, 0)
+	if tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	grpcServer := grpc.NewServer(options...)
+	grpcServer.RegisterService(&teamConfigServiceDesc, &GRPCServer{Server: server})
+
+	go func() {
+		<-ctx.Done()
+		grpcServer.GracefulStop()
+	}()
+
+	log.Infof("serving gRPC API on %s", config.GRPCAddress)
+	return grpcServer.Serve(listener)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// protoField is a field of a message in api/teamconfig.proto.
+type protoField struct {
+	Type     string
+	Number   string
+	Repeated bool
+}
+
+var (
+	protoMessagePattern = regexp.MustCompile(`^message (\w+) \{$`)
+	protoFieldPattern   = regexp.MustCompile(`^(repeated )?(\w+) (\w+) = (\d+);$`)
+)
+
+// readProtoMessages returns the fields of each message in a proto file, by name.
+func readProtoMessages(t *testing.T, path string) map[string]map[string]protoField {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	messages := make(map[string]map[string]protoField)
+	var fields map[string]protoField
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case protoMessagePattern.MatchString(line):
+			fields = make(map[string]protoField)
+			messages[protoMessagePattern.FindStringSubmatch(line)[1]] = fields
+		case line == "}":
+			fields = nil
+		case fields != nil && protoFieldPattern.MatchString(line):
+			match := protoFieldPattern.FindStringSubmatch(line)
+			fields[match[3]] = protoField{Type: match[2], Number: match[4], Repeated: len(match[1]) > 0}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+// wireType returns the wire type in protobuf struct tags of a proto field type.
+func wireType(protoType string) string {
+	switch protoType {
+	case "bool", "int32", "int64", "uint32", "uint64":
+		return "varint"
+	default:
+		return "bytes"
+	}
+}
+
+// TestMessagesMatchProto checks that the hand-written gRPC messages match api/teamconfig.proto.
+func TestMessagesMatchProto(t *testing.T) {
+	messages := readProtoMessages(t, "api/teamconfig.proto")
+
+	for _, test := range []struct {
+		proto   string
+		message interface{}
+	}{
+		{"TeamRequest", TeamRequestMessage{}},
+		{"ClusterResult", ClusterResultMessage{}},
+		{"TeamReport", TeamReportMessage{}},
+		{"ClusterStatus", ClusterStatusMessage{}},
+	} {
+		t.Run(test.proto, func(t *testing.T) {
+			fields, ok := messages[test.proto]
+			if !ok {
+				t.Fatalf("message %s not found in api/teamconfig.proto", test.proto)
+			}
+
+			messageType := reflect.TypeOf(test.message)
+			if messageType.NumField() != len(fields) {
+				t.Errorf("%s has %d fields, but the proto message has %d", messageType.Name(), messageType.NumField(), len(fields))
+			}
+			for i := 0; i < messageType.NumField(); i++ {
+				field := messageType.Field(i)
+				tag := strings.Split(field.Tag.Get("protobuf"), ",")
+				if len(tag) < 4 || !strings.HasPrefix(tag[3], "name=") {
+					t.Errorf("%s.%s has an invalid protobuf tag %q", messageType.Name(), field.Name, field.Tag.Get("protobuf"))
+					continue
+				}
+				name := strings.TrimPrefix(tag[3], "name=")
+				protoField, ok := fields[name]
+				if !ok {
+					t.Errorf("%s.%s: field %s not found in the proto message", messageType.Name(), field.Name, name)
+					continue
+				}
+				label := "opt"
+				if protoField.Repeated {
+					label = "rep"
+				}
+				expected := []string{wireType(protoField.Type), protoField.Number, label}
+				if got := tag[:3]; !reflect.DeepEqual(got, expected) {
+					t.Errorf("%s.%s: tag %s, expected %s", messageType.Name(), field.Name, strings.Join(got, ","), strings.Join(expected, ","))
+				}
+				if kind := field.Type.Kind(); protoField.Repeated != (kind == reflect.Slice) {
+					t.Errorf("%s.%s: repeated is %t, but the field is a %s", messageType.Name(), field.Name, protoField.Repeated, kind)
+				}
+			}
+		})
+	}
+}
+
+func TestWireType(t *testing.T) {
+	for _, test := range []struct {
+		protoType string
+		expected  string
+	}{
+		{"string", "bytes"},
+		{"bool", "varint"},
+		{"int64", "varint"},
+		{"ClusterResult", "bytes"},
+	} {
+		if got := wireType(test.protoType); got != test.expected {
+			t.Errorf("%s: got %s, expected %s", test.protoType, got, test.expected)
+		}
+	}
+}
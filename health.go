@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Health tracks the main loop of a long-running command, and the clusters it talks to,
+// for the /healthz and /readyz endpoints used by liveness and readiness probes.
+type Health struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	progress time.Time
+	loops    int
+	clusters map[string]error
+}
+
+func NewHealth() *Health {
+	return &Health{
+		clusters: make(map[string]error),
+	}
+}
+
+// Start is called when the main loop starts, with how often it is expected to run.
+func (h *Health) Start(interval time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.interval = interval
+	h.progress = time.Now()
+}
+
+// Progress records that the main loop is not stuck, e.g. after handling a single resource.
+func (h *Health) Progress() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.progress = time.Now()
+}
+
+// Completed records a completed iteration of the main loop, with the outcome in each cluster.
+func (h *Health) Completed(clusters map[string]error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.progress = time.Now()
+	h.loops++
+	h.clusters = clusters
+}
+
+// live returns an error if the main loop has made no progress for several intervals,
+// allowing for a cluster timing out.
+func (h *Health) live() error {
+	if h.interval == 0 {
+		return nil
+	}
+	stalled := time.Since(h.progress)
+	if stalled > 3*h.interval+config.ClusterTimeout {
+		return fmt.Errorf("no progress for %s", stalled.Round(time.Second))
+	}
+	return nil
+}
+
+// ready returns an error if the main loop has not completed yet, or if no cluster could be reached.
+func (h *Health) ready() error {
+	if err := h.live(); err != nil {
+		return err
+	}
+	if h.interval > 0 && h.loops == 0 {
+		return fmt.Errorf("waiting for first iteration")
+	}
+	failed := 0
+	for _, err := range h.clusters {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed > 0 && failed == len(h.clusters) {
+		return fmt.Errorf("no clusters reachable")
+	}
+	return nil
+}
+
+// handler reports the outcome of check, followed by the state of each cluster.
+func (h *Health) handler(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := check(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "%s\n", err)
+		} else {
+			fmt.Fprintf(w, "ok\n")
+		}
+
+		clusters := make([]string, 0, len(h.clusters))
+		for cluster := range h.clusters {
+			clusters = append(clusters, cluster)
+		}
+		sort.Strings(clusters)
+		for _, cluster := range clusters {
+			if err := h.clusters[cluster]; err != nil {
+				fmt.Fprintf(w, "%s: %s\n", cluster, err)
+			} else {
+				fmt.Fprintf(w, "%s: ok\n", cluster)
+			}
+		}
+	}
+}
+
+// Register adds the /healthz and /readyz endpoints to mux.
+func (h *Health) Register(mux *http.ServeMux) {
+	mux.Handle("/healthz", h.handler(h.live))
+	mux.Handle("/readyz", h.handler(h.ready))
+}
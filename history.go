@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// HistoryAnnotation holds the rotation history of a service account, as a JSON list of HistoryEntry.
+const HistoryAnnotation = "teamconfig.nais.io/history"
+
+// MaxHistory is how many entries are kept in the rotation history. Older entries are dropped.
+const MaxHistory = 20
+
+// HistoryEntry records a single creation or rotation of a service account.
+type HistoryEntry struct {
+	Time     time.Time `json:"time"`
+	Operator string    `json:"operator"`
+	Action   string    `json:"action"`
+}
+
+// serviceAccountHistory returns the rotation history recorded on a service account, oldest entry first.
+func serviceAccountHistory(serviceAccount v1.ServiceAccount) ([]HistoryEntry, error) {
+	data := serviceAccount.Annotations[HistoryAnnotation]
+	if len(data) == 0 {
+		return nil, nil
+	}
+	history := make([]HistoryEntry, 0)
+	err := json.Unmarshal([]byte(data), &history)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing %s annotation: %s", HistoryAnnotation, err)
+	}
+	return history, nil
+}
+
+// appendHistory adds an entry to a rotation history, and returns it as an annotation value.
+// Only the last MaxHistory entries are kept.
+func appendHistory(history []HistoryEntry, entry HistoryEntry) string {
+	history = append(history, entry)
+	if len(history) > MaxHistory {
+		history = history[len(history)-MaxHistory:]
+	}
+	data, _ := json.Marshal(history)
+	return string(data)
+}
+
+// history prints the rotation history of the team service account in all clusters to standard output.
+func history(ctx context.Context) error {
+	histories := make([][]HistoryEntry, len(config.Clusters))
+
+	err := forEachCluster(ctx, func(ctx context.Context, i int, cluster string) error {
+		_, client, err := clusterClient(ctx, cluster)
+		if err != nil {
+			return err
+		}
+
+		serviceAccount, err := ServiceAccount(client, config.serviceAccountNamespace(cluster), ServiceAccountName(config.Team))
+		if errors.IsNotFound(err) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("while retrieving service account: %s", err)
+		}
+
+		histories[i], err = serviceAccountHistory(*serviceAccount)
+		return err
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "CLUSTER\tTIME\tACTION\tOPERATOR\n")
+	for i, cluster := range config.Clusters {
+		for _, entry := range histories[i] {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+				cluster,
+				formatTime(entry.Time),
+				entry.Action,
+				entry.Operator,
+			)
+		}
+	}
+	w.Flush()
+
+	if err != nil {
+		return fmt.Errorf("exiting due to errors")
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LegacyTokenIssuer is the issuer of tokens stored in service account token secrets.
+const LegacyTokenIssuer = "kubernetes/serviceaccount"
+
+// audience is the aud claim, which is either a single string or a list of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// boundObject identifies an object that a bound token is tied to.
+type boundObject struct {
+	Name string `json:"name"`
+	UID  string `json:"uid"`
+}
+
+// TokenClaims are the claims of a service account token that teamconfig looks at.
+// The signature of the token is not verified.
+type TokenClaims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audiences audience `json:"aud,omitempty"`
+	Expiry    int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+
+	// Kubernetes holds the claims of bound tokens, which name the objects the token is tied to.
+	Kubernetes *struct {
+		Namespace      string       `json:"namespace"`
+		ServiceAccount *boundObject `json:"serviceaccount,omitempty"`
+		Pod            *boundObject `json:"pod,omitempty"`
+		Secret         *boundObject `json:"secret,omitempty"`
+	} `json:"kubernetes.io,omitempty"`
+
+	// LegacySecretName is set in legacy tokens to the name of the token secret.
+	LegacySecretName string `json:"kubernetes.io/serviceaccount/secret.name,omitempty"`
+}
+
+// Type returns 'legacy' for tokens stored in token secrets, and 'bound' for tokens
+// minted with the TokenRequest API.
+func (c *TokenClaims) Type() string {
+	if c.Issuer == LegacyTokenIssuer || len(c.LegacySecretName) > 0 {
+		return "legacy"
+	}
+	if c.Kubernetes != nil {
+		return "bound"
+	}
+	return "unknown"
+}
+
+// BoundTo returns the objects the token is bound to, such as 'serviceaccount/x' or 'pod/y'.
+func (c *TokenClaims) BoundTo() []string {
+	bound := make([]string, 0)
+	if c.Kubernetes == nil {
+		if len(c.LegacySecretName) > 0 {
+			bound = append(bound, "secret/"+c.LegacySecretName)
+		}
+		return bound
+	}
+	if c.Kubernetes.ServiceAccount != nil {
+		bound = append(bound, "serviceaccount/"+c.Kubernetes.ServiceAccount.Name)
+	}
+	if c.Kubernetes.Pod != nil {
+		bound = append(bound, "pod/"+c.Kubernetes.Pod.Name)
+	}
+	if c.Kubernetes.Secret != nil {
+		bound = append(bound, "secret/"+c.Kubernetes.Secret.Name)
+	}
+	return bound
+}
+
+// ParseTokenClaims decodes the claims of a JSON Web Token.
+func ParseTokenClaims(token string) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("token is not a JSON Web Token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("while decoding token claims: %s", err)
+	}
+
+	claims := &TokenClaims{}
+	err = json.Unmarshal(payload, claims)
+	if err != nil {
+		return nil, fmt.Errorf("while decoding token claims: %s", err)
+	}
+
+	return claims, nil
+}
+
+// ValidFrom returns when the token becomes valid, or the zero time if it is valid from issuance.
+func (c *TokenClaims) ValidFrom() time.Time {
+	if c.NotBefore == 0 {
+		return time.Time{}
+	}
+	return time.Unix(c.NotBefore, 0)
+}
+
+// ExpiresAt returns when the token expires, or the zero time if it never does.
+func (c *TokenClaims) ExpiresAt() time.Time {
+	if c.Expiry == 0 {
+		return time.Time{}
+	}
+	return time.Unix(c.Expiry, 0)
+}
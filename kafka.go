@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// KafkaConfig configures publishing of credential events to a Kafka topic.
+type KafkaConfig struct {
+	Brokers  []string
+	Topic    string
+	TLS      bool
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// kafkaTLSConfig returns the TLS configuration for the Kafka brokers. A client certificate is
+// presented if given, as required by for instance Aiven.
+func kafkaTLSConfig(cfg KafkaConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if len(cfg.CAFile) > 0 {
+		data, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+	}
+
+	if len(cfg.CertFile) > 0 || len(cfg.KeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("while loading client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// KafkaNotifier publishes an event to a Kafka topic for every cluster where a team service
+// account was changed. Events are keyed by team, so that the events of a team stay in order.
+type KafkaNotifier struct {
+	Topic    string
+	Producer sarama.SyncProducer
+}
+
+// NewKafkaNotifier connects to the Kafka brokers. SASL/PLAIN is used if KAFKA_USERNAME is set.
+func NewKafkaNotifier(cfg KafkaConfig) (*KafkaNotifier, error) {
+	if len(cfg.Topic) == 0 {
+		return nil, fmt.Errorf("--kafka-brokers requires --kafka-topic")
+	}
+
+	kafkaConfig := sarama.NewConfig()
+	kafkaConfig.ClientID = "teamconfig"
+	kafkaConfig.Net.DialTimeout = 10 * time.Second
+	kafkaConfig.Producer.RequiredAcks = sarama.WaitForAll
+	kafkaConfig.Producer.Return.Successes = true
+
+	if cfg.TLS || len(cfg.CAFile) > 0 || len(cfg.CertFile) > 0 {
+		tlsConfig, err := kafkaTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		kafkaConfig.Net.TLS.Enable = true
+		kafkaConfig.Net.TLS.Config = tlsConfig
+	}
+
+	if username := os.Getenv("KAFKA_USERNAME"); len(username) > 0 {
+		kafkaConfig.Net.SASL.Enable = true
+		kafkaConfig.Net.SASL.User = username
+		kafkaConfig.Net.SASL.Password = os.Getenv("KAFKA_PASSWORD")
+	}
+
+	producer, err := sarama.NewSyncProducer(cfg.Brokers, kafkaConfig)
+	if err != nil {
+		return nil, fmt.Errorf("while connecting to Kafka: %s", err)
+	}
+
+	return &KafkaNotifier{
+		Topic:    cfg.Topic,
+		Producer: producer,
+	}, nil
+}
+
+func (k *KafkaNotifier) Notify(ctx context.Context, n *Notification) error {
+	events := n.clusterEvents()
+	if len(events) == 0 {
+		return nil
+	}
+
+	messages := make([]*sarama.ProducerMessage, len(events))
+	for i, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		messages[i] = &sarama.ProducerMessage{
+			Topic:     k.Topic,
+			Key:       sarama.StringEncoder(event.Team),
+			Value:     sarama.ByteEncoder(value),
+			Timestamp: event.Time,
+		}
+	}
+
+	return k.Producer.SendMessages(messages)
+}
+
+func (k *KafkaNotifier) Close() error {
+	return k.Producer.Close()
+}
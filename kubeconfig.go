@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Credentials contains everything needed to access a single cluster as the team service user.
+type Credentials struct {
+	Team    string
+	Cluster string
+	Server  string
+	Token   string
+
+	// CAData is the PEM encoded CA certificate of the API server, if known.
+	CAData []byte
+
+	// Insecure disables verification of the API server certificate.
+	Insecure bool
+}
+
+// How clients authenticate in generated Kubeconfig files.
+const (
+	// AuthToken embeds the service account token.
+	AuthToken = "token"
+
+	// AuthExec runs a credential plugin, which fetches short-lived tokens on demand.
+	AuthExec = "exec"
+
+	// AuthOIDC logs in with the OpenID Connect provider of the cluster, using kubelogin.
+	AuthOIDC = "oidc"
+)
+
+func validateAuth() error {
+	switch config.Auth {
+	case AuthToken:
+	case AuthExec:
+		if len(config.ExecCommand) == 0 {
+			return fmt.Errorf("--exec-command is required with --auth %s", AuthExec)
+		}
+	case AuthOIDC:
+		if len(config.OIDC.IssuerURL) == 0 || len(config.OIDC.ClientID) == 0 {
+			return fmt.Errorf("--oidc-issuer-url and --oidc-client-id are required with --auth %s", AuthOIDC)
+		}
+		if config.Create || config.Rotate {
+			return fmt.Errorf("--auth %s does not use service accounts, and cannot be used to create or rotate them", AuthOIDC)
+		}
+		if config.Verify {
+			return fmt.Errorf("--verify cannot be used with --auth %s", AuthOIDC)
+		}
+	default:
+		return fmt.Errorf("unknown --auth '%s'", config.Auth)
+	}
+	return nil
+}
+
+// authInfo returns the credentials of the team service user in a cluster, as configured by --auth.
+func (c *Config) authInfo(credentials *Credentials) clientcmdapi.AuthInfo {
+	switch c.Auth {
+	case AuthExec:
+		replacer := strings.NewReplacer("{team}", credentials.Team, "{cluster}", credentials.Cluster)
+		args := make([]string, len(c.ExecArgs))
+		for i, arg := range c.ExecArgs {
+			args[i] = replacer.Replace(arg)
+		}
+		return ExecAuthInfo(c.ExecCommand, args)
+	case AuthOIDC:
+		return OIDCAuthInfo(c.OIDC)
+	default:
+		return AuthInfo(credentials.Token)
+	}
+}
+
+// ContextName holds the values available in --context-template.
+type ContextName struct {
+	Team    string
+	Cluster string
+}
+
+// renderContextName returns the name of the context, user and cluster of a cluster in generated
+// Kubeconfig files, as given by --context-template.
+func (c *Config) renderContextName(team, cluster string) (string, error) {
+	tmpl, err := template.New("context").Parse(c.ContextTemplate)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, ContextName{Team: team, Cluster: cluster})
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// contextName returns the name of the context, user and cluster of credentials in generated
+// Kubeconfig files. The template is validated before any changes are made, so the cluster name is
+// only used as a last resort.
+func (c *Config) contextName(credentials *Credentials) string {
+	name, err := c.renderContextName(credentials.Team, credentials.Cluster)
+	if err != nil || len(name) == 0 {
+		return credentials.Cluster
+	}
+	return name
+}
+
+// validateContextTemplate checks --context-template before any changes are made. Since each
+// cluster gets its own context in merged Kubeconfig files, the template must give different
+// clusters different names.
+func validateContextTemplate() error {
+	names := make([]string, 0, 2)
+	for _, cluster := range []string{"cluster-a", "cluster-b"} {
+		name, err := config.renderContextName("team", cluster)
+		if err != nil {
+			return fmt.Errorf("invalid --context-template: %s", err)
+		}
+		if len(strings.TrimSpace(name)) == 0 {
+			return fmt.Errorf("--context-template must not give an empty name")
+		}
+		names = append(names, name)
+	}
+	if names[0] == names[1] {
+		return fmt.Errorf("--context-template must give each cluster a different name, e.g. by including {{.Cluster}}")
+	}
+	return nil
+}
+
+// GenerationExtension is the name of the extension in generated Kubeconfig files that records where they came from.
+const GenerationExtension = "teamconfig.nais.io/generation"
+
+// Generation describes how a Kubeconfig file was generated.
+type Generation struct {
+	Version     string    `json:"version"`
+	Team        string    `json:"team"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	Operator    string    `json:"operator"`
+}
+
+// generationExtension returns the extension stamped into generated Kubeconfig files for a team.
+func generationExtension(ctx context.Context, team string, now time.Time) (runtime.Object, error) {
+	raw, err := json.Marshal(Generation{
+		Version:     Version,
+		Team:        team,
+		GeneratedAt: now.UTC().Truncate(time.Second),
+		Operator:    configFrom(ctx).operatorIdentity(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &runtime.Unknown{Raw: raw, ContentType: runtime.ContentTypeJSON}, nil
+}
+
+// Kubeconfig assembles a Kubeconfig file with one context per cluster, named by --context-template.
+// Nil entries, from clusters that produced no credentials, are skipped.
+func Kubeconfig(ctx context.Context, credentials []*Credentials) *clientcmdapi.Config {
+	config := configFrom(ctx)
+	userConfig := clientcmdapi.NewConfig()
+
+	for _, c := range credentials {
+		if c == nil {
+			continue
+		}
+		name := config.contextName(c)
+		authInfo := config.authInfo(c)
+		userConfig.AuthInfos[name] = &authInfo
+		cluster := &clientcmdapi.Cluster{
+			Server:                   c.Server,
+			CertificateAuthorityData: c.CAData,
+		}
+		if c.Insecure {
+			// kubectl refuses configurations with both a CA certificate and insecure mode.
+			cluster.CertificateAuthorityData = nil
+			cluster.InsecureSkipTLSVerify = true
+		}
+		userConfig.Clusters[name] = cluster
+		userConfig.Contexts[name] = &clientcmdapi.Context{
+			Namespace: config.contextNamespace(c.Team, c.Cluster),
+			AuthInfo:  name,
+			Cluster:   name,
+		}
+	}
+
+	return userConfig
+}
+
+// configuredCA returns the CA bundle given for a cluster with --cluster-ca-file or --ca-file,
+// or nil if none is given.
+func (c *Config) configuredCA(cluster string) ([]byte, error) {
+	path, ok := c.CAFiles[cluster]
+	if !ok {
+		path = c.CAFile
+	}
+	if len(path) == 0 {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("while reading CA bundle: %s", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestValidateContextTemplate(t *testing.T) {
+	for _, test := range []struct {
+		template string
+		err      bool
+	}{
+		{template: "{{.Cluster}}"},
+		{template: "{{.Team}}-{{.Cluster}}"},
+		{template: "{{.Team}}", err: true},
+		{template: "teams", err: true},
+		{template: " ", err: true},
+		{template: "{{.Team", err: true},
+		{template: "{{.Namespace}}", err: true},
+	} {
+		t.Run(test.template, func(t *testing.T) {
+			setConfig(t, &Config{ContextTemplate: test.template})
+			err := validateContextTemplate()
+			if (err != nil) != test.err {
+				t.Errorf("validateContextTemplate() error = %v, want error %t", err, test.err)
+			}
+		})
+	}
+}
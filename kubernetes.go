@@ -0,0 +1,577 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	_ "k8s.io/client-go/plugin/pkg/client/auth" // Needed for azure auth side effect
+
+	log "github.com/sirupsen/logrus"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// loadingRules returns the rules for finding Kubeconfig files. If kubeconfigPath is empty,
+// the files listed in $KUBECONFIG are used, falling back to ~/.kube/config. Like $KUBECONFIG,
+// kubeconfigPath may be a list of files, which are merged with the first file taking precedence.
+func loadingRules(kubeconfigPath string) *clientcmd.ClientConfigLoadingRules {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	paths := filepath.SplitList(kubeconfigPath)
+	switch {
+	case len(paths) == 1:
+		rules.ExplicitPath = paths[0]
+	case len(paths) > 1:
+		rules.Precedence = paths
+	}
+	return rules
+}
+
+func buildConfigFromFlags(kubeContext, kubeconfigPath string) (*rest.Config, error) {
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		loadingRules(kubeconfigPath),
+		&clientcmd.ConfigOverrides{
+			CurrentContext: kubeContext,
+		}).ClientConfig()
+}
+
+// KubeconfigContexts returns the sorted names of all contexts in the Kubeconfig files.
+func KubeconfigContexts(kubeconfigPath string) ([]string, error) {
+	kubeconfig, err := loadingRules(kubeconfigPath).Load()
+	if err != nil {
+		return nil, err
+	}
+
+	contexts := make([]string, 0, len(kubeconfig.Contexts))
+	for name := range kubeconfig.Contexts {
+		contexts = append(contexts, name)
+	}
+	sort.Strings(contexts)
+
+	return contexts, nil
+}
+
+// contextRoundTripper attaches a context to every request passing through it.
+type contextRoundTripper struct {
+	ctx  context.Context
+	next http.RoundTripper
+}
+
+func (rt *contextRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rt.next.RoundTrip(req.WithContext(rt.ctx))
+	if err == nil {
+		observeAuth(rt.ctx, resp)
+	}
+	return resp, err
+}
+
+// KubeClient returns a client for the given configuration. The typed clients in this
+// version of client-go do not accept a context, so the context is instead attached
+// to every request made by the client. When it is cancelled, all outstanding and
+// future requests fail. Requests failing with transient errors are retried up to
+// retries times, with exponential backoff starting at retryInterval.
+func KubeClient(ctx context.Context, config *rest.Config, retries int, retryInterval time.Duration) (kubernetes.Interface, error) {
+	return kubernetes.NewForConfig(wrapConfig(ctx, config, retries, retryInterval))
+}
+
+// DynamicClient returns a client for arbitrary resources, such as custom resources,
+// which behaves like the client returned by KubeClient.
+func DynamicClient(ctx context.Context, config *rest.Config, retries int, retryInterval time.Duration) (dynamic.Interface, error) {
+	return dynamic.NewForConfig(wrapConfig(ctx, config, retries, retryInterval))
+}
+
+// wrapConfig returns a copy of config that attaches the context to every request, and retries requests.
+func wrapConfig(ctx context.Context, config *rest.Config, retries int, retryInterval time.Duration) *rest.Config {
+	config = rest.CopyConfig(config)
+	wrapTransport := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrapTransport != nil {
+			rt = wrapTransport(rt)
+		}
+		rt = &tracingRoundTripper{next: rt}
+		if retries > 0 {
+			rt = &retryRoundTripper{retries: retries, interval: retryInterval, next: rt}
+		}
+		return &contextRoundTripper{ctx: ctx, next: rt}
+	}
+	return config
+}
+
+// parseProxyURL parses the URL of an HTTP, HTTPS or SOCKS5 proxy.
+func parseProxyURL(proxyURL string) (*url.URL, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+		return u, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme '%s'", u.Scheme)
+	}
+}
+
+// proxyConfig returns a copy of config that connects through the proxy at proxyURL.
+// This version of client-go cannot set a proxy on the transports it builds, so the
+// transport is built here instead, with the TLS settings moved over from config.
+func proxyConfig(config *rest.Config, proxyURL string) (*rest.Config, error) {
+	u, err := parseProxyURL(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := rest.TLSConfigFor(config)
+	if err != nil {
+		return nil, err
+	}
+
+	config = rest.CopyConfig(config)
+	config.TLSClientConfig = rest.TLSClientConfig{}
+	config.Transport = utilnet.SetTransportDefaults(&http.Transport{
+		Proxy:           http.ProxyURL(u),
+		TLSClientConfig: tlsConfig,
+	})
+	return config, nil
+}
+
+func ServiceAccountName(team string) string {
+	return fmt.Sprintf(ServiceUserTemplate, team)
+}
+
+// TeamName returns the team name of a service account named according to ServiceUserTemplate.
+func TeamName(serviceAccountName string) (string, bool) {
+	prefix := strings.TrimSuffix(ServiceUserTemplate, "%s")
+	if !strings.HasPrefix(serviceAccountName, prefix) || len(serviceAccountName) == len(prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(serviceAccountName, prefix), true
+}
+
+func ListServiceAccounts(client kubernetes.Interface, namespace string) ([]v1.ServiceAccount, error) {
+	log.Debugf("attempting to list service accounts in namespace %s", namespace)
+	serviceAccounts, err := client.CoreV1().ServiceAccounts(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return serviceAccounts.Items, nil
+}
+
+func ServiceAccount(client kubernetes.Interface, namespace, serviceAccountName string) (*v1.ServiceAccount, error) {
+	log.Debugf("attempting to retrieve service account '%s' in namespace %s", serviceAccountName, namespace)
+	return client.CoreV1().ServiceAccounts(namespace).Get(serviceAccountName, metav1.GetOptions{})
+}
+
+func DeleteServiceAccount(client kubernetes.Interface, namespace, serviceAccountName string) error {
+	log.Debugf("attempting to delete service account '%s' in namespace %s", serviceAccountName, namespace)
+	// mark the deletion as made by teamconfig, so that it is admitted by the webhook
+	err := AnnotateServiceAccount(client, namespace, serviceAccountName, map[string]string{})
+	if err != nil {
+		return err
+	}
+	return client.CoreV1().ServiceAccounts(namespace).Delete(serviceAccountName, &metav1.DeleteOptions{})
+}
+
+// ServiceAccountObject returns a team service account, labelled as managed by teamconfig.
+func ServiceAccountObject(namespace, serviceAccountName, team string, annotations map[string]string) *v1.ServiceAccount {
+	return &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceAccountName,
+			Namespace: namespace,
+			Labels: map[string]string{
+				ManagedByLabel: ManagedByValue,
+				TeamLabel:      team,
+			},
+			Annotations: annotations,
+		},
+	}
+}
+
+func CreateServiceAccount(client kubernetes.Interface, namespace, serviceAccountName, team string, annotations map[string]string) (*v1.ServiceAccount, error) {
+	log.Debugf("attempting to create service account '%s' in namespace %s", serviceAccountName, namespace)
+	return client.CoreV1().ServiceAccounts(namespace).Create(ServiceAccountObject(namespace, serviceAccountName, team, annotations))
+}
+
+// TokenSecretObject returns a secret that the token controller fills with a long-lived token for
+// the service account. It contains no credentials until then.
+func TokenSecretObject(namespace, serviceAccountName, team string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceAccountName + "-token",
+			Namespace: namespace,
+			Labels: map[string]string{
+				ManagedByLabel: ManagedByValue,
+				TeamLabel:      team,
+			},
+			Annotations: map[string]string{
+				v1.ServiceAccountNameKey: serviceAccountName,
+			},
+		},
+		Type: v1.SecretTypeServiceAccountToken,
+	}
+}
+
+// CreateTokenSecret creates an additional token secret for a service account, with a generated
+// name. The token controller fills it with a new long-lived token, which is valid until the secret
+// is deleted.
+func CreateTokenSecret(client kubernetes.Interface, namespace, serviceAccountName, team string) (*v1.Secret, error) {
+	log.Debugf("attempting to create token secret for service account '%s' in namespace %s", serviceAccountName, namespace)
+	secret := TokenSecretObject(namespace, serviceAccountName, team)
+	secret.GenerateName = secret.Name + "-"
+	secret.Name = ""
+	return client.CoreV1().Secrets(namespace).Create(secret)
+}
+
+// DeleteTokenSecret deletes a token secret, which invalidates its token.
+func DeleteTokenSecret(client kubernetes.Interface, namespace, name string) error {
+	log.Debugf("attempting to delete secret '%s' in namespace %s", name, namespace)
+	// mark the deletion as made by teamconfig, so that it is admitted by the webhook
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				ManagedChangeAnnotation: time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().Secrets(namespace).Patch(name, types.MergePatchType, patch)
+	if err != nil {
+		return err
+	}
+	return client.CoreV1().Secrets(namespace).Delete(name, &metav1.DeleteOptions{})
+}
+
+// ReplaceServiceAccountSecret replaces the reference to a token secret in the secrets of a service
+// account with a reference to another. Before Kubernetes 1.24, the token controller issues a new
+// token secret when the only one referenced by a service account is deleted, so a secret must be
+// dereferenced before it is deleted. Service accounts not referencing the secret are left alone.
+func ReplaceServiceAccountSecret(client kubernetes.Interface, namespace, serviceAccountName, previous, current string) error {
+	serviceAccount, err := ServiceAccount(client, namespace, serviceAccountName)
+	if err != nil {
+		return err
+	}
+	secrets := make([]v1.ObjectReference, 0, len(serviceAccount.Secrets))
+	referenced, hasCurrent := false, false
+	for _, ref := range serviceAccount.Secrets {
+		switch ref.Name {
+		case previous:
+			referenced = true
+			continue
+		case current:
+			hasCurrent = true
+		}
+		secrets = append(secrets, ref)
+	}
+	if !referenced {
+		return nil
+	}
+	if !hasCurrent && len(current) > 0 {
+		secrets = append(secrets, v1.ObjectReference{Name: current})
+	}
+
+	log.Debugf("attempting to replace secret '%s' of service account '%s' in namespace %s", previous, serviceAccountName, namespace)
+	serviceAccount.Secrets = secrets
+	if serviceAccount.Annotations == nil {
+		serviceAccount.Annotations = make(map[string]string)
+	}
+	serviceAccount.Annotations[ManagedChangeAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	_, err = client.CoreV1().ServiceAccounts(namespace).Update(serviceAccount)
+	return err
+}
+
+// annotatedTime returns the time recorded in an annotation, or the zero time if it is missing or invalid.
+func annotatedTime(serviceAccount v1.ServiceAccount, annotation string) time.Time {
+	t, err := time.Parse(time.RFC3339, serviceAccount.Annotations[annotation])
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// CreatedAt returns when the service account was first created, before any rotations.
+func CreatedAt(serviceAccount v1.ServiceAccount) time.Time {
+	if t := annotatedTime(serviceAccount, CreatedAtAnnotation); !t.IsZero() {
+		return t
+	}
+	return serviceAccount.CreationTimestamp.Time
+}
+
+// LastRotated returns when the service account was last rotated, or created if it was never rotated.
+// Service accounts are rotated by recreating them, so their creation time is used if they are not annotated.
+func LastRotated(serviceAccount v1.ServiceAccount) time.Time {
+	if t := annotatedTime(serviceAccount, RotatedAtAnnotation); !t.IsZero() {
+		return t
+	}
+	return serviceAccount.CreationTimestamp.Time
+}
+
+// AnnotateServiceAccount sets annotations on a service account, leaving other annotations untouched.
+// ManagedChangeAnnotation is always set to the current time.
+func AnnotateServiceAccount(client kubernetes.Interface, namespace, serviceAccountName string, annotations map[string]string) error {
+	return PatchServiceAccountAnnotations(client, namespace, serviceAccountName, annotations, nil)
+}
+
+// PatchServiceAccountAnnotations sets some annotations on a service account, and removes others.
+func PatchServiceAccountAnnotations(client kubernetes.Interface, namespace, serviceAccountName string, annotations map[string]string, removed []string) error {
+	log.Debugf("attempting to annotate service account '%s' in namespace %s", serviceAccountName, namespace)
+	patched := map[string]interface{}{
+		ManagedChangeAnnotation: time.Now().UTC().Format(time.RFC3339),
+	}
+	for key, value := range annotations {
+		patched[key] = value
+	}
+	// A merge patch removes keys set to null
+	for _, key := range removed {
+		patched[key] = nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": patched,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().ServiceAccounts(namespace).Patch(serviceAccountName, types.MergePatchType, patch)
+	return err
+}
+
+// RecordServiceAccountEvent records a normal event on a service account, so that changes made by
+// teamconfig are shown next to it, for instance by kubectl describe.
+func RecordServiceAccountEvent(client kubernetes.Interface, namespace, serviceAccountName, reason, message string) error {
+	log.Debugf("attempting to record %s event on service account '%s' in namespace %s", reason, serviceAccountName, namespace)
+	now := metav1.Now()
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", serviceAccountName, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "ServiceAccount",
+			Namespace:  namespace,
+			Name:       serviceAccountName,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           v1.EventTypeNormal,
+		Source:         v1.EventSource{Component: ManagedByValue},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	_, err := client.CoreV1().Events(namespace).Create(event)
+	return err
+}
+
+// ServiceAccountSecret returns the token secret of a service account. The secret issued by the last
+// two-phase rotation takes precedence. On Kubernetes 1.24 and newer, where token secrets are no
+// longer referenced by the service account, the secret created from TokenSecretObject, e.g. by a
+// GitOps tool, is used.
+func ServiceAccountSecret(client kubernetes.Interface, serviceAccount v1.ServiceAccount) (*v1.Secret, error) {
+	if name := serviceAccount.Annotations[TokenSecretAnnotation]; len(name) > 0 {
+		log.Debugf("attempting to retrieve secret '%s' in namespace %s", name, serviceAccount.Namespace)
+		return client.CoreV1().Secrets(serviceAccount.Namespace).Get(name, metav1.GetOptions{})
+	}
+	if len(serviceAccount.Secrets) == 0 {
+		secret, err := client.CoreV1().Secrets(serviceAccount.Namespace).Get(serviceAccount.Name+"-token", metav1.GetOptions{})
+		if err == nil && secret.Type == v1.SecretTypeServiceAccountToken && secret.Annotations[v1.ServiceAccountNameKey] == serviceAccount.Name {
+			return secret, nil
+		}
+		return nil, fmt.Errorf("no secret associated with service account '%s'", serviceAccount.Name)
+	}
+	secretRef := serviceAccount.Secrets[0]
+	log.Debugf("attempting to retrieve secret '%s' in namespace %s", secretRef.Name, serviceAccount.Namespace)
+	return client.CoreV1().Secrets(serviceAccount.Namespace).Get(secretRef.Name, metav1.GetOptions{})
+}
+
+// WaitForServiceAccountSecret retrieves the token secret of a service account, polling with
+// exponential backoff until the token controller has populated it or the timeout expires.
+// With a zero timeout, only a single attempt is made.
+func WaitForServiceAccountSecret(ctx context.Context, client kubernetes.Interface, namespace, serviceAccountName string, timeout time.Duration) (*v1.Secret, error) {
+	return waitForToken(ctx, fmt.Sprintf("token secret of service account '%s'", serviceAccountName), timeout, func() (*v1.Secret, error) {
+		return serviceAccountSecretWithToken(client, namespace, serviceAccountName)
+	})
+}
+
+// WaitForTokenSecret retrieves a token secret by name, polling like WaitForServiceAccountSecret
+// until the token controller has populated it.
+func WaitForTokenSecret(ctx context.Context, client kubernetes.Interface, namespace, name string, timeout time.Duration) (*v1.Secret, error) {
+	return waitForToken(ctx, fmt.Sprintf("token secret '%s'", name), timeout, func() (*v1.Secret, error) {
+		secret, err := client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+		if err == nil && len(secret.Data["token"]) == 0 {
+			return nil, missingTokenError{fmt.Errorf("secret '%s' does not contain a token", name)}
+		}
+		return secret, err
+	})
+}
+
+// waitForToken calls get with exponential backoff until it returns a secret with a token,
+// fails for another reason than the secret or token missing, or the timeout expires.
+func waitForToken(ctx context.Context, description string, timeout time.Duration, get func() (*v1.Secret, error)) (*v1.Secret, error) {
+	const maxInterval = 2 * time.Second
+	interval := 50 * time.Millisecond
+	deadline := time.Now().Add(timeout)
+
+	for {
+		secret, err := get()
+		if err == nil || !errors.IsNotFound(err) && !isMissingToken(err) {
+			return secret, err
+		}
+		if !time.Now().Add(interval).Before(deadline) {
+			if timeout == 0 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("timed out after %s waiting for %s: %s", timeout, description, err)
+		}
+		log.Tracef("%s not ready, retrying in %s", description, interval)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		interval *= 2
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+type missingTokenError struct {
+	error
+}
+
+func isMissingToken(err error) bool {
+	_, ok := err.(missingTokenError)
+	return ok
+}
+
+func serviceAccountSecretWithToken(client kubernetes.Interface, namespace, serviceAccountName string) (*v1.Secret, error) {
+	serviceAccount, err := ServiceAccount(client, namespace, serviceAccountName)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := ServiceAccountSecret(client, *serviceAccount)
+	if err != nil && len(serviceAccount.Secrets) == 0 {
+		return nil, missingTokenError{err}
+	} else if err != nil {
+		return nil, err
+	}
+	if len(secret.Data["token"]) == 0 {
+		return nil, missingTokenError{fmt.Errorf("secret '%s' does not contain a token", secret.Name)}
+	}
+	return secret, nil
+}
+
+// MinTokenTTL is the shortest token lifetime accepted by the TokenRequest API.
+const MinTokenTTL = 10 * time.Minute
+
+// RequestServiceAccountToken mints a new token for the service account using the TokenRequest API.
+// The token is bound to the given audiences, or to the API server if none are given, and expires
+// after ttl, or the API server default if ttl is zero. The token and its expiry are returned.
+func RequestServiceAccountToken(client kubernetes.Interface, namespace, serviceAccountName string, audiences []string, ttl time.Duration) (*authenticationv1.TokenRequestStatus, error) {
+	log.Debugf("attempting to request token for service account '%s' in namespace %s", serviceAccountName, namespace)
+	tokenRequest := authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences: audiences,
+		},
+	}
+	if ttl > 0 {
+		expirationSeconds := int64(ttl.Seconds())
+		tokenRequest.Spec.ExpirationSeconds = &expirationSeconds
+	}
+	result, err := client.CoreV1().ServiceAccounts(namespace).CreateToken(serviceAccountName, &tokenRequest)
+	if err != nil {
+		return nil, err
+	}
+	return &result.Status, nil
+}
+
+// TokenRequestUnsupported returns true if the error indicates that
+// the cluster does not serve the TokenRequest API.
+func TokenRequestUnsupported(err error) bool {
+	return errors.IsNotFound(err) || errors.IsMethodNotSupported(err)
+}
+
+func AuthInfo(token string) clientcmdapi.AuthInfo {
+	return clientcmdapi.AuthInfo{
+		Token: token,
+	}
+}
+
+// ExecCredentialAPIVersion is the version of the credential plugin protocol spoken by teamconfig.
+const ExecCredentialAPIVersion = "client.authentication.k8s.io/v1beta1"
+
+// OIDCAuthInfo returns an AuthInfo that logs in with an OpenID Connect provider using kubelogin,
+// available as the kubectl plugin oidc-login.
+func OIDCAuthInfo(oidc OIDCConfig) clientcmdapi.AuthInfo {
+	args := []string{
+		"oidc-login",
+		"get-token",
+		"--oidc-issuer-url=" + oidc.IssuerURL,
+		"--oidc-client-id=" + oidc.ClientID,
+	}
+	if len(oidc.ClientSecret) > 0 {
+		args = append(args, "--oidc-client-secret="+oidc.ClientSecret)
+	}
+	for _, scope := range oidc.ExtraScopes {
+		args = append(args, "--oidc-extra-scope="+scope)
+	}
+	return ExecAuthInfo("kubectl", args)
+}
+
+// ExecAuthInfo returns an AuthInfo that runs a credential plugin to fetch tokens on demand.
+func ExecAuthInfo(command string, args []string) clientcmdapi.AuthInfo {
+	return clientcmdapi.AuthInfo{
+		Exec: &clientcmdapi.ExecConfig{
+			APIVersion: ExecCredentialAPIVersion,
+			Command:    command,
+			Args:       args,
+		},
+	}
+}
+
+// RootCAConfigMap is published in every namespace by Kubernetes 1.20 and newer,
+// and holds the CA certificate for verifying the API server.
+const RootCAConfigMap = "kube-root-ca.crt"
+
+// clusterCA returns the CA certificate for verifying the API server. It is taken from the
+// configuration used by teamconfig itself, the token secret of the service account, or the
+// root CA config map, whichever is found first. No certificate is returned if none is found.
+func clusterCA(clientConfig *rest.Config, client kubernetes.Interface, namespace string, secret *v1.Secret) ([]byte, error) {
+	if len(clientConfig.CAData) > 0 {
+		return clientConfig.CAData, nil
+	}
+	if len(clientConfig.CAFile) > 0 {
+		return ioutil.ReadFile(clientConfig.CAFile)
+	}
+	if secret != nil && len(secret.Data["ca.crt"]) > 0 {
+		return secret.Data["ca.crt"], nil
+	}
+
+	configMap, err := client.CoreV1().ConfigMaps(namespace).Get(RootCAConfigMap, metav1.GetOptions{})
+	if errors.IsNotFound(err) || errors.IsForbidden(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return []byte(configMap.Data["ca.crt"]), nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// KubeconfigSecretKey is the key of the Kubeconfig file in Kubernetes secrets.
+const KubeconfigSecretKey = "kubeconfig"
+
+// ApplyKubeconfigSecret creates a secret holding a Kubeconfig file, or replaces the data of an existing one.
+func ApplyKubeconfigSecret(client kubernetes.Interface, namespace, name, team string, kubeconfig []byte) error {
+	secrets := client.CoreV1().Secrets(namespace)
+	labels := map[string]string{
+		ManagedByLabel: ManagedByValue,
+		TeamLabel:      team,
+	}
+	data := map[string][]byte{
+		KubeconfigSecretKey: kubeconfig,
+	}
+
+	existing, err := secrets.Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Debugf("attempting to create secret '%s' in namespace %s", name, namespace)
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    labels,
+			},
+			Type: v1.SecretTypeOpaque,
+			Data: data,
+		}
+		_, err = secrets.Create(secret)
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	log.Debugf("attempting to update secret '%s' in namespace %s", name, namespace)
+	if existing.Labels == nil {
+		existing.Labels = make(map[string]string)
+	}
+	for key, value := range labels {
+		existing.Labels[key] = value
+	}
+	existing.Data = data
+	_, err = secrets.Update(existing)
+	return err
+}
+
+// kubernetesSecretName returns the name of the secret holding the Kubeconfig file for a team.
+func kubernetesSecretName(team string) string {
+	return strings.Replace(config.KubernetesSecret.Name, "{team}", team, -1)
+}
+
+// writeKubernetesSecret stores the Kubeconfig file as a secret in the admin cluster,
+// where the team can retrieve it with their existing access.
+func writeKubernetesSecret(ctx context.Context, team string, kubeconfig []byte) error {
+	config := configFrom(ctx)
+	_, client, err := clusterClient(ctx, config.KubernetesSecret.Cluster)
+	if err != nil {
+		return err
+	}
+
+	name := kubernetesSecretName(team)
+	namespace := strings.Replace(config.KubernetesSecret.Namespace, "{team}", team, -1)
+	err = ApplyKubeconfigSecret(client, namespace, name, team, kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("%s: credentials stored in secret %s in namespace %s", config.KubernetesSecret.Cluster, name, namespace)
+	return nil
+}
+
+// validateKubernetesSecret checks the --secret-* flags before any changes are made.
+func validateKubernetesSecret() error {
+	if len(config.KubernetesSecret.Cluster) > 0 && len(config.KubernetesSecret.Namespace) == 0 {
+		return fmt.Errorf("--secret-cluster requires --secret-namespace")
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	coordinationv1beta1 "k8s.io/api/coordination/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LeaderElectionConfig configures leader election between replicas of long-running commands.
+type LeaderElectionConfig struct {
+	Enabled       bool
+	LeaseName     string
+	Namespace     string
+	LeaseDuration time.Duration
+}
+
+// leaderIdentity identifies this replica in the lease. In a pod, the hostname is the pod name.
+func leaderIdentity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// tryAcquireLease acquires or renews the lease for identity. It returns false if the lease is held by
+// another replica that has renewed it within the lease duration.
+func tryAcquireLease(client kubernetes.Interface, identity string, leaderElection LeaderElectionConfig) (bool, error) {
+	leases := client.CoordinationV1beta1().Leases(leaderElection.Namespace)
+	now := metav1.NewMicroTime(time.Now())
+	duration := int32(leaderElection.LeaseDuration.Seconds())
+
+	lease, err := leases.Get(leaderElection.LeaseName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		transitions := int32(0)
+		_, err = leases.Create(&coordinationv1beta1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      leaderElection.LeaseName,
+				Namespace: leaderElection.Namespace,
+				Labels: map[string]string{
+					ManagedByLabel: ManagedByValue,
+				},
+			},
+			Spec: coordinationv1beta1.LeaseSpec{
+				HolderIdentity:       &identity,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+				LeaseTransitions:     &transitions,
+			},
+		})
+		if errors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	} else if err != nil {
+		return false, err
+	}
+
+	holder := ""
+	if lease.Spec.HolderIdentity != nil {
+		holder = *lease.Spec.HolderIdentity
+	}
+	if holder != identity && len(holder) > 0 && lease.Spec.RenewTime != nil {
+		expires := lease.Spec.RenewTime.Add(leaderElection.LeaseDuration)
+		if lease.Spec.LeaseDurationSeconds != nil {
+			expires = lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+		}
+		if time.Now().Before(expires) {
+			return false, nil
+		}
+	}
+
+	if holder != identity {
+		transitions := int32(1)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions = *lease.Spec.LeaseTransitions + 1
+		}
+		lease.Spec.HolderIdentity = &identity
+		lease.Spec.AcquireTime = &now
+		lease.Spec.LeaseTransitions = &transitions
+	}
+	lease.Spec.RenewTime = &now
+	lease.Spec.LeaseDurationSeconds = &duration
+
+	// a conflict means another replica updated the lease first
+	_, err = leases.Update(lease)
+	if errors.IsConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// releaseLease gives up the lease, so that another replica can take over without waiting for it to expire.
+func releaseLease(client kubernetes.Interface, identity string, leaderElection LeaderElectionConfig) error {
+	leases := client.CoordinationV1beta1().Leases(leaderElection.Namespace)
+	lease, err := leases.Get(leaderElection.LeaseName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != identity {
+		return nil
+	}
+	lease.Spec.HolderIdentity = nil
+	_, err = leases.Update(lease)
+	return err
+}
+
+// runAsLeader runs fn once this replica holds the lease, and keeps renewing it while fn runs.
+// If the lease cannot be renewed within the lease duration, the context passed to fn is
+// cancelled and an error is returned, as another replica may already have taken over.
+func runAsLeader(ctx context.Context, client kubernetes.Interface, leaderElection LeaderElectionConfig, fn func(ctx context.Context) error) error {
+	identity := leaderIdentity()
+	retryPeriod := leaderElection.LeaseDuration / 3
+
+	log.Infof("%s: waiting for lease %s/%s", identity, leaderElection.Namespace, leaderElection.LeaseName)
+	for {
+		acquired, err := tryAcquireLease(client, identity, leaderElection)
+		if err != nil {
+			log.Errorf("%s: while acquiring lease: %s", identity, err)
+		}
+		if acquired {
+			break
+		}
+		select {
+		case <-time.After(retryPeriod):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	log.Infof("%s: acquired lease %s/%s", identity, leaderElection.Namespace, leaderElection.LeaseName)
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(leaderCtx)
+	}()
+
+	ticker := time.NewTicker(retryPeriod)
+	defer ticker.Stop()
+
+	renewed := time.Now()
+	for {
+		select {
+		case err := <-done:
+			if releaseErr := releaseLease(client, identity, leaderElection); releaseErr != nil {
+				log.Warnf("%s: while releasing lease: %s", identity, releaseErr)
+			}
+			return err
+		case <-ticker.C:
+			acquired, err := tryAcquireLease(client, identity, leaderElection)
+			if err != nil {
+				log.Warnf("%s: while renewing lease: %s", identity, err)
+			}
+			if acquired {
+				renewed = time.Now()
+			} else if err == nil || time.Since(renewed) >= leaderElection.LeaseDuration {
+				cancel()
+				<-done
+				return fmt.Errorf("lost lease %s/%s", leaderElection.Namespace, leaderElection.LeaseName)
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// isTeamServiceAccount returns true if the service account is managed by teamconfig, either
+// because it carries the managed-by label, or because its name matches ServiceUserTemplate.
+func isTeamServiceAccount(serviceAccount v1.ServiceAccount) bool {
+	if serviceAccount.Labels[ManagedByLabel] == ManagedByValue {
+		return true
+	}
+	_, ok := TeamName(serviceAccount.Name)
+	return ok
+}
+
+// serviceAccountTeam returns the team owning a team service account.
+func serviceAccountTeam(serviceAccount v1.ServiceAccount) string {
+	if team, ok := serviceAccount.Labels[TeamLabel]; ok {
+		return team
+	}
+	team, _ := TeamName(serviceAccount.Name)
+	return team
+}
+
+// listNamespace returns the namespace to look for team service accounts in.
+func listNamespace(cluster string) string {
+	if config.AllNamespaces {
+		return metav1.NamespaceAll
+	}
+	return config.serviceAccountNamespace(cluster)
+}
+
+// clusterServiceAccounts returns the team service accounts in each of the clusters, sorted by team.
+// The service accounts found are returned even if listing failed in some clusters.
+func clusterServiceAccounts(ctx context.Context) ([][]v1.ServiceAccount, error) {
+	found := make([][]v1.ServiceAccount, len(config.Clusters))
+
+	err := forEachCluster(ctx, func(ctx context.Context, i int, cluster string) error {
+		_, client, err := clusterClient(ctx, cluster)
+		if err != nil {
+			return err
+		}
+
+		serviceAccounts, err := ListServiceAccounts(client, listNamespace(cluster))
+		if err != nil {
+			return fmt.Errorf("while listing service accounts: %s", err)
+		}
+
+		for _, serviceAccount := range serviceAccounts {
+			if isTeamServiceAccount(serviceAccount) {
+				found[i] = append(found[i], serviceAccount)
+			}
+		}
+
+		sort.Slice(found[i], func(a, b int) bool {
+			return serviceAccountTeam(found[i][a]) < serviceAccountTeam(found[i][b])
+		})
+
+		return nil
+	})
+
+	return found, err
+}
+
+// clusterTeams returns the teams having a team service account in each of the clusters.
+func clusterTeams(ctx context.Context) ([][]string, error) {
+	serviceAccounts, err := clusterServiceAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([][]string, len(config.Clusters))
+	for i := range serviceAccounts {
+		for _, serviceAccount := range serviceAccounts[i] {
+			found[i] = append(found[i], serviceAccountTeam(serviceAccount))
+		}
+	}
+
+	return found, nil
+}
+
+// discoverTeams returns the teams having a team service account in any of the clusters.
+func discoverTeams(ctx context.Context) ([]string, error) {
+	found, err := clusterTeams(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	teams := make([]string, 0)
+	for _, clusterTeams := range found {
+		for _, team := range clusterTeams {
+			if !seen[team] {
+				seen[team] = true
+				teams = append(teams, team)
+			}
+		}
+	}
+	sort.Strings(teams)
+
+	return teams, nil
+}
+
+// list prints all team service accounts in all clusters to standard output.
+func list(ctx context.Context) error {
+	found, err := clusterServiceAccounts(ctx)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "CLUSTER\tNAMESPACE\tTEAM\tSERVICE ACCOUNT\tCREATED\n")
+	for i, cluster := range config.Clusters {
+		for _, serviceAccount := range found[i] {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				cluster,
+				serviceAccount.Namespace,
+				serviceAccountTeam(serviceAccount),
+				serviceAccount.Name,
+				serviceAccount.CreationTimestamp.UTC().Format(time.RFC3339),
+			)
+		}
+	}
+	w.Flush()
+
+	if err != nil {
+		return fmt.Errorf("exiting due to errors")
+	}
+
+	return nil
+}
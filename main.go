@@ -1,253 +1,637 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
-	"k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
-
-	_ "k8s.io/client-go/plugin/pkg/client/auth" // Needed for azure auth side effect
-
 	log "github.com/sirupsen/logrus"
 	flag "github.com/spf13/pflag"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
-const Namespace = "default"
+// Version of teamconfig, set when building with -ldflags "-X main.Version=...".
+var Version = "dev"
+
+const DefaultServiceAccountNamespace = "default"
 const ServiceUserTemplate = "serviceuser-%s"
 
-type Config struct {
-	Clusters []string
-	Debug    bool
-	Create   bool
-	Revoke   bool
-	Rotate   bool
-	Team     string
-}
+// Labels set on service accounts created by teamconfig.
+const (
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedByValue = "teamconfig"
+	TeamLabel      = "team"
+)
 
-func DefaultConfig() *Config {
-	return &Config{
-		Clusters: []string{"dev-fss", "dev-sbs", "prod-fss", "prod-sbs"},
-	}
-}
+// Annotations set on service accounts by teamconfig. Timestamps are in RFC 3339 format.
+const (
+	// CreatedAtAnnotation records when the service account was first created. It is kept when rotating.
+	CreatedAtAnnotation = "teamconfig.nais.io/created-at"
+
+	// RotatedAtAnnotation records when the service account was last rotated.
+	RotatedAtAnnotation = "teamconfig.nais.io/rotated-at"
+
+	// TokenExpiresAnnotation records when the last token minted for a service account expires.
+	TokenExpiresAnnotation = "teamconfig.nais.io/token-expires-at"
 
-func (c *Config) addFlags() {
-	flag.StringSliceVar(&c.Clusters, "clusters", c.Clusters, "Which clusters to operate on.")
-	flag.StringVar(&c.Team, "team", c.Team, "Team name that will own the configuration file.")
-	flag.BoolVar(&c.Debug, "debug", c.Debug, "Print debugging information.")
-	flag.BoolVar(&c.Create, "create", c.Create, "Create teams that do not exist.")
-	flag.BoolVar(&c.Revoke, "revoke", c.Revoke, "Delete any tokens that belongs to this team.")
-	flag.BoolVar(&c.Rotate, "rotate", c.Rotate, "Rotate secret tokens that are already present in cluster. This will invalidate old tokens.")
+	// ManagedChangeAnnotation is updated whenever teamconfig changes or deletes a service account,
+	// so that the admission webhook can tell changes made by teamconfig from manual ones.
+	ManagedChangeAnnotation = "teamconfig.nais.io/managed-change"
+)
+
+// GitHubConfig configures uploading of credentials as GitHub Actions secrets.
+type GitHubConfig struct {
+	API               string
+	Repo              string
+	Org               string
+	Visibility        string
+	SecretName        string
+	Fields            []string
+	AppID             string
+	AppInstallationID string
+	AppPrivateKey     string
 }
 
-var config = DefaultConfig()
+// GitLabConfig configures storing of credentials as GitLab CI/CD variables.
+type GitLabConfig struct {
+	API          string
+	Project      string
+	Group        string
+	VariableName string
+	Fields       []string
+	Protected    bool
+}
 
-func buildConfigFromFlags(context, kubeconfigPath string) (*rest.Config, error) {
-	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
-		&clientcmd.ConfigOverrides{
-			CurrentContext: context,
-		}).ClientConfig()
+// AzureConfig configures storing of credentials in Azure Key Vault.
+type AzureConfig struct {
+	KeyVault   string
+	SecretName string
+	Fields     []string
 }
 
-func KubeClient(config *rest.Config) (kubernetes.Interface, error) {
-	return kubernetes.NewForConfig(config)
+// AWSConfig configures storing of credentials in AWS Secrets Manager.
+type AWSConfig struct {
+	Secret   string
+	Region   string
+	KMSKeyID string
 }
 
-func ServiceAccountName(team string) string {
-	return fmt.Sprintf(ServiceUserTemplate, team)
+// OIDCConfig configures logging in with OpenID Connect in generated Kubeconfig files.
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	ExtraScopes  []string
 }
 
-func ServiceAccount(client kubernetes.Interface, serviceAccountName string) (*v1.ServiceAccount, error) {
-	log.Debugf("attempting to retrieve service account '%s' in namespace %s", serviceAccountName, Namespace)
-	return client.CoreV1().ServiceAccounts(Namespace).Get(serviceAccountName, metav1.GetOptions{})
+// KubernetesSecretConfig configures storing of the Kubeconfig file as a secret in an admin cluster.
+type KubernetesSecretConfig struct {
+	Cluster   string
+	Namespace string
+	Name      string
 }
 
-func DeleteServiceAccount(client kubernetes.Interface, serviceAccountName string) error {
-	log.Debugf("attempting to delete service account '%s' in namespace %s", serviceAccountName, Namespace)
-	return client.CoreV1().ServiceAccounts(Namespace).Delete(serviceAccountName, &metav1.DeleteOptions{})
+type Config struct {
+	Kubeconfig      string
+	InCluster       string
+	Clusters        []string
+	ClustersRegex   string
+	ExcludeClusters []string
+	AllContexts     bool
+	ClusterTimeout  time.Duration
+	Retries         int
+	RetryInterval   time.Duration
+	QPS             float32
+	Burst           int
+	RequestTimeout  time.Duration
+	As              string
+	AsGroups        []string
+	Proxies         map[string]string
+	Concurrency     int
+	Debug           bool
+	Create          bool
+	Revoke          bool
+	Rotate          bool
+	Team            string
+	DryRun          bool
+
+	ServiceAccountNamespace  string
+	ServiceAccountNamespaces map[string]string
+	Servers                  map[string]string
+	CAFile                   string
+	CAFiles                  map[string]string
+	InsecureClusters         []string
+	AllNamespaces            bool
+
+	CreateRole  bool
+	Role        string
+	ClusterRole string
+	ClusterWide bool
+
+	TokenRequest   bool
+	TokenAudiences []string
+	TokenTTL       time.Duration
+	Auth           string
+	ExecCommand    string
+	ExecArgs       []string
+	OIDC           OIDCConfig
+	TokenTimeout   time.Duration
+	Verify         bool
+
+	Sinks       []string
+	SinkExec    []string
+	Output      string
+	OutputDir   string
+	SplitOutput string
+	Force       bool
+
+	VaultPath   string
+	VaultFields []string
+
+	GitHub GitHubConfig
+	GitLab GitLabConfig
+	Azure  AzureConfig
+
+	GCPSecret       string
+	GCPSecretLabels bool
+
+	AWS AWSConfig
+
+	KubernetesSecret KubernetesSecretConfig
+
+	EncryptGPG  string
+	EncryptAge  []string
+	EncryptSOPS bool
+	SOPSKeys    SOPSKeys
+
+	TeamsFile         string
+	BatchSize         int
+	Spread            time.Duration
+	HaltOnFailure     bool
+	GitOps            GitOpsConfig
+	Bundle            string
+	ContextTemplate   string
+	DefaultContext    string
+	SupportContact    string
+	ContextNamespace  string
+	ContextNamespaces map[string]string
+	Merge             string
+	OutputFormat      string
+	RawFields         []string
+	RawBase64         bool
+	SealedSecret      SealedSecretConfig
+	ExternalSecret    ExternalSecretConfig
+	StateFile         string
+	PlanFile          string
+	Report            string
+
+	OTLPEndpoint string
+
+	ListenAddress string
+	DebugAddress  string
+	GRPCAddress   string
+	ScanInterval  time.Duration
+
+	OperatorCluster   string
+	OperatorNamespace string
+	ResyncInterval    time.Duration
+
+	// OperatorAdminNamespaces may hold TeamAccess resources for any team, not only the team
+	// named like the namespace.
+	OperatorAdminNamespaces []string
+
+	LeaderElection LeaderElectionConfig
+
+	Schedule      string
+	DiscoverTeams bool
+
+	WebhookCluster string
+	TLSCertFile    string
+	TLSKeyFile     string
+	TrustedUsers   []string
+
+	ServerAuth ServerAuthConfig
+	Slack      SlackCommandConfig
+
+	ExpiringWithin time.Duration
+	MaxTokenAge    time.Duration
+	OutputJSON     bool
+	ShowClaims     bool
+
+	AuditLog      string
+	AuditURL      string
+	AuditOperator string
+
+	NotifySlackWebhook string
+	NotifyURL          string
+	Email              EmailConfig
+	CloudEventsURL     string
+	CloudEventsSource  string
+	Kafka              KafkaConfig
+	NATS               NATSConfig
+
+	SkipPermissionCheck bool
+	RotateIfOlderThan   time.Duration
+	CanaryClusters      []string
+	TwoPhase            bool
+	GracePeriod         time.Duration
+	RollbackOnFailure   bool
+	FinishRotation      bool
+	ExpiredOnly         bool
 }
 
-func CreateServiceAccount(client kubernetes.Interface, serviceAccountName string) (*v1.ServiceAccount, error) {
-	log.Debugf("attempting to create service account '%s' in namespace %s", serviceAccountName, Namespace)
-	serviceAccount := v1.ServiceAccount{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      serviceAccountName,
-			Namespace: Namespace,
+func DefaultConfig() *Config {
+	return &Config{
+		Clusters:       []string{"dev-fss", "dev-sbs", "prod-fss", "prod-sbs"},
+		ClusterTimeout: 2 * time.Minute,
+		Concurrency:    4,
+		BatchSize:      1,
+		Retries:        3,
+		RetryInterval:  200 * time.Millisecond,
+		TokenTimeout:   30 * time.Second,
+		ListenAddress:  ":8080",
+		ScanInterval:   5 * time.Minute,
+		ExpiringWithin: 14 * 24 * time.Hour,
+		ResyncInterval: time.Minute,
+
+		CloudEventsSource: "teamconfig",
+
+		NATS: NATSConfig{
+			Subject: "teamconfig.credential.{action}.{team}",
+		},
+
+		ContextTemplate:  "{{.Cluster}}",
+		ContextNamespace: "default",
+		OutputFormat:     "kubeconfig",
+		RawFields:        []string{"token"},
+		SealedSecret: SealedSecretConfig{
+			Namespace: "{team}",
+			Name:      "kubeconfig",
+			Key:       "kubeconfig",
 		},
-	}
-	return client.CoreV1().ServiceAccounts(Namespace).Create(&serviceAccount)
-}
 
-func ServiceAccountSecret(client kubernetes.Interface, serviceAccount v1.ServiceAccount) (*v1.Secret, error) {
-	if len(serviceAccount.Secrets) == 0 {
-		return nil, fmt.Errorf("no secret associated with service account '%s'", serviceAccount.Name)
+		ExternalSecret: ExternalSecretConfig{
+			StoreKind:       "ClusterSecretStore",
+			Namespace:       "{team}",
+			Name:            "kubeconfig",
+			RefreshInterval: "1h",
+		},
+
+		GitOps: GitOpsConfig{
+			Path: "{cluster}/{team}.yaml",
+		},
+
+		Auth:        AuthToken,
+		ExecCommand: "teamconfig",
+		ExecArgs:    []string{"token", "--team", "{team}", "--clusters", "{cluster}"},
+
+		VaultFields: []string{"kubeconfig"},
+
+		GitHub: GitHubConfig{
+			API:        defaultGitHubAPI,
+			Visibility: "private",
+			SecretName: "KUBECONFIG",
+			Fields:     []string{"kubeconfig"},
+		},
+
+		GitLab: GitLabConfig{
+			API:          defaultGitLabAPI,
+			VariableName: "KUBECONFIG",
+			Fields:       []string{"kubeconfig"},
+			Protected:    true,
+		},
+
+		KubernetesSecret: KubernetesSecretConfig{
+			Name: "{team}",
+		},
+
+		Azure: AzureConfig{
+			SecretName: "kubeconfig-{team}",
+			Fields:     []string{"kubeconfig"},
+		},
+
+		ServerAuth: ServerAuthConfig{
+			OIDCUsernameClaim: "email",
+			OIDCGroupsClaim:   "groups",
+		},
+
+		LeaderElection: LeaderElectionConfig{
+			LeaseName:     "teamconfig",
+			Namespace:     DefaultServiceAccountNamespace,
+			LeaseDuration: 15 * time.Second,
+		},
+
+		ServiceAccountNamespace:  DefaultServiceAccountNamespace,
+		ServiceAccountNamespaces: make(map[string]string),
+		Servers:                  make(map[string]string),
+		CAFiles:                  make(map[string]string),
+		Proxies:                  make(map[string]string),
 	}
-	secretRef := serviceAccount.Secrets[0]
-	log.Debugf("attempting to retrieve secret '%s' in namespace %s", secretRef.Name, Namespace)
-	return client.CoreV1().Secrets(Namespace).Get(secretRef.Name, metav1.GetOptions{})
 }
 
-func AuthInfo(secret v1.Secret) clientcmdapi.AuthInfo {
-	return clientcmdapi.AuthInfo{
-		Token: string(secret.Data["token"]),
+// server returns the API server URL that teams should use for a cluster,
+// given the URL used by teamconfig itself.
+func (c *Config) server(cluster, host string) string {
+	if server, ok := c.Servers[cluster]; ok {
+		return server
 	}
+	return host
 }
 
-func clusterExec(cluster string, userConfig *clientcmdapi.Config) error {
-	clientConfig, err := buildConfigFromFlags(cluster, os.Getenv("KUBECONFIG"))
-	if err != nil {
-		return err
+// serviceAccountNamespace returns the namespace of team service accounts in a cluster.
+func (c *Config) serviceAccountNamespace(cluster string) string {
+	if namespace, ok := c.ServiceAccountNamespaces[cluster]; ok {
+		return namespace
 	}
+	return c.ServiceAccountNamespace
+}
 
-	client, err := KubeClient(clientConfig)
-	if err != nil {
-		return err
+// contextNamespace returns the namespace of the context of a cluster in the Kubeconfig file of a team.
+func (c *Config) contextNamespace(team, cluster string) string {
+	namespace, ok := c.ContextNamespaces[cluster]
+	if !ok {
+		namespace = c.ContextNamespace
 	}
+	return strings.Replace(namespace, "{team}", team, -1)
+}
 
-	serviceAccountName := ServiceAccountName(config.Team)
-	deleted := false
-
-	// if revoking access or rotating keys, delete the service account if it exists
-	if config.Rotate || config.Revoke {
-		err = DeleteServiceAccount(client, serviceAccountName)
-		if err == nil {
-			if config.Revoke {
-				log.Infof("%s: revoked access for service account '%s'", cluster, serviceAccountName)
-				return nil
-			}
-			deleted = true
-		} else {
-			if errors.IsNotFound(err) && !config.Create {
-				log.Debugf("%s: service account '%s' not found", cluster, serviceAccountName)
-			} else {
-				return fmt.Errorf("while deleting service account: %s", err)
-			}
-		}
-	}
+func (c *Config) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Kubeconfig, "kubeconfig", c.Kubeconfig, "Path to the Kubeconfig file with credentials for the clusters. Several files may be given, separated like in $KUBECONFIG. Defaults to $KUBECONFIG, or ~/.kube/config.")
+	fs.StringVar(&c.InCluster, "in-cluster", c.InCluster, "Name of the cluster teamconfig runs in, e.g. as a Job. This cluster is reached using the pod's service account instead of the Kubeconfig file.")
+	fs.StringSliceVar(&c.Clusters, "clusters", c.Clusters, "Which clusters to operate on. Glob patterns such as 'dev-*' are matched against the default clusters, or all contexts with --all-contexts.")
+	fs.StringVar(&c.ClustersRegex, "clusters-regex", c.ClustersRegex, "Only operate on clusters matching this regular expression.")
+	fs.StringSliceVar(&c.ExcludeClusters, "exclude-clusters", c.ExcludeClusters, "Do not operate on these clusters, even if selected by other flags. Glob patterns are allowed.")
+	fs.BoolVar(&c.AllContexts, "all-contexts", c.AllContexts, "Operate on all contexts in the Kubeconfig file. If --clusters is given, only those contexts are used.")
+	fs.StringVar(&c.Team, "team", c.Team, "Team name that will own the configuration file.")
+	fs.IntVar(&c.Concurrency, "concurrency", c.Concurrency, "How many clusters to operate on in parallel.")
+	fs.DurationVar(&c.ClusterTimeout, "cluster-timeout", c.ClusterTimeout, "Abort operations on a cluster that take longer than this. Zero means no timeout.")
+	fs.IntVar(&c.Retries, "retries", c.Retries, "How many times to retry Kubernetes API requests that fail with timeouts, throttling or server errors. Zero disables retries.")
+	fs.DurationVar(&c.RetryInterval, "retry-interval", c.RetryInterval, "How long to wait before the first retry. The interval doubles for every retry.")
+	fs.Float32Var(&c.QPS, "kube-qps", c.QPS, "Maximum Kubernetes API requests per second to each cluster. Zero uses the client-go default of 5.")
+	fs.IntVar(&c.Burst, "kube-burst", c.Burst, "Maximum burst of Kubernetes API requests to each cluster. Zero uses the client-go default of 10.")
+	fs.DurationVar(&c.RequestTimeout, "request-timeout", c.RequestTimeout, "Timeout for each Kubernetes API request. Zero means no timeout, other than --cluster-timeout.")
+	fs.StringVar(&c.As, "as", c.As, "Impersonate this user or service account, e.g. system:serviceaccount:kube-system:teamconfig, when talking to the clusters.")
+	fs.StringArrayVar(&c.AsGroups, "as-group", c.AsGroups, "Impersonate this group when talking to the clusters. May be repeated.")
+	fs.StringToStringVar(&c.Proxies, "cluster-proxy", c.Proxies, "Reach specific clusters through an HTTP, HTTPS or SOCKS5 proxy, e.g. prod-fss=socks5://localhost:1080.")
+	fs.BoolVar(&c.Debug, "debug", c.Debug, "Print debugging information.")
+	fs.StringVar(&c.OTLPEndpoint, "otlp-endpoint", c.OTLPEndpoint, "Export traces of cluster operations to this OTLP/HTTP endpoint, e.g. http://localhost:4318. OTEL_EXPORTER_OTLP_ENDPOINT is also honored.")
+	fs.StringVar(&c.ServiceAccountNamespace, "service-account-namespace", c.ServiceAccountNamespace, "Namespace where team service accounts live.")
+	fs.StringToStringVar(&c.ServiceAccountNamespaces, "cluster-service-account-namespace", c.ServiceAccountNamespaces, "Override --service-account-namespace for specific clusters, e.g. prod-fss=team-access.")
+}
 
-	// create service account
-	if config.Rotate || config.Create {
-		_, err = CreateServiceAccount(client, serviceAccountName)
-		if err != nil {
-			if errors.IsAlreadyExists(err) {
-				log.Debugf("%s: service account '%s' already exists", cluster, serviceAccountName)
-			} else {
-				return fmt.Errorf("while creating service account: %s", err)
-			}
-		} else if config.Rotate && deleted {
-			log.Infof("%s: rotated token for service account '%s'", cluster, serviceAccountName)
-		} else if config.Create {
-			log.Infof("%s: created service account '%s'", cluster, serviceAccountName)
-		}
+// addGenerateFlags adds flags for commands that generate Kubeconfig files.
+func (c *Config) addGenerateFlags(fs *flag.FlagSet) {
+	c.addCredentialFlags(fs)
+	fs.StringSliceVar(&c.Sinks, "sink", c.Sinks, "Where to write the credentials: "+strings.Join(sinkTypeNames(), ", ")+". May be repeated. Defaults to every destination configured by other flags, or stdout if there are none.")
+	fs.StringArrayVar(&c.SinkExec, "sink-exec", c.SinkExec, "Run this program with the Kubeconfig file on standard input, and the team in TEAMCONFIG_TEAM and clusters in TEAMCONFIG_CLUSTERS. May be repeated.")
+	fs.StringVarP(&c.Output, "output", "o", c.Output, "Write the Kubeconfig file to this path instead of standard output. The file is only readable by you.")
+	fs.StringVar(&c.OutputDir, "output-dir", c.OutputDir, "Write the Kubeconfig file to <team>.yaml in this directory. Required when using --teams-file.")
+	fs.StringVar(&c.SplitOutput, "split-output", c.SplitOutput, "Write one Kubeconfig file per cluster, named <team>-<cluster>.yaml, to this directory.")
+	fs.StringVar(&c.OutputFormat, "output-format", c.OutputFormat, "Format of the files written to --output, --output-dir, --split-output and standard output: "+strings.Join(outputFormatNames(), ", ")+".")
+	fs.StringSliceVar(&c.RawFields, "field", c.RawFields, "Fields written with --output-format raw: token, ca.crt, namespace and server. Several fields are written one per line.")
+	fs.BoolVar(&c.RawBase64, "base64", c.RawBase64, "Base64 encode the fields written with --output-format raw.")
+	fs.StringVar(&c.SealedSecret.Cert, "sealed-secret-cert", c.SealedSecret.Cert, "Certificate of the sealed-secrets controller, as fetched with kubeseal --fetch-cert, used with --output-format sealedsecret.")
+	fs.StringVar(&c.SealedSecret.Namespace, "sealed-secret-namespace", c.SealedSecret.Namespace, "Namespace of the secret unsealed from --output-format sealedsecret. {team} is replaced with the team name.")
+	fs.StringVar(&c.SealedSecret.Name, "sealed-secret-name", c.SealedSecret.Name, "Name of the secret unsealed from --output-format sealedsecret. {team} is replaced with the team name.")
+	fs.StringVar(&c.SealedSecret.Key, "sealed-secret-key", c.SealedSecret.Key, "Key of the Kubeconfig file in the secret unsealed from --output-format sealedsecret.")
+	fs.StringVar(&c.Merge, "merge", c.Merge, "Merge the clusters, users and contexts into this existing Kubeconfig file, e.g. ~/.kube/config, keeping its other contexts. {team} is replaced with the team name.")
+	fs.StringVar(&c.Bundle, "bundle", c.Bundle, "Write a .tar.gz archive with the Kubeconfig file, a Kubeconfig file per cluster, a README and metadata to this path, e.g. {team}.tar.gz. {team} is replaced with the team name.")
+	fs.BoolVar(&c.Force, "force", c.Force, "Overwrite output files if they already exist.")
+	fs.StringVar(&c.VaultPath, "vault-path", c.VaultPath, "Store the credentials in Vault at this path, e.g. secret/teams/{team}/kubeconfig. {team} is replaced with the team name.")
+	fs.StringSliceVar(&c.VaultFields, "vault-fields", c.VaultFields, "What to store in Vault: 'kubeconfig' for the Kubeconfig file, and 'tokens' for one token-<cluster> field per cluster.")
+	fs.StringVar(&c.GitHub.Repo, "github-repo", c.GitHub.Repo, "Upload the credentials as GitHub Actions secrets to this repository, e.g. navikt/{team}-deploy. {team} is replaced with the team name.")
+	fs.StringVar(&c.GitHub.Org, "github-org", c.GitHub.Org, "Upload the credentials as GitHub Actions secrets to this organization.")
+	fs.StringVar(&c.GitHub.Visibility, "github-org-visibility", c.GitHub.Visibility, "Which repositories can use organization secrets: all or private.")
+	fs.StringVar(&c.GitHub.SecretName, "github-secret-name", c.GitHub.SecretName, "Name of the GitHub Actions secret holding the Kubeconfig file. Tokens are stored as <name>_TOKEN_<CLUSTER>.")
+	fs.StringSliceVar(&c.GitHub.Fields, "github-fields", c.GitHub.Fields, "What to upload to GitHub: 'kubeconfig' for the Kubeconfig file, and 'tokens' for one secret per cluster.")
+	fs.StringVar(&c.GitHub.API, "github-api", c.GitHub.API, "GitHub API URL, for GitHub Enterprise Server.")
+	fs.StringVar(&c.GitHub.AppID, "github-app-id", c.GitHub.AppID, "Authenticate as this GitHub App instead of using GITHUB_TOKEN.")
+	fs.StringVar(&c.GitHub.AppInstallationID, "github-app-installation-id", c.GitHub.AppInstallationID, "Installation ID of the GitHub App.")
+	fs.StringVar(&c.GitHub.AppPrivateKey, "github-app-private-key", c.GitHub.AppPrivateKey, "Path to the private key of the GitHub App.")
+	fs.StringVar(&c.GitLab.Project, "gitlab-project", c.GitLab.Project, "Store the credentials as CI/CD variables in this GitLab project, e.g. teams/{team}. {team} is replaced with the team name.")
+	fs.StringVar(&c.GitLab.Group, "gitlab-group", c.GitLab.Group, "Store the credentials as CI/CD variables in this GitLab group. {team} is replaced with the team name.")
+	fs.StringVar(&c.GitLab.VariableName, "gitlab-variable-name", c.GitLab.VariableName, "Name of the file variable holding the Kubeconfig file. Tokens are stored as <name>_TOKEN_<CLUSTER>.")
+	fs.StringSliceVar(&c.GitLab.Fields, "gitlab-fields", c.GitLab.Fields, "What to store in GitLab: 'kubeconfig' for the Kubeconfig file, and 'tokens' for one masked variable per cluster.")
+	fs.BoolVar(&c.GitLab.Protected, "gitlab-protected", c.GitLab.Protected, "Only expose the variables to pipelines on protected branches and tags.")
+	fs.StringVar(&c.GitLab.API, "gitlab-api", c.GitLab.API, "GitLab API URL, for self-managed GitLab instances.")
+	fs.StringVar(&c.Azure.KeyVault, "azure-key-vault", c.Azure.KeyVault, "Store the credentials in this Azure Key Vault, given by name or URL.")
+	fs.StringVar(&c.Azure.SecretName, "azure-secret-name", c.Azure.SecretName, "Name of the Key Vault secret holding the Kubeconfig file. {team} is replaced with the team name. Tokens are stored as <name>-token-<cluster>.")
+	fs.StringSliceVar(&c.Azure.Fields, "azure-fields", c.Azure.Fields, "What to store in Azure Key Vault: 'kubeconfig' for the Kubeconfig file, and 'tokens' for one secret per cluster.")
+	fs.StringVar(&c.GCPSecret, "gcp-secret", c.GCPSecret, "Store the Kubeconfig file as a new version of this Google Secret Manager secret, e.g. projects/<project>/secrets/{team}-kubeconfig. {team} is replaced with the team name.")
+	fs.BoolVar(&c.GCPSecretLabels, "gcp-secret-labels", c.GCPSecretLabels, "Label the Google Secret Manager secret with the team and clusters.")
+	fs.StringVar(&c.AWS.Secret, "aws-secret", c.AWS.Secret, "Store the Kubeconfig file in this AWS Secrets Manager secret, given by name or ARN. {team} is replaced with the team name.")
+	fs.StringVar(&c.AWS.Region, "aws-region", c.AWS.Region, "AWS region of the Secrets Manager secret. Defaults to the region in your AWS configuration.")
+	fs.StringVar(&c.AWS.KMSKeyID, "aws-kms-key-id", c.AWS.KMSKeyID, "KMS key used to encrypt newly created Secrets Manager secrets, instead of the default key.")
+	fs.StringVar(&c.ExternalSecret.Dir, "external-secret-dir", c.ExternalSecret.Dir, "Write an ExternalSecret manifest, named <team>-externalsecret.yaml, pointing at the Kubeconfig file in Vault or a cloud secret store to this directory.")
+	fs.StringVar(&c.ExternalSecret.Store, "external-secret-store", c.ExternalSecret.Store, "Name of the secret store referenced by ExternalSecret manifests.")
+	fs.StringVar(&c.ExternalSecret.StoreKind, "external-secret-store-kind", c.ExternalSecret.StoreKind, "Kind of the secret store referenced by ExternalSecret manifests: SecretStore or ClusterSecretStore.")
+	fs.StringVar(&c.ExternalSecret.Namespace, "external-secret-namespace", c.ExternalSecret.Namespace, "Namespace of ExternalSecret manifests. {team} is replaced with the team name.")
+	fs.StringVar(&c.ExternalSecret.Name, "external-secret-name", c.ExternalSecret.Name, "Name of ExternalSecret manifests and the secrets they create. {team} is replaced with the team name.")
+	fs.StringVar(&c.ExternalSecret.RefreshInterval, "external-secret-refresh-interval", c.ExternalSecret.RefreshInterval, "How often External Secrets Operator fetches rotated credentials.")
+	fs.StringVar(&c.KubernetesSecret.Cluster, "secret-cluster", c.KubernetesSecret.Cluster, "Store the Kubeconfig file as a secret in this cluster, so that teams can retrieve it themselves.")
+	fs.StringVar(&c.KubernetesSecret.Namespace, "secret-namespace", c.KubernetesSecret.Namespace, "Namespace of the secret in --secret-cluster. {team} is replaced with the team name.")
+	fs.StringVar(&c.KubernetesSecret.Name, "secret-name", c.KubernetesSecret.Name, "Name of the secret in --secret-cluster. {team} is replaced with the team name.")
+	fs.StringVar(&c.EncryptGPG, "encrypt-gpg", c.EncryptGPG, "Encrypt the Kubeconfig files with GPG for this recipient, given as a key ID in your keyring or a path to a public key file.")
+	fs.StringSliceVar(&c.EncryptAge, "encrypt-age", c.EncryptAge, "Encrypt the Kubeconfig files with age for these recipients, given as age1... public keys or SSH public keys.")
+	fs.BoolVar(&c.EncryptSOPS, "encrypt-sops", c.EncryptSOPS, "Encrypt the Kubeconfig files as SOPS documents, using the keys given by the --sops-* flags.")
+	fs.StringSliceVar(&c.SOPSKeys.KMS, "sops-kms", c.SOPSKeys.KMS, "AWS KMS key ARNs to encrypt SOPS documents with.")
+	fs.StringSliceVar(&c.SOPSKeys.GCPKMS, "sops-gcp-kms", c.SOPSKeys.GCPKMS, "Google Cloud KMS resource IDs to encrypt SOPS documents with.")
+	fs.StringSliceVar(&c.SOPSKeys.AzureKV, "sops-azure-kv", c.SOPSKeys.AzureKV, "Azure Key Vault key URLs to encrypt SOPS documents with.")
+	fs.StringSliceVar(&c.SOPSKeys.Age, "sops-age", c.SOPSKeys.Age, "age recipients to encrypt SOPS documents with.")
+	fs.StringSliceVar(&c.SOPSKeys.PGP, "sops-pgp", c.SOPSKeys.PGP, "PGP fingerprints to encrypt SOPS documents with.")
+}
 
-		// Sleep for a bit to allow server to generate token
-		time.Sleep(100 * time.Millisecond)
-	}
+// addCredentialFlags adds flags controlling the credentials in generated Kubeconfig files.
+func (c *Config) addCredentialFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.TokenRequest, "token-request", c.TokenRequest, "Mint tokens using the TokenRequest API, falling back to token secrets on clusters that do not support it. Required for Kubernetes 1.24 and newer.")
+	c.addTokenRequestFlags(fs)
+	fs.StringVar(&c.ContextNamespace, "context-namespace", c.ContextNamespace, "Namespace of the contexts in generated Kubeconfig files, e.g. {team}. {team} is replaced with the team name.")
+	fs.StringToStringVar(&c.ContextNamespaces, "cluster-context-namespace", c.ContextNamespaces, "Override --context-namespace for specific clusters, e.g. prod-fss={team}-prod.")
+	fs.StringVar(&c.DefaultContext, "default-context", c.DefaultContext, "Cluster that generated Kubeconfig files start in, e.g. dev-fss. Defaults to the first cluster.")
+	fs.StringVar(&c.SupportContact, "support-contact", c.SupportContact, "Where teams can ask for help, e.g. '#nais on Slack'. Included in the header comment of generated Kubeconfig files.")
+	fs.StringVar(&c.ContextTemplate, "context-template", c.ContextTemplate, "Go template naming the contexts, users and clusters in generated Kubeconfig files, e.g. '{{.Team}}-{{.Cluster}}'. .Team and .Cluster are available.")
+	fs.StringToStringVar(&c.Servers, "cluster-server", c.Servers, "API server URL to use in generated Kubeconfig files for specific clusters, e.g. prod-fss=https://api.prod-fss.example.com. Defaults to the URL in your Kubeconfig file.")
+	fs.StringVar(&c.CAFile, "ca-file", c.CAFile, "Embed this CA bundle in generated Kubeconfig files, instead of the CA certificate of each cluster.")
+	fs.StringToStringVar(&c.CAFiles, "cluster-ca-file", c.CAFiles, "Embed this CA bundle for specific clusters, e.g. onprem-1=/etc/ssl/private-ca.pem. Overrides --ca-file.")
+	fs.StringSliceVar(&c.InsecureClusters, "insecure-skip-tls-verify", c.InsecureClusters, "Clusters whose API server certificate should not be verified by clients of generated Kubeconfig files. Only use this for lab clusters.")
+	fs.StringVar(&c.Auth, "auth", c.Auth, "How clients authenticate in generated Kubeconfig files: 'token' embeds the service account token, 'exec' runs --exec-command to fetch short-lived tokens on demand, and 'oidc' logs in with OpenID Connect using kubelogin.")
+	fs.StringVar(&c.ExecCommand, "exec-command", c.ExecCommand, "Credential plugin run by clients with --auth exec.")
+	fs.StringArrayVar(&c.ExecArgs, "exec-arg", c.ExecArgs, "Arguments to --exec-command. {team} and {cluster} are replaced with the team and cluster name. May be repeated.")
+	fs.StringVar(&c.OIDC.IssuerURL, "oidc-issuer-url", c.OIDC.IssuerURL, "Issuer URL of the OpenID Connect provider used with --auth oidc.")
+	fs.StringVar(&c.OIDC.ClientID, "oidc-client-id", c.OIDC.ClientID, "OpenID Connect client ID used with --auth oidc.")
+	fs.StringVar(&c.OIDC.ClientSecret, "oidc-client-secret", c.OIDC.ClientSecret, "OpenID Connect client secret used with --auth oidc, for providers that require one.")
+	fs.StringSliceVar(&c.OIDC.ExtraScopes, "oidc-extra-scopes", c.OIDC.ExtraScopes, "Additional scopes to request with --auth oidc, e.g. groups.")
+	fs.BoolVar(&c.Verify, "verify", c.Verify, "Check that the generated credentials work, by listing pods in the service account namespace with each new token. Fails the run if a token is rejected.")
+	fs.DurationVar(&c.TokenTimeout, "token-timeout", c.TokenTimeout, "How long to wait for the token secret of a newly created service account.")
+}
 
-	// get service account for this team
-	serviceAccount, err := ServiceAccount(client, serviceAccountName)
-	if err != nil {
-		return fmt.Errorf("while retrieving service account: %s", err)
-	}
+// addTokenRequestFlags adds flags controlling tokens minted with the TokenRequest API.
+func (c *Config) addTokenRequestFlags(fs *flag.FlagSet) {
+	fs.StringSliceVar(&c.TokenAudiences, "token-audience", c.TokenAudiences, "Bind tokens minted with the TokenRequest API to these audiences. Defaults to the API server. Tokens are only accepted by the API server if one of the audiences is its own.")
+	fs.DurationVar(&c.TokenTTL, "token-ttl", c.TokenTTL, "Lifetime of tokens minted with the TokenRequest API, e.g. 720h. Must be at least 10m. Defaults to the API server default of one hour.")
+}
 
-	// get service account secret token
-	secret, err := ServiceAccountSecret(client, *serviceAccount)
-	if err != nil {
-		return fmt.Errorf("while retrieving secret token: %s", err)
-	}
+// addMutateFlags adds flags for commands that make changes to clusters.
+func (c *Config) addMutateFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.DryRun, "dry-run", c.DryRun, "Show which service accounts would be created, rotated or deleted, without making any changes.")
+	fs.BoolVar(&c.SkipPermissionCheck, "skip-permission-check", c.SkipPermissionCheck, "Do not check permissions with SelfSubjectAccessReviews before making changes.")
+	fs.StringVar(&c.AuditLog, "audit-log", c.AuditLog, "Append a JSON record of every change to this file.")
+	fs.StringVar(&c.AuditURL, "audit-url", c.AuditURL, "Post a JSON record of every change to this HTTP endpoint.")
+	fs.StringVar(&c.AuditOperator, "audit-operator", c.AuditOperator, "Operator identity recorded in the audit log and rotation history. Defaults to the current user.")
+	fs.StringVar(&c.NotifySlackWebhook, "notify-slack-webhook", c.NotifySlackWebhook, "Post a message to this Slack incoming webhook URL whenever team credentials are created, rotated or revoked.")
+	fs.StringVar(&c.NotifyURL, "notify-url", c.NotifyURL, "Post a signed JSON notification to this HTTP endpoint whenever team credentials are created, rotated or revoked. The signing secret is read from TEAMCONFIG_NOTIFY_SECRET.")
+	fs.StringVar(&c.Email.SMTPServer, "smtp-server", c.Email.SMTPServer, "Email team owners through this SMTP server, given as host:port, when their credentials are rotated. Credentials are read from SMTP_USERNAME and SMTP_PASSWORD.")
+	fs.StringVar(&c.Email.From, "smtp-from", c.Email.From, "Sender address of emails to team owners.")
+	fs.StringArrayVar(&c.Email.Addresses, "team-email", c.Email.Addresses, "Email address of the owners of a team, given as team=address. May be repeated.")
+	fs.StringVar(&c.Email.AddressesFile, "team-emails-file", c.Email.AddressesFile, "YAML file mapping teams to lists of email addresses of their owners.")
+	fs.StringVar(&c.Email.AddressTemplate, "team-email-template", c.Email.AddressTemplate, "Email address of teams not given by --team-email or --team-emails-file, e.g. {team}@example.com. {team} is replaced with the team name.")
+	fs.StringVar(&c.CloudEventsURL, "cloudevents-url", c.CloudEventsURL, "Publish CloudEvents of type teamconfig.credential.created, .rotated and .revoked to this HTTP endpoint or broker.")
+	fs.StringVar(&c.CloudEventsSource, "cloudevents-source", c.CloudEventsSource, "Source attribute of published CloudEvents.")
+	fs.StringSliceVar(&c.Kafka.Brokers, "kafka-brokers", c.Kafka.Brokers, "Publish an event to Kafka through these brokers for every cluster where team credentials are created, rotated or revoked. SASL/PLAIN credentials are read from KAFKA_USERNAME and KAFKA_PASSWORD.")
+	fs.StringVar(&c.Kafka.Topic, "kafka-topic", c.Kafka.Topic, "Kafka topic to publish events to.")
+	fs.BoolVar(&c.Kafka.TLS, "kafka-tls", c.Kafka.TLS, "Connect to the Kafka brokers with TLS.")
+	fs.StringVar(&c.Kafka.CAFile, "kafka-ca-file", c.Kafka.CAFile, "CA bundle used to verify the Kafka brokers. Implies --kafka-tls.")
+	fs.StringVar(&c.Kafka.CertFile, "kafka-cert-file", c.Kafka.CertFile, "Client certificate presented to the Kafka brokers. Implies --kafka-tls.")
+	fs.StringVar(&c.Kafka.KeyFile, "kafka-key-file", c.Kafka.KeyFile, "Private key of the Kafka client certificate.")
+	fs.StringVar(&c.NATS.URL, "nats-url", c.NATS.URL, "Publish an event to NATS through these servers, separated by commas, for every cluster where team credentials are created, rotated or revoked.")
+	fs.StringVar(&c.NATS.Subject, "nats-subject", c.NATS.Subject, "NATS subject to publish events to. {action}, {team} and {cluster} are replaced with e.g. rotated, the team and the cluster.")
+	fs.StringVar(&c.NATS.Credentials, "nats-credentials", c.NATS.Credentials, "NATS credentials file with the user JWT and seed.")
+	fs.StringVar(&c.NATS.CAFile, "nats-ca-file", c.NATS.CAFile, "CA bundle used to verify the NATS servers.")
+}
 
-	authInfo := AuthInfo(*secret)
+// addRBACFlags adds flags controlling access granted to team service accounts.
+func (c *Config) addRBACFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.CreateRole, "create-role", c.CreateRole, "Create a role with permissions to deploy applications, and bind it to the service account. Revoking deletes the role and binding.")
+	fs.StringVar(&c.Role, "role", c.Role, "Bind the service account to this existing role in its namespace. Revoking deletes the binding, but not the role.")
+	fs.StringVar(&c.ClusterRole, "cluster-role", c.ClusterRole, "Bind the service account to this existing cluster role in its namespace. Revoking deletes the binding.")
+	fs.BoolVar(&c.ClusterWide, "cluster-wide", c.ClusterWide, "Grant --cluster-role in all namespaces, using a cluster role binding.")
+}
 
-	userConfig.AuthInfos[cluster] = &authInfo
-	userConfig.Clusters[cluster] = &clientcmdapi.Cluster{
-		Server:                clientConfig.Host,
-	}
-	userConfig.Contexts[cluster] = &clientcmdapi.Context{
-		Namespace: "default",
-		AuthInfo:  cluster,
-		Cluster:   cluster,
-	}
+// addDebugFlags adds flags for diagnosing long-running commands.
+func (c *Config) addDebugFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.DebugAddress, "debug-address", c.DebugAddress, "Address to serve pprof profiles and Go runtime metrics on, e.g. localhost:6060. Disabled by default.")
+}
 
-	return nil
+// addServerAuthFlags adds flags for serving the API over TLS, and authenticating and authorizing callers.
+func (c *Config) addServerAuthFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.TLSCertFile, "tls-cert-file", c.TLSCertFile, "File containing the TLS certificate of the API. Served without TLS if not given.")
+	fs.StringVar(&c.TLSKeyFile, "tls-key-file", c.TLSKeyFile, "File containing the TLS private key of the API.")
+	fs.StringVar(&c.ServerAuth.ClientCAFile, "client-ca-file", c.ServerAuth.ClientCAFile, "Authenticate callers presenting a client certificate signed by this CA. The common name is the user, and organizations are groups.")
+	fs.BoolVar(&c.ServerAuth.RequireClientCert, "require-client-cert", c.ServerAuth.RequireClientCert, "Reject TLS connections without a client certificate signed by --client-ca-file, including health checks.")
+	fs.StringVar(&c.ServerAuth.OIDCIssuerURL, "api-oidc-issuer-url", c.ServerAuth.OIDCIssuerURL, "Authenticate callers presenting an ID token from this OpenID Connect issuer as a bearer token.")
+	fs.StringVar(&c.ServerAuth.OIDCAudience, "api-oidc-audience", c.ServerAuth.OIDCAudience, "Audience that ID tokens must be issued for, usually the client ID.")
+	fs.StringVar(&c.ServerAuth.OIDCUsernameClaim, "api-oidc-username-claim", c.ServerAuth.OIDCUsernameClaim, "ID token claim holding the user name.")
+	fs.StringVar(&c.ServerAuth.OIDCGroupsClaim, "api-oidc-groups-claim", c.ServerAuth.OIDCGroupsClaim, "ID token claim holding the groups of the user.")
+	fs.StringVar(&c.ServerAuth.PolicyFile, "policy-file", c.ServerAuth.PolicyFile, "YAML file granting users and groups operations on teams. Required when callers are authenticated.")
+	fs.BoolVar(&c.Slack.Enabled, "slack-commands", c.Slack.Enabled, "Handle '/teamconfig rotate <team>' Slack slash commands on /slack/command, verified with the signing secret in SLACK_SIGNING_SECRET.")
+	fs.StringSliceVar(&c.Slack.Approvers, "slack-approvers", c.Slack.Approvers, "Slack user IDs allowed to approve rotations requested with slash commands. Required with --slack-commands.")
 }
 
-func run() error {
-	config.addFlags()
-	flag.Parse()
+// addLeaderElectionFlags adds flags for long-running commands that can run with several replicas.
+func (c *Config) addLeaderElectionFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.LeaderElection.Enabled, "leader-elect", c.LeaderElection.Enabled, "Only do work while holding a lease, so that only one of several replicas makes changes at a time.")
+	fs.StringVar(&c.LeaderElection.LeaseName, "lease-name", c.LeaderElection.LeaseName, "Name of the lease used with --leader-elect.")
+	fs.StringVar(&c.LeaderElection.Namespace, "lease-namespace", c.LeaderElection.Namespace, "Namespace of the lease used with --leader-elect.")
+	fs.DurationVar(&c.LeaderElection.LeaseDuration, "lease-duration", c.LeaderElection.LeaseDuration, "How long other replicas wait before taking over the lease of a leader that stopped renewing it.")
+}
 
-	if config.Debug {
-		log.SetLevel(log.TraceLevel)
-	} else {
-		log.SetLevel(log.InfoLevel)
-	}
+// addBatchFlags adds flags for commands that can operate on several teams at once.
+func (c *Config) addBatchFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.TeamsFile, "teams-file", c.TeamsFile, "Operate on all teams listed in this file, instead of --team. Plain text files list one team per line; .yaml files contain a list of team names.")
+	c.addSpreadFlags(fs)
+}
 
-	log.SetOutput(os.Stderr)
+// addSpreadFlags adds flags controlling how the work is spread when operating on many teams.
+func (c *Config) addSpreadFlags(fs *flag.FlagSet) {
+	fs.IntVar(&c.BatchSize, "batch-size", c.BatchSize, "How many teams to operate on in parallel. Each cluster sees at most this many teams at a time.")
+	fs.DurationVar(&c.Spread, "spread", c.Spread, "Spread the batches of teams evenly over this long, e.g. 2h, starting each batch at a random point within its share.")
+	fs.BoolVar(&c.HaltOnFailure, "halt-on-failure", c.HaltOnFailure, "Skip the remaining teams if any team in a batch fails.")
+}
 
-	if len(config.Team) == 0 {
-		flag.Usage()
-		return fmt.Errorf("team name must be specified")
-	}
+// addRotationFlags adds flags for commands that rotate team service users.
+func (c *Config) addRotationFlags(fs *flag.FlagSet) {
+	fs.StringSliceVar(&c.CanaryClusters, "canary-clusters", c.CanaryClusters, "Rotate and verify these clusters first, e.g. 'dev-*', and only continue with the remaining clusters if they all succeed. Requires --verify.")
+	fs.BoolVar(&c.RollbackOnFailure, "rollback-on-failure", c.RollbackOnFailure, "If rotating a team fails in any cluster, restore its previous token in the clusters where it succeeded.")
+}
 
-	if config.Revoke && (config.Create || config.Rotate) {
-		return fmt.Errorf("--revoke is mutually exclusive with --create and --rotate")
-	}
+// addReportFlags adds flags for commands that operate on team service accounts.
+func (c *Config) addReportFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Report, "report", c.Report, "Write a JSON report of the outcome in each cluster to this file.")
+}
 
-	failed := false
-	userConfig := clientcmdapi.NewConfig()
+func (c *Config) addStateFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.StateFile, "state-file", c.StateFile, "YAML file listing the teams that should have a service user, and optionally in which clusters. Team service users not listed are revoked.")
+}
 
-	for _, cluster := range config.Clusters {
-		log.Debugf("%s: entering cluster", cluster)
+// addLegacyFlags adds the flags used before teamconfig had subcommands.
+func (c *Config) addLegacyFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Create, "create", c.Create, "Create teams that do not exist.")
+	fs.BoolVar(&c.Revoke, "revoke", c.Revoke, "Delete any tokens that belongs to this team.")
+	fs.BoolVar(&c.Rotate, "rotate", c.Rotate, "Rotate secret tokens that are already present in cluster. This will invalidate old tokens.")
+	fs.MarkDeprecated("create", "use 'teamconfig create' instead")
+	fs.MarkDeprecated("revoke", "use 'teamconfig revoke' instead")
+	fs.MarkDeprecated("rotate", "use 'teamconfig rotate' instead")
+}
 
-		err := clusterExec(cluster, userConfig)
+// dayDuration is a flag value holding a duration, which also accepts a number of days such as 90d.
+type dayDuration time.Duration
 
-		if err == nil {
-			log.Debugf("%s: successfully generated configuration", cluster)
-		} else {
-			log.Errorf("%s: %s", cluster, err)
-			failed = true
+func (d *dayDuration) Set(s string) error {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return fmt.Errorf("invalid number of days '%s'", s)
 		}
+		*d = dayDuration(time.Duration(days) * 24 * time.Hour)
+		return nil
 	}
-
-	if failed {
-		return fmt.Errorf("exiting due to errors")
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
 	}
+	*d = dayDuration(duration)
+	return nil
+}
 
-	if config.Revoke {
-		log.Infof("successfully revoked keys")
-		return nil
+func (d *dayDuration) String() string {
+	if *d == 0 {
+		return "0"
 	}
+	return time.Duration(*d).String()
+}
 
-	userConfig.CurrentContext = config.Clusters[0]
+func (d *dayDuration) Type() string {
+	return "duration"
+}
 
-	output, err := clientcmd.Write(*userConfig)
-	if err != nil {
-		return fmt.Errorf("while generating output: %s", err)
-	}
+var config = DefaultConfig()
 
-	stdout := bufio.NewWriter(os.Stdout)
-	_, err = stdout.Write(output)
-	stdout.Flush()
+// configKey is the context key of the configuration of a single operation.
+type configKey struct{}
 
-	if err != nil {
-		return fmt.Errorf("while writing output: %s", err)
-	}
-	log.Debugf("configuration file written to stdout")
+// withConfig returns a context for an operation using c instead of the global configuration. Long-running
+// commands use it to run operations on a single team, so that the global configuration, which other
+// goroutines read, is never changed after startup.
+func withConfig(ctx context.Context, c *Config) context.Context {
+	return context.WithValue(ctx, configKey{}, c)
+}
 
-	return nil
+// configFrom returns the configuration of the operation running in ctx, or the global configuration.
+// Everything an operation does must be configured from it, so that concurrent operations each use
+// their own settings.
+func configFrom(ctx context.Context) *Config {
+	if c, ok := ctx.Value(configKey{}).(*Config); ok {
+		return c
+	}
+	return config
 }
 
 func main() {
-	err := run()
+	err := run(os.Args[1:])
 	if err != nil {
 		log.Errorf("fatal: %s", err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// setConfig replaces the global configuration for the duration of a test.
+func setConfig(t *testing.T, c *Config) {
+	previous := config
+	config = c
+	t.Cleanup(func() { config = previous })
+}
+
+func TestDayDurationSet(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		expected time.Duration
+		err      bool
+	}{
+		{value: "90d", expected: 90 * 24 * time.Hour},
+		{value: "1d", expected: 24 * time.Hour},
+		{value: "0d", expected: 0},
+		{value: "24h", expected: 24 * time.Hour},
+		{value: "1h30m", expected: 90 * time.Minute},
+		{value: "0", expected: 0},
+		{value: "d", err: true},
+		{value: "1.5d", err: true},
+		{value: "xd", err: true},
+		{value: "90", err: true},
+		{value: "", err: true},
+	} {
+		t.Run(test.value, func(t *testing.T) {
+			var d dayDuration
+			err := d.Set(test.value)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", time.Duration(d))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if time.Duration(d) != test.expected {
+				t.Errorf("got %s, expected %s", time.Duration(d), test.expected)
+			}
+		})
+	}
+}
+
+func TestDayDurationString(t *testing.T) {
+	for _, test := range []struct {
+		duration time.Duration
+		expected string
+	}{
+		{0, "0"},
+		{90 * time.Minute, "1h30m0s"},
+		{48 * time.Hour, "48h0m0s"},
+	} {
+		d := dayDuration(test.duration)
+		if got := d.String(); got != test.expected {
+			t.Errorf("%d: got %s, expected %s", test.duration, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSConfig configures publishing of credential events to NATS.
+type NATSConfig struct {
+	URL         string
+	Subject     string
+	Credentials string
+	CAFile      string
+}
+
+// NATSNotifier publishes an event to NATS for every cluster where a team service account was changed.
+type NATSNotifier struct {
+	Subject string
+	Conn    *nats.Conn
+}
+
+// NewNATSNotifier connects to the NATS servers.
+func NewNATSNotifier(cfg NATSConfig) (*NATSNotifier, error) {
+	options := []nats.Option{
+		nats.Name("teamconfig"),
+		nats.Timeout(10 * time.Second),
+	}
+	if len(cfg.Credentials) > 0 {
+		options = append(options, nats.UserCredentials(cfg.Credentials))
+	}
+	if len(cfg.CAFile) > 0 {
+		options = append(options, nats.RootCAs(cfg.CAFile))
+	}
+
+	conn, err := nats.Connect(cfg.URL, options...)
+	if err != nil {
+		return nil, fmt.Errorf("while connecting to NATS: %s", err)
+	}
+
+	return &NATSNotifier{
+		Subject: cfg.Subject,
+		Conn:    conn,
+	}, nil
+}
+
+// subject returns the subject of an event, with {action}, {team} and {cluster} replaced.
+func (s *NATSNotifier) subject(n *Notification, event ClusterEvent) string {
+	replacer := strings.NewReplacer("{action}", n.pastTense(), "{team}", event.Team, "{cluster}", event.Cluster)
+	return replacer.Replace(s.Subject)
+}
+
+func (s *NATSNotifier) Notify(ctx context.Context, n *Notification) error {
+	events := n.clusterEvents()
+	if len(events) == 0 {
+		return nil
+	}
+
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		err = s.Conn.Publish(s.subject(n, event), data)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Publish only buffers the events, so wait for the server to receive them
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return s.Conn.FlushWithContext(ctx)
+}
+
+func (s *NATSNotifier) Close() error {
+	return s.Conn.Drain()
+}
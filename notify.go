@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Notification describes a completed create, rotate or revoke operation on a team.
+// It never contains credentials.
+type Notification struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Team     string    `json:"team"`
+	Operator string    `json:"operator"`
+	Host     string    `json:"host,omitempty"`
+
+	// Clusters lists the clusters where the team service account was changed.
+	Clusters []string `json:"clusters"`
+
+	// Failed lists the clusters where the operation failed.
+	Failed []string `json:"failed,omitempty"`
+	Error  string   `json:"error,omitempty"`
+
+	// PreviousTokensValid is set if the tokens replaced by a rotation still work, as with
+	// --two-phase, until they are invalidated by finish-rotation.
+	PreviousTokensValid bool `json:"previousTokensValid,omitempty"`
+
+	// PreviousTokensExpire is when the grace period of the replaced tokens ends, with --grace-period.
+	PreviousTokensExpire *time.Time `json:"previousTokensExpire,omitempty"`
+}
+
+// NewNotification describes the outcome of an operation on a team. It returns nil if
+// nothing was changed and nothing failed, so that unchanged teams are not announced.
+func NewNotification(action, operator string, report *TeamReport) *Notification {
+	n := &Notification{
+		Time:     time.Now().UTC(),
+		Action:   action,
+		Team:     report.Team,
+		Operator: operator,
+		Clusters: make([]string, 0),
+		Error:    report.Error,
+	}
+	n.Host, _ = os.Hostname()
+
+	for _, result := range report.Clusters {
+		switch {
+		case len(result.Error) > 0:
+			n.Failed = append(n.Failed, result.Cluster)
+		case result.Action == ActionCreated || result.Action == ActionRotated || result.Action == ActionRevoked:
+			n.Clusters = append(n.Clusters, result.Cluster)
+		}
+	}
+
+	if len(n.Clusters) == 0 && len(n.Error) == 0 {
+		return nil
+	}
+	return n
+}
+
+// ClusterEvent records a change to a team service account in a single cluster,
+// as published to Kafka and NATS.
+type ClusterEvent struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Team     string    `json:"team"`
+	Cluster  string    `json:"cluster"`
+	Operator string    `json:"operator"`
+}
+
+// clusterEvents returns an event for every cluster where the team service account was changed.
+func (n *Notification) clusterEvents() []ClusterEvent {
+	events := make([]ClusterEvent, len(n.Clusters))
+	for i, cluster := range n.Clusters {
+		events[i] = ClusterEvent{
+			Time:     n.Time,
+			Action:   n.Action,
+			Team:     n.Team,
+			Cluster:  cluster,
+			Operator: n.Operator,
+		}
+	}
+	return events
+}
+
+// Notifier tells people or systems that the credentials of a team have changed.
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// NotifierType describes a kind of notifier enabled by flags.
+type NotifierType struct {
+	Name string
+
+	// Configured returns true if the flags for this notifier are given.
+	Configured func() bool
+
+	New func() (Notifier, error)
+}
+
+// NotifierTypes lists all notifiers in the order they are notified.
+var NotifierTypes = []NotifierType{
+	{
+		Name:       "slack",
+		Configured: func() bool { return len(config.NotifySlackWebhook) > 0 },
+		New:        func() (Notifier, error) { return NewSlackNotifier(config.NotifySlackWebhook), nil },
+	},
+	{
+		Name:       "webhook",
+		Configured: func() bool { return len(config.NotifyURL) > 0 },
+		New: func() (Notifier, error) {
+			return NewWebhookNotifier(config.NotifyURL, os.Getenv("TEAMCONFIG_NOTIFY_SECRET"))
+		},
+	},
+	{
+		Name:       "email",
+		Configured: func() bool { return len(config.Email.SMTPServer) > 0 },
+		New:        func() (Notifier, error) { return NewEmailNotifier(config.Email) },
+	},
+	{
+		Name:       "cloudevents",
+		Configured: func() bool { return len(config.CloudEventsURL) > 0 },
+		New: func() (Notifier, error) {
+			return NewCloudEventsNotifier(config.CloudEventsURL, config.CloudEventsSource), nil
+		},
+	},
+	{
+		Name:       "kafka",
+		Configured: func() bool { return len(config.Kafka.Brokers) > 0 },
+		New:        func() (Notifier, error) { return NewKafkaNotifier(config.Kafka) },
+	},
+	{
+		Name:       "nats",
+		Configured: func() bool { return len(config.NATS.URL) > 0 },
+		New:        func() (Notifier, error) { return NewNATSNotifier(config.NATS) },
+	},
+}
+
+// notifiers holds the configured notifiers, if any.
+var notifiers = make(map[string]Notifier)
+
+// openNotifiers sets up the notifiers enabled by flags.
+func openNotifiers() error {
+	for _, notifierType := range NotifierTypes {
+		if !notifierType.Configured() {
+			continue
+		}
+		notifier, err := notifierType.New()
+		if err != nil {
+			return fmt.Errorf("while setting up %s notifications: %s", notifierType.Name, err)
+		}
+		notifiers[notifierType.Name] = notifier
+	}
+	return nil
+}
+
+// closeNotifiers closes the connections of notifiers that keep them open, such as Kafka producers.
+func closeNotifiers() {
+	for name, notifier := range notifiers {
+		if closer, ok := notifier.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Errorf("while closing %s notifier: %s", name, err)
+			}
+		}
+	}
+}
+
+// notify sends a notification about a completed operation on a team to all configured notifiers.
+// Failures are logged, since the operation itself has already happened.
+func notify(ctx context.Context, report *TeamReport) {
+	c := configFrom(ctx)
+	action := c.auditAction()
+	if len(notifiers) == 0 || len(action) == 0 {
+		return
+	}
+	n := NewNotification(action, c.operatorIdentity(), report)
+	if n == nil {
+		return
+	}
+	// Without --two-phase, previous tokens are only kept if the Kubeconfig file could not be delivered.
+	if c.Rotate && (c.TwoPhase || c.RollbackOnFailure && len(report.Error) > 0) {
+		n.PreviousTokensValid = true
+		if c.TwoPhase && c.GracePeriod > 0 {
+			expires := n.Time.Add(c.GracePeriod)
+			n.PreviousTokensExpire = &expires
+		}
+	}
+
+	for _, notifierType := range NotifierTypes {
+		notifier, ok := notifiers[notifierType.Name]
+		if !ok {
+			continue
+		}
+		err := notifier.Notify(ctx, n)
+		if err != nil {
+			log.Errorf("team %s: while sending %s notification: %s", n.Team, notifierType.Name, err)
+		}
+	}
+}
+
+// pastTense returns the action of a notification as used in messages, e.g. "rotated".
+func (n *Notification) pastTense() string {
+	switch n.Action {
+	case "create":
+		return ActionCreated
+	case "rotate":
+		return ActionRotated
+	case "revoke":
+		return ActionRevoked
+	default:
+		return n.Action
+	}
+}
+
+// SlackNotifier posts notifications to a Slack incoming webhook.
+type SlackNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewSlackNotifier(url string) *SlackNotifier {
+	return &SlackNotifier{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *SlackNotifier) Notify(ctx context.Context, n *Notification) error {
+	text := fmt.Sprintf("%s failed to %s the credentials of team *%s*.", n.Operator, n.Action, n.Team)
+	if len(n.Clusters) > 0 {
+		text = fmt.Sprintf("Credentials of team *%s* %s by %s in %s.", n.Team, n.pastTense(), n.Operator, strings.Join(n.Clusters, ", "))
+	}
+	if len(n.Failed) > 0 {
+		text += fmt.Sprintf("\n:warning: Failed in %s.", strings.Join(n.Failed, ", "))
+	} else if len(n.Error) > 0 {
+		text += fmt.Sprintf("\n:warning: %s", n.Error)
+	}
+	return jsonRequest(ctx, s.Client, http.MethodPost, s.URL, nil, slackMessage{Text: text}, nil)
+}
+
+// WebhookNotifier posts notifications as JSON to an HTTP endpoint. Each request is signed with
+// HMAC-SHA256 over the timestamp and body, so that receivers can check that it came from teamconfig.
+type WebhookNotifier struct {
+	URL    string
+	Secret []byte
+	Client *http.Client
+}
+
+func NewWebhookNotifier(url, secret string) (*WebhookNotifier, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("--notify-url requires a signing secret in the TEAMCONFIG_NOTIFY_SECRET environment variable")
+	}
+	return &WebhookNotifier{
+		URL:    url,
+		Secret: []byte(secret),
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// signature returns the signature of a payload sent at timestamp.
+func (w *WebhookNotifier) signature(timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, w.Secret)
+	fmt.Fprintf(mac, "%s.%s", timestamp, payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, n *Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	headers := map[string]string{
+		"X-Teamconfig-Timestamp": timestamp,
+		"X-Teamconfig-Signature": w.signature(timestamp, payload),
+	}
+	return jsonRequest(ctx, w.Client, http.MethodPost, w.URL, headers, json.RawMessage(payload), nil)
+}
+
+// CloudEvent is a notification in the structured JSON format of CloudEvents 1.0.
+type CloudEvent struct {
+	SpecVersion     string        `json:"specversion"`
+	ID              string        `json:"id"`
+	Source          string        `json:"source"`
+	Type            string        `json:"type"`
+	Subject         string        `json:"subject"`
+	Time            time.Time     `json:"time"`
+	DataContentType string        `json:"datacontenttype"`
+	Data            *Notification `json:"data"`
+}
+
+// NewCloudEvent wraps a notification in a CloudEvent of type teamconfig.credential.<action>, e.g.
+// teamconfig.credential.rotated. The subject is the team.
+func NewCloudEvent(source string, n *Notification) (*CloudEvent, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	return &CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              hex.EncodeToString(random),
+		Source:          source,
+		Type:            "teamconfig.credential." + n.pastTense(),
+		Subject:         n.Team,
+		Time:            n.Time,
+		DataContentType: "application/json",
+		Data:            n,
+	}, nil
+}
+
+// CloudEventsNotifier posts notifications as CloudEvents to an HTTP endpoint, such as a Knative broker.
+// Operations that changed nothing are not published.
+type CloudEventsNotifier struct {
+	URL    string
+	Source string
+	Client *http.Client
+}
+
+func NewCloudEventsNotifier(url, source string) *CloudEventsNotifier {
+	return &CloudEventsNotifier{
+		URL:    url,
+		Source: source,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *CloudEventsNotifier) Notify(ctx context.Context, n *Notification) error {
+	if len(n.Clusters) == 0 {
+		return nil
+	}
+	event, err := NewCloudEvent(c.Source, n)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{
+		"Content-Type": "application/cloudevents+json; charset=utf-8",
+	}
+	return jsonRequest(ctx, c.Client, http.MethodPost, c.URL, headers, event, nil)
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TeamAccessResource identifies the TeamAccess custom resource, defined in deploy/teamaccess-crd.yaml.
+var TeamAccessResource = schema.GroupVersionResource{
+	Group:    "teamconfig.nais.io",
+	Version:  "v1alpha1",
+	Resource: "teamaccesses",
+}
+
+// TeamAccess declares that a team should have a service user in a set of clusters,
+// rotated at a regular interval, with the Kubeconfig file kept in a secret next to it.
+type TeamAccess struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TeamAccessSpec   `json:"spec"`
+	Status TeamAccessStatus `json:"status,omitempty"`
+}
+
+type TeamAccessSpec struct {
+	Team string `json:"team"`
+
+	// Clusters defaults to the clusters selected with the operator's flags.
+	Clusters []string `json:"clusters,omitempty"`
+
+	// RotationInterval is a Go duration or a number of days, such as 90d. Tokens are never rotated if empty.
+	RotationInterval string `json:"rotationInterval,omitempty"`
+
+	// SecretName defaults to <name>-kubeconfig.
+	SecretName string `json:"secretName,omitempty"`
+}
+
+type TeamAccessStatus struct {
+	// Team is the team that was last granted access, so that it can be revoked if spec.team changes.
+	Team string `json:"team,omitempty"`
+
+	// ObservedGeneration is the generation of the spec that was last reconciled.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// SecretName is the secret in the same namespace holding the Kubeconfig file.
+	SecretName string `json:"secretName,omitempty"`
+
+	LastRotationTime *metav1.Time `json:"lastRotationTime,omitempty"`
+
+	Conditions []TeamAccessCondition     `json:"conditions,omitempty"`
+	Clusters   []TeamAccessClusterStatus `json:"clusters,omitempty"`
+}
+
+// Condition types of TeamAccess resources.
+const (
+	// ConditionReady is true when the team service user exists in every cluster,
+	// and the Kubeconfig file in the secret is up to date.
+	ConditionReady = "Ready"
+
+	// ConditionDegraded is true when the last reconciliation failed in some clusters.
+	ConditionDegraded = "Degraded"
+)
+
+// TeamAccessCondition follows the conventions for conditions of Kubernetes resources.
+type TeamAccessCondition struct {
+	Type               string      `json:"type"`
+	Status             string      `json:"status"`
+	Reason             string      `json:"reason,omitempty"`
+	Message            string      `json:"message,omitempty"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+}
+
+// TeamAccessClusterStatus describes the team service user in a single cluster.
+type TeamAccessClusterStatus struct {
+	Cluster            string       `json:"cluster"`
+	Action             string       `json:"action,omitempty"`
+	Error              string       `json:"error,omitempty"`
+	LastRotationTime   *metav1.Time `json:"lastRotationTime,omitempty"`
+	ObservedGeneration int64        `json:"observedGeneration,omitempty"`
+}
+
+// setCondition adds or updates a condition. The transition time is only changed if the status changes.
+func (s *TeamAccessStatus) setCondition(conditionType string, status bool, reason, message string) {
+	condition := TeamAccessCondition{
+		Type:               conditionType,
+		Status:             string(v1.ConditionFalse),
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: metav1.Now(),
+	}
+	if status {
+		condition.Status = string(v1.ConditionTrue)
+	}
+
+	for i, existing := range s.Conditions {
+		if existing.Type != conditionType {
+			continue
+		}
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		s.Conditions[i] = condition
+		return
+	}
+	s.Conditions = append(s.Conditions, condition)
+}
+
+// clusterStatus returns the status entry of a cluster, or nil if there is none.
+func (s *TeamAccessStatus) clusterStatus(cluster string) *TeamAccessClusterStatus {
+	for i := range s.Clusters {
+		if s.Clusters[i].Cluster == cluster {
+			return &s.Clusters[i]
+		}
+	}
+	return nil
+}
+
+// recordReconcile updates the status after a reconciliation, from its outcome in each cluster.
+func (t *TeamAccess) recordReconcile(report *TeamReport, err error) {
+	now := metav1.Now()
+	clusters := make([]TeamAccessClusterStatus, 0, len(report.Clusters))
+	failed := 0
+	for _, result := range report.Clusters {
+		clusterStatus := TeamAccessClusterStatus{
+			Cluster:            result.Cluster,
+			Action:             result.Action,
+			Error:              result.Error,
+			ObservedGeneration: t.Generation,
+		}
+		if previous := t.Status.clusterStatus(result.Cluster); previous != nil {
+			clusterStatus.LastRotationTime = previous.LastRotationTime
+		}
+		switch {
+		case len(result.Error) > 0 || result.Action == ActionSkipped:
+			failed++
+		case result.Action == ActionCreated || result.Action == ActionRotated || clusterStatus.LastRotationTime == nil:
+			clusterStatus.LastRotationTime = &now
+		}
+		clusters = append(clusters, clusterStatus)
+	}
+	if len(report.Clusters) > 0 {
+		t.Status.Clusters = clusters
+	}
+
+	if err != nil {
+		t.Status.setCondition(ConditionReady, false, "ReconcileFailed", err.Error())
+	} else {
+		t.Status.ObservedGeneration = t.Generation
+		t.Status.setCondition(ConditionReady, true, "Reconciled", fmt.Sprintf("Kubeconfig file stored in secret %s", t.Status.SecretName))
+	}
+
+	if failed > 0 {
+		t.Status.setCondition(ConditionDegraded, true, "ClusterFailures", fmt.Sprintf("failed in %d of %d clusters", failed, len(report.Clusters)))
+	} else {
+		t.Status.setCondition(ConditionDegraded, false, "AllClustersReconciled", "")
+	}
+}
+
+// secretName returns the name of the secret holding the Kubeconfig file.
+func (t *TeamAccess) secretName() string {
+	if len(t.Spec.SecretName) > 0 {
+		return t.Spec.SecretName
+	}
+	return t.Name + "-kubeconfig"
+}
+
+// rotationDue returns true if the tokens of the team are older than the rotation interval.
+func (t *TeamAccess) rotationDue() (bool, error) {
+	if len(t.Spec.RotationInterval) == 0 || t.Status.LastRotationTime == nil {
+		return false, nil
+	}
+	var interval dayDuration
+	if err := interval.Set(t.Spec.RotationInterval); err != nil {
+		return false, fmt.Errorf("invalid rotationInterval: %s", err)
+	}
+	return time.Since(t.Status.LastRotationTime.Time) >= time.Duration(interval), nil
+}
+
+// TeamAccessFinalizer keeps a deleted TeamAccess resource around until the team service user
+// has been revoked in its clusters.
+const TeamAccessFinalizer = "teamconfig.nais.io/revoke"
+
+// clusters returns the clusters the team should have access to. Resources outside
+// --operator-admin-namespaces may only grant access to the team named like their namespace, and
+// only in the clusters selected with the operator's flags.
+func (t *TeamAccess) clusters() ([]string, error) {
+	if len(t.Spec.Team) == 0 {
+		return nil, fmt.Errorf("spec.team must be set")
+	}
+	if t.Spec.Team != t.Namespace && !contains(config.OperatorAdminNamespaces, t.Namespace) {
+		return nil, fmt.Errorf("spec.team must be '%s'; only resources in --operator-admin-namespaces may grant access to other teams", t.Namespace)
+	}
+	if len(t.Spec.Clusters) == 0 {
+		return config.Clusters, nil
+	}
+	clusters, err := selectClusters(t.Spec.Clusters)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spec.clusters: %s", err)
+	}
+	return clusters, nil
+}
+
+// granted returns the clusters the team in the status was given access to.
+func (s *TeamAccessStatus) granted() []string {
+	clusters := make([]string, 0, len(s.Clusters))
+	for _, clusterStatus := range s.Clusters {
+		clusters = append(clusters, clusterStatus.Cluster)
+	}
+	return clusters
+}
+
+// TeamAccessClaims records which clusters each team has access to through TeamAccess resources,
+// so that access granted by one resource is not revoked when another is changed or deleted.
+type TeamAccessClaims map[string]map[string]bool
+
+// claim records that team has access to clusters.
+func (c TeamAccessClaims) claim(team string, clusters []string) {
+	if c[team] == nil {
+		c[team] = make(map[string]bool)
+	}
+	for _, cluster := range clusters {
+		c[team][cluster] = true
+	}
+}
+
+// unclaimed returns those of the clusters where no resource gives team access.
+func (c TeamAccessClaims) unclaimed(team string, clusters []string) []string {
+	result := make([]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		if !c[team][cluster] {
+			result = append(result, cluster)
+		}
+	}
+	return result
+}
+
+// revokeTeamAccess revokes the team service user in clusters.
+func revokeTeamAccess(ctx context.Context, team string, clusters []string) error {
+	if len(team) == 0 || len(clusters) == 0 {
+		return nil
+	}
+	c := *configFrom(ctx)
+	c.Clusters = clusters
+	c.Create, c.Rotate, c.Revoke = false, false, true
+
+	log.Infof("team %s: revoking access in %s", team, strings.Join(clusters, ", "))
+	_, err := teamCredentials(withConfig(ctx, &c), team, &TeamReport{Team: team})
+	if err != nil {
+		return fmt.Errorf("while revoking team %s: %s", team, err)
+	}
+	return nil
+}
+
+// revokeDropped revokes the team service user in the clusters that were in the status, but no
+// longer are wanted, and that no other resource gives the team access to. If spec.team changed,
+// the previous team is revoked in every cluster.
+func (t *TeamAccess) revokeDropped(ctx context.Context, clusters []string, claims TeamAccessClaims) error {
+	wanted := make(map[string]bool)
+	if t.Status.Team == t.Spec.Team {
+		for _, cluster := range clusters {
+			wanted[cluster] = true
+		}
+	}
+	dropped := make([]string, 0)
+	for _, cluster := range t.Status.granted() {
+		if !wanted[cluster] {
+			dropped = append(dropped, cluster)
+		}
+	}
+	dropped = claims.unclaimed(t.Status.Team, dropped)
+
+	err := revokeTeamAccess(ctx, t.Status.Team, dropped)
+	if err != nil {
+		return err
+	}
+	t.Status.Team = t.Spec.Team
+	return nil
+}
+
+// reconcileTeamAccess makes sure that the team service user exists in clusters, rotates it when
+// the rotation interval has passed, and stores the Kubeconfig file in the secret named in the
+// status. The outcome in each cluster is added to report.
+func reconcileTeamAccess(ctx context.Context, client kubernetes.Interface, access *TeamAccess, clusters []string, report *TeamReport) error {
+	due, err := access.rotationDue()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.CoreV1().Secrets(access.Namespace).Get(access.secretName(), metav1.GetOptions{})
+	upToDate := access.Status.SecretName == access.secretName() && access.Status.ObservedGeneration == access.Generation
+	if err == nil && !due && upToDate {
+		log.Debugf("%s/%s: up to date", access.Namespace, access.Name)
+		return nil
+	} else if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("while retrieving secret: %s", err)
+	}
+
+	c := *configFrom(ctx)
+	c.Clusters = clusters
+	c.Create = true
+	c.Rotate = due
+	ctx = withConfig(ctx, &c)
+
+	report.Team = access.Spec.Team
+	credentials, err := teamCredentials(ctx, access.Spec.Team, report)
+	if err != nil {
+		return err
+	}
+
+	kubeconfig, err := renderKubeconfig(ctx, access.Spec.Team, credentials, false)
+	if err != nil {
+		return err
+	}
+
+	err = ApplyKubeconfigSecret(client, access.Namespace, access.secretName(), access.Spec.Team, kubeconfig)
+	if err != nil {
+		return fmt.Errorf("while storing Kubeconfig file: %s", err)
+	}
+
+	access.Status.SecretName = access.secretName()
+	if due || access.Status.LastRotationTime == nil {
+		now := metav1.Now()
+		access.Status.LastRotationTime = &now
+	}
+	if due {
+		log.Infof("%s/%s: rotated credentials for team %s", access.Namespace, access.Name, access.Spec.Team)
+	} else {
+		log.Infof("%s/%s: stored credentials for team %s in secret %s", access.Namespace, access.Name, access.Spec.Team, access.secretName())
+	}
+
+	return nil
+}
+
+// updateTeamAccessStatus writes the status of a TeamAccess resource back to the cluster.
+func updateTeamAccessStatus(resources dynamic.NamespaceableResourceInterface, obj *unstructured.Unstructured, access *TeamAccess) error {
+	status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&access.Status)
+	if err != nil {
+		return err
+	}
+	err = unstructured.SetNestedField(obj.Object, status, "status")
+	if err != nil {
+		return err
+	}
+	_, err = resources.Namespace(obj.GetNamespace()).UpdateStatus(obj, metav1.UpdateOptions{})
+	return err
+}
+
+// setFinalizer adds or removes TeamAccessFinalizer, and returns the updated resource.
+func setFinalizer(resources dynamic.NamespaceableResourceInterface, obj *unstructured.Unstructured, present bool) (*unstructured.Unstructured, error) {
+	finalizers := make([]string, 0)
+	for _, finalizer := range obj.GetFinalizers() {
+		if finalizer != TeamAccessFinalizer {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	if present {
+		finalizers = append(finalizers, TeamAccessFinalizer)
+	}
+	obj.SetFinalizers(finalizers)
+	return resources.Namespace(obj.GetNamespace()).Update(obj, metav1.UpdateOptions{})
+}
+
+// finalizeTeamAccess revokes the team service user of a deleted TeamAccess resource in the
+// clusters where no other resource gives the team access, and then lets the deletion complete.
+func finalizeTeamAccess(ctx context.Context, resources dynamic.NamespaceableResourceInterface, obj *unstructured.Unstructured, access *TeamAccess, claims TeamAccessClaims) error {
+	if !contains(obj.GetFinalizers(), TeamAccessFinalizer) {
+		return nil
+	}
+	err := revokeTeamAccess(ctx, access.Status.Team, claims.unclaimed(access.Status.Team, access.Status.granted()))
+	if err != nil {
+		return err
+	}
+	_, err = setFinalizer(resources, obj, false)
+	if err != nil {
+		return fmt.Errorf("while removing finalizer: %s", err)
+	}
+	log.Infof("%s/%s: revoked access for team %s", access.Namespace, access.Name, access.Status.Team)
+	return nil
+}
+
+// reconcileAll reconciles every TeamAccess resource once. Failures are logged, and do not
+// prevent other resources from being reconciled.
+func reconcileAll(ctx context.Context, client kubernetes.Interface, resources dynamic.NamespaceableResourceInterface, health *Health) error {
+	list, err := resources.Namespace(config.OperatorNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("while listing TeamAccess resources: %s", err)
+	}
+
+	accesses := make([]*TeamAccess, len(list.Items))
+	claims := make(TeamAccessClaims)
+	for i := range list.Items {
+		obj := &list.Items[i]
+		access := &TeamAccess{}
+		err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, access)
+		if err != nil {
+			log.Errorf("%s/%s: %s", obj.GetNamespace(), obj.GetName(), err)
+			continue
+		}
+		accesses[i] = access
+		if clusters, err := access.clusters(); err == nil && access.DeletionTimestamp == nil {
+			claims.claim(access.Spec.Team, clusters)
+		}
+	}
+
+	for i, access := range accesses {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if access == nil {
+			continue
+		}
+		obj := &list.Items[i]
+
+		if access.DeletionTimestamp != nil {
+			err = finalizeTeamAccess(ctx, resources, obj, access, claims)
+			if err != nil {
+				log.Errorf("%s/%s: %s", access.Namespace, access.Name, err)
+			}
+			health.Progress()
+			continue
+		}
+
+		if !contains(obj.GetFinalizers(), TeamAccessFinalizer) {
+			obj, err = setFinalizer(resources, obj, true)
+			if err != nil {
+				log.Errorf("%s/%s: while adding finalizer: %s", access.Namespace, access.Name, err)
+				continue
+			}
+		}
+
+		report := &TeamReport{}
+		clusters, err := access.clusters()
+		if err == nil {
+			err = access.revokeDropped(ctx, clusters, claims)
+		}
+		if err == nil {
+			err = reconcileTeamAccess(ctx, client, access, clusters, report)
+		}
+		if err != nil {
+			log.Errorf("%s/%s: %s", access.Namespace, access.Name, err)
+		}
+		access.recordReconcile(report, err)
+
+		err = updateTeamAccessStatus(resources, obj, access)
+		if err != nil {
+			log.Errorf("%s/%s: while updating status: %s", access.Namespace, access.Name, err)
+		}
+		health.Progress()
+	}
+
+	return nil
+}
+
+// operator reconciles TeamAccess resources in --operator-cluster until interrupted.
+func operator(ctx context.Context) error {
+	if len(config.OperatorCluster) == 0 {
+		return validationError(fmt.Errorf("--operator-cluster must be specified"))
+	}
+	if err := validateAuth(); err != nil {
+		return validationError(err)
+	}
+
+	clientConfig, client, err := clusterClient(ctx, config.OperatorCluster)
+	if err != nil {
+		return err
+	}
+	dynamicClient, err := DynamicClient(ctx, clientConfig, config.Retries, config.RetryInterval)
+	if err != nil {
+		return err
+	}
+	resources := dynamicClient.Resource(TeamAccessResource)
+
+	startDebugServer(ctx)
+
+	health := NewHealth()
+	mux := http.NewServeMux()
+	health.Register(mux)
+	server := &http.Server{
+		Addr:    config.ListenAddress,
+		Handler: mux,
+	}
+	go func() {
+		log.Infof("serving health checks on %s", config.ListenAddress)
+		err := server.ListenAndServe()
+		if err != http.ErrServerClosed {
+			log.Errorf("while serving health checks: %s", err)
+		}
+	}()
+	defer server.Shutdown(context.Background())
+
+	if config.LeaderElection.Enabled {
+		return runAsLeader(ctx, client, config.LeaderElection, func(ctx context.Context) error {
+			return runOperator(ctx, client, resources, health)
+		})
+	}
+	return runOperator(ctx, client, resources, health)
+}
+
+// runOperator reconciles all TeamAccess resources every --resync-interval until the context is done.
+func runOperator(ctx context.Context, client kubernetes.Interface, resources dynamic.NamespaceableResourceInterface, health *Health) error {
+	ticker := time.NewTicker(config.ResyncInterval)
+	defer ticker.Stop()
+
+	health.Start(config.ResyncInterval)
+	log.Infof("%s: reconciling TeamAccess resources every %s", config.OperatorCluster, config.ResyncInterval)
+	for {
+		err := reconcileAll(ctx, client, resources, health)
+		if err != nil && ctx.Err() == nil {
+			log.Errorf("%s: %s", config.OperatorCluster, err)
+		}
+		health.Completed(map[string]error{config.OperatorCluster: err})
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// WriteFileAtomic writes data to a file readable only by the current user.
+// The data is written to a temporary file in the same directory, which is
+// then moved into place, so that readers never see a partially written file.
+// An existing file is only replaced if overwrite is true.
+func WriteFileAtomic(path string, data []byte, overwrite bool) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// TempFile creates files with mode 0600, but be explicit about it.
+	err = tmp.Chmod(0600)
+	if err == nil {
+		_, err = tmp.Write(data)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if overwrite {
+		return os.Rename(tmp.Name(), path)
+	}
+
+	// Linking fails if the destination exists, which makes the existence
+	// check and the write a single atomic operation.
+	err = os.Link(tmp.Name(), path)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists; use --force to overwrite", path)
+	}
+	return err
+}
+
+// outputPath returns the path of the merged Kubeconfig file for a team,
+// or an empty string if it should be written to standard output.
+func outputPath(team string) string {
+	if len(config.OutputDir) > 0 {
+		return filepath.Join(config.OutputDir, team+outputExtension())
+	}
+	return config.Output
+}
+
+// splitOutputPath returns the path of the Kubeconfig file for a single cluster.
+func splitOutputPath(team, cluster string) string {
+	return filepath.Join(config.SplitOutput, fmt.Sprintf("%s-%s%s", team, cluster, outputExtension()))
+}
+
+// outputPaths returns all files that will be written by writeKubeconfigs.
+func outputPaths(ctx context.Context, team string) []string {
+	paths := make([]string, 0)
+	sinkTypes, err := selectedSinkTypes()
+	if err != nil {
+		return paths
+	}
+	for _, sinkType := range sinkTypes {
+		if sink, ok := sinkType.New().(fileSink); ok {
+			paths = append(paths, sink.Paths(ctx, team)...)
+		}
+	}
+	return paths
+}
+
+// checkOutputPaths returns an error if any output file already exists and --force is not given.
+func checkOutputPaths(ctx context.Context, team string) error {
+	if configFrom(ctx).Force {
+		return nil
+	}
+	for _, path := range outputPaths(ctx, team) {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists; use --force to overwrite", path)
+		}
+	}
+	return nil
+}
+
+// pruneKubeconfig removes clusters and users not used by any context.
+func pruneKubeconfig(userConfig *clientcmdapi.Config) {
+	clusters := make(map[string]bool)
+	authInfos := make(map[string]bool)
+	for _, context := range userConfig.Contexts {
+		clusters[context.Cluster] = true
+		authInfos[context.AuthInfo] = true
+	}
+	for name := range userConfig.Clusters {
+		if !clusters[name] {
+			delete(userConfig.Clusters, name)
+		}
+	}
+	for name := range userConfig.AuthInfos {
+		if !authInfos[name] {
+			delete(userConfig.AuthInfos, name)
+		}
+	}
+}
+
+// currentContext returns the context a Kubeconfig file with the given clusters starts in: the
+// cluster given by --default-context, or the first of them if that cluster is not in the file.
+func currentContext(ctx context.Context, credentials []*Credentials) string {
+	config := configFrom(ctx)
+	var first *Credentials
+	for _, c := range credentials {
+		if c == nil {
+			continue
+		}
+		if c.Cluster == config.DefaultContext {
+			return config.contextName(c)
+		}
+		if first == nil {
+			first = c
+		}
+	}
+	if first == nil {
+		return ""
+	}
+	// Files for a single cluster never contain the default context, so only warn about merged files.
+	if len(config.DefaultContext) > 0 && len(credentials) > 1 {
+		log.Warnf("%s: no credentials for --default-context; starting in %s instead", config.DefaultContext, first.Cluster)
+	}
+	return config.contextName(first)
+}
+
+// kubeconfigHeader returns the comment at the top of generated Kubeconfig files, describing
+// them to whoever finds the file later. Teams are told where to report a leak; only files
+// written for operators show the command that rotates the credentials.
+func (c *Config) kubeconfigHeader(team string, credentials []*Credentials, now time.Time, forOperators bool) []byte {
+	clusters := make([]string, 0, len(credentials))
+	for _, credential := range credentials {
+		if credential != nil {
+			clusters = append(clusters, credential.Cluster)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "# Kubeconfig file for team %s, generated by teamconfig %s at %s.\n", team, Version, now.UTC().Format(time.RFC3339))
+	fmt.Fprintf(buf, "# Clusters: %s\n", strings.Join(clusters, ", "))
+	fmt.Fprintf(buf, "#\n")
+	fmt.Fprintf(buf, "# These credentials may be rotated or revoked. If they stop working, ask for a new file.\n")
+	if forOperators {
+		fmt.Fprintf(buf, "# Keep this file secret. If it leaks, the credentials must be rotated with:\n")
+		fmt.Fprintf(buf, "#   teamconfig rotate --team %s\n", team)
+	} else if len(c.SupportContact) > 0 {
+		fmt.Fprintf(buf, "# Keep this file secret. If it leaks, report it to %s.\n", c.SupportContact)
+	} else {
+		fmt.Fprintf(buf, "# Keep this file secret. If it leaks, report it to whoever gave it to you.\n")
+	}
+	if len(c.SupportContact) > 0 {
+		fmt.Fprintf(buf, "#\n")
+		fmt.Fprintf(buf, "# Support: %s\n", c.SupportContact)
+	}
+	return buf.Bytes()
+}
+
+// operatorOutput returns whether generated files are only written to standard output, and so
+// are read by the operator running teamconfig rather than handed to the team.
+func operatorOutput(sinkTypes []SinkType) bool {
+	for _, sinkType := range sinkTypes {
+		if sinkType.Name != "stdout" {
+			return false
+		}
+	}
+	return len(sinkTypes) > 0
+}
+
+// renderKubeconfig generates a Kubeconfig file for a team with the given clusters, starting in the
+// one chosen by currentContext, stamped with a Generation extension and a header comment. The file
+// is validated, so that teams never receive a file kubectl cannot use. The header is written
+// for operators if forOperators is set, and for the team otherwise.
+func renderKubeconfig(ctx context.Context, team string, credentials []*Credentials, forOperators bool) ([]byte, error) {
+	userConfig := Kubeconfig(ctx, credentials)
+	userConfig.CurrentContext = currentContext(ctx, credentials)
+	pruneKubeconfig(userConfig)
+
+	now := time.Now()
+	extension, err := generationExtension(ctx, team, now)
+	if err != nil {
+		return nil, fmt.Errorf("while generating output: %s", err)
+	}
+	userConfig.Extensions[GenerationExtension] = extension
+
+	err = clientcmd.Validate(*userConfig)
+	if err != nil {
+		return nil, fmt.Errorf("generated Kubeconfig file is invalid: %s", err)
+	}
+
+	output, err := clientcmd.Write(*userConfig)
+	if err != nil {
+		return nil, fmt.Errorf("while generating output: %s", err)
+	}
+	return append(configFrom(ctx).kubeconfigHeader(team, credentials, now, forOperators), output...), nil
+}
+
+// writeKubeconfigs generates the merged Kubeconfig file for a team,
+// and writes it and the credentials to all selected sinks.
+func writeKubeconfigs(ctx context.Context, team string, credentials []*Credentials) error {
+	sinkTypes, err := selectedSinkTypes()
+	if err != nil {
+		return err
+	}
+
+	output, err := renderKubeconfig(ctx, team, credentials, operatorOutput(sinkTypes))
+	if err != nil {
+		return err
+	}
+
+	for _, sinkType := range sinkTypes {
+		log.Debugf("writing credentials to sink %s", sinkType.Name)
+		sinkCtx, span := tracer.Start(ctx, "sink "+sinkType.Name, trace.WithAttributes(attribute.String("team", team)))
+		err = sinkType.New().Write(sinkCtx, team, output, credentials)
+		endSpan(span, err)
+		if err != nil {
+			return fmt.Errorf("while writing to %s: %s", sinkType.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// writeOutput writes a generated file to the given path,
+// or to standard output if the path is empty.
+func writeOutput(path string, data []byte) error {
+	if len(path) > 0 {
+		err := WriteFileAtomic(path, data, config.Force)
+		if err != nil {
+			return err
+		}
+		log.Infof("configuration file written to %s", path)
+		return nil
+	}
+
+	stdout := bufio.NewWriter(os.Stdout)
+	_, err := stdout.Write(data)
+	if err == nil {
+		err = stdout.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	log.Debugf("configuration file written to stdout")
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKubeconfigHeader(t *testing.T) {
+	now := time.Date(2018, 10, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	for _, test := range []struct {
+		name           string
+		supportContact string
+		forOperators   bool
+		expected       []string
+		unexpected     []string
+	}{
+		{
+			name:           "team",
+			supportContact: "#nais",
+			expected: []string{
+				"# Kubeconfig file for team aura, generated by teamconfig " + Version + " at 2018-10-01T10:00:00Z.\n",
+				"# Clusters: dev-fss, prod-sbs\n",
+				"# Keep this file secret. If it leaks, report it to #nais.\n",
+				"# Support: #nais\n",
+			},
+			unexpected: []string{"teamconfig rotate"},
+		},
+		{
+			name:       "team without support contact",
+			expected:   []string{"# Keep this file secret. If it leaks, report it to whoever gave it to you.\n"},
+			unexpected: []string{"teamconfig rotate", "# Support:"},
+		},
+		{
+			name:           "operators",
+			supportContact: "#nais",
+			forOperators:   true,
+			expected: []string{
+				"# Keep this file secret. If it leaks, the credentials must be rotated with:\n",
+				"#   teamconfig rotate --team aura\n",
+				"# Support: #nais\n",
+			},
+			unexpected: []string{"report it to"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := &Config{SupportContact: test.supportContact}
+			header := string(c.kubeconfigHeader("aura", testCredentials(), now, test.forOperators))
+			for _, expected := range test.expected {
+				if !strings.Contains(header, expected) {
+					t.Errorf("header does not contain %q:\n%s", expected, header)
+				}
+			}
+			for _, unexpected := range test.unexpected {
+				if strings.Contains(header, unexpected) {
+					t.Errorf("header contains %q:\n%s", unexpected, header)
+				}
+			}
+			for _, line := range strings.Split(strings.TrimSuffix(header, "\n"), "\n") {
+				if !strings.HasPrefix(line, "#") {
+					t.Errorf("header line is not a comment: %q", line)
+				}
+			}
+		})
+	}
+}
+
+func TestOperatorOutput(t *testing.T) {
+	for _, test := range []struct {
+		sinks    []string
+		expected bool
+	}{
+		{sinks: []string{"stdout"}, expected: true},
+		{sinks: []string{"stdout", "vault"}, expected: false},
+		{sinks: []string{"file"}, expected: false},
+		{sinks: []string{}, expected: false},
+	} {
+		sinkTypes := make([]SinkType, 0, len(test.sinks))
+		for _, name := range test.sinks {
+			sinkTypes = append(sinkTypes, SinkType{Name: name})
+		}
+		if actual := operatorOutput(sinkTypes); actual != test.expected {
+			t.Errorf("operatorOutput(%v) = %t, want %t", test.sinks, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+
+	log "github.com/sirupsen/logrus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+)
+
+// Permission is an action on a kind of resource in a namespace.
+type Permission struct {
+	Verb        string
+	Resource    string
+	Subresource string
+}
+
+func (p Permission) String() string {
+	if len(p.Subresource) > 0 {
+		return fmt.Sprintf("%s %s/%s", p.Verb, p.Resource, p.Subresource)
+	}
+	return fmt.Sprintf("%s %s", p.Verb, p.Resource)
+}
+
+// joinPermissions returns a human readable list of permissions.
+func joinPermissions(permissions []Permission) string {
+	names := make([]string, len(permissions))
+	for i, permission := range permissions {
+		names[i] = permission.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+// ManagePermissions are needed to manage team service accounts and their token secrets.
+var ManagePermissions = []Permission{
+	{Verb: "get", Resource: "serviceaccounts"},
+	{Verb: "create", Resource: "serviceaccounts"},
+	{Verb: "delete", Resource: "serviceaccounts"},
+	{Verb: "get", Resource: "secrets"},
+	{Verb: "list", Resource: "secrets"},
+}
+
+// MissingPermissions asks the API server which of the permissions the current user lacks in
+// the namespace, using SelfSubjectAccessReviews.
+func MissingPermissions(client kubernetes.Interface, namespace string, permissions []Permission) ([]Permission, error) {
+	missing := make([]Permission, 0)
+	for _, permission := range permissions {
+		log.Tracef("attempting to review permission to %s in namespace %s", permission, namespace)
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   namespace,
+					Verb:        permission.Verb,
+					Resource:    permission.Resource,
+					Subresource: permission.Subresource,
+				},
+			},
+		}
+		result, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(review)
+		if err != nil {
+			return nil, err
+		}
+		if !result.Status.Allowed {
+			missing = append(missing, permission)
+		}
+	}
+	return missing, nil
+}
+
+// requiredPermissions returns the permissions needed for the configured operation
+// on a team service account.
+func (c *Config) requiredPermissions() []Permission {
+	permissions := []Permission{
+		{Verb: "get", Resource: "serviceaccounts"},
+	}
+	if c.Rotate || c.Revoke {
+		permissions = append(permissions, Permission{Verb: "delete", Resource: "serviceaccounts"})
+	}
+	if c.Rotate || c.Create {
+		permissions = append(permissions, Permission{Verb: "create", Resource: "serviceaccounts"})
+	}
+	if c.Rotate && (c.TwoPhase || c.RollbackOnFailure) {
+		permissions = append(permissions, Permission{Verb: "create", Resource: "secrets"})
+	}
+	if c.FinishRotation || c.Rotate && c.RollbackOnFailure {
+		permissions = append(permissions, Permission{Verb: "delete", Resource: "secrets"})
+	}
+	if !c.Revoke {
+		if c.TokenRequest {
+			permissions = append(permissions, Permission{Verb: "create", Resource: "serviceaccounts", Subresource: "token"})
+		}
+		permissions = append(permissions, Permission{Verb: "get", Resource: "secrets"})
+	}
+	return permissions
+}
+
+// checkPermissions fails if we lack any permission needed for the configured operation
+// in the namespace, so that no changes are made in clusters where the operation cannot
+// be completed.
+func checkPermissions(ctx context.Context, client kubernetes.Interface, namespace string) error {
+	missing, err := MissingPermissions(client, namespace, configFrom(ctx).requiredPermissions())
+	if err != nil {
+		return fmt.Errorf("while reviewing permissions: %s", err)
+	}
+	if len(missing) > 0 {
+		rejectAuth(ctx)
+		return fmt.Errorf("missing permission to %s in namespace %s", joinPermissions(missing), namespace)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Plan is a set of changes computed by plan, to be reviewed and then made by apply.
+type Plan struct {
+	Created           time.Time           `json:"created"`
+	Operator          string              `json:"operator"`
+	Clusters          []string            `json:"clusters"`
+	RotateIfOlderThan string              `json:"rotateIfOlderThan,omitempty"`
+	State             map[string][]string `json:"state"`
+	Changes           []Change            `json:"changes"`
+}
+
+// WritePlan writes a plan as JSON to path.
+func WritePlan(path string, plan *Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, append(data, '\n'), true)
+}
+
+// ReadPlan reads a plan written by WritePlan.
+func ReadPlan(path string) (*Plan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{}
+	err = json.Unmarshal(data, plan)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing %s: %s", path, err)
+	}
+	if len(plan.Clusters) == 0 {
+		return nil, fmt.Errorf("no clusters found in %s", path)
+	}
+	return plan, nil
+}
+
+// plan prints the changes needed to make the clusters match --state-file, and writes them to
+// --plan-file for apply.
+func plan(ctx context.Context) error {
+	if len(config.StateFile) == 0 {
+		return validationError(fmt.Errorf("--state-file must be specified"))
+	}
+	desired, err := ReadStateFile(config.StateFile)
+	if err != nil {
+		return validationError(err)
+	}
+	state, err := desiredState(desired)
+	if err != nil {
+		return validationError(err)
+	}
+
+	changes, err := reconcileChanges(ctx, state)
+	if err != nil {
+		return err
+	}
+	printChanges(os.Stdout, changes)
+
+	if len(config.PlanFile) == 0 {
+		return nil
+	}
+
+	p := &Plan{
+		Created:  time.Now().UTC(),
+		Operator: config.operatorIdentity(),
+		Clusters: config.Clusters,
+		State:    state,
+		Changes:  changes,
+	}
+	if config.RotateIfOlderThan > 0 {
+		p.RotateIfOlderThan = (*dayDuration)(&config.RotateIfOlderThan).String()
+	}
+	err = WritePlan(config.PlanFile, p)
+	if err != nil {
+		return fmt.Errorf("while writing plan: %s", err)
+	}
+	log.Infof("plan written to %s; run apply --plan-file %s to make these changes", config.PlanFile, config.PlanFile)
+	return nil
+}
+
+// validateApply checks the configuration before any changes are made.
+func validateApply() error {
+	if len(config.PlanFile) == 0 {
+		return fmt.Errorf("--plan-file must be specified")
+	}
+	if err := validateGenerate(); err != nil {
+		return err
+	}
+	return validatePerTeamSinks()
+}
+
+// apply makes the changes in --plan-file. The plan is computed again first, and nothing is
+// changed if the clusters have changed since the plan was made, so that only reviewed changes
+// are made.
+func apply(ctx context.Context) error {
+	if err := validateApply(); err != nil {
+		return validationError(err)
+	}
+
+	p, err := ReadPlan(config.PlanFile)
+	if err != nil {
+		return validationError(err)
+	}
+	config.Clusters = p.Clusters
+	config.RotateIfOlderThan = 0
+	if len(p.RotateIfOlderThan) > 0 {
+		err = (*dayDuration)(&config.RotateIfOlderThan).Set(p.RotateIfOlderThan)
+		if err != nil {
+			return validationError(fmt.Errorf("invalid rotateIfOlderThan in %s: %s", config.PlanFile, err))
+		}
+	}
+
+	changes, err := reconcileChanges(ctx, p.State)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(changes, p.Changes) {
+		printChanges(os.Stderr, changes)
+		return fmt.Errorf("the clusters have changed since the plan was made at %s; the changes above are needed now. Run plan again",
+			p.Created.Format(time.RFC3339))
+	}
+
+	printChanges(os.Stderr, changes)
+	if config.DryRun {
+		log.Infof("dry run complete; no changes were made")
+		return nil
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if err := openAuditor(); err != nil {
+		return err
+	}
+	if auditor != nil {
+		defer auditor.Close()
+	}
+	if err := openNotifiers(); err != nil {
+		return err
+	}
+	defer closeNotifiers()
+
+	log.Infof("applying plan made by %s at %s in %s", p.Operator, p.Created.Format(time.RFC3339), strings.Join(p.Clusters, ", "))
+	report := NewReport(ctx)
+	report.Command = "apply"
+	err = applyChanges(ctx, p.State, changes, report)
+	writeReport(report, true)
+	if err != nil {
+		return &ExitError{Code: report.ExitCode(), Err: err}
+	}
+	return nil
+}
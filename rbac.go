@@ -0,0 +1,169 @@
+package main
+
+import (
+	"k8s.io/client-go/kubernetes"
+
+	log "github.com/sirupsen/logrus"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// deployVerbs are the verbs needed to deploy and manage workloads.
+var deployVerbs = []string{"get", "list", "watch", "create", "update", "patch", "delete"}
+
+// DeployRules are the rules granted by the Role created with --create-role.
+var DeployRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{""},
+		Resources: []string{"configmaps", "services"},
+		Verbs:     deployVerbs,
+	},
+	{
+		APIGroups: []string{""},
+		Resources: []string{"pods", "pods/log", "events"},
+		Verbs:     []string{"get", "list", "watch"},
+	},
+	{
+		APIGroups: []string{"apps"},
+		Resources: []string{"deployments", "replicasets", "statefulsets"},
+		Verbs:     deployVerbs,
+	},
+	{
+		APIGroups: []string{"batch"},
+		Resources: []string{"jobs", "cronjobs"},
+		Verbs:     deployVerbs,
+	},
+	{
+		APIGroups: []string{"nais.io"},
+		Resources: []string{"applications"},
+		Verbs:     deployVerbs,
+	},
+}
+
+// RoleObject returns a role granting the given rules.
+func RoleObject(namespace, name string, rules []rbacv1.PolicyRule) *rbacv1.Role {
+	return &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Rules: rules,
+	}
+}
+
+func CreateRole(client kubernetes.Interface, namespace, name string, rules []rbacv1.PolicyRule) (*rbacv1.Role, error) {
+	log.Debugf("attempting to create role '%s' in namespace %s", name, namespace)
+	return client.RbacV1().Roles(namespace).Create(RoleObject(namespace, name, rules))
+}
+
+func DeleteRole(client kubernetes.Interface, namespace, name string) error {
+	log.Debugf("attempting to delete role '%s' in namespace %s", name, namespace)
+	return client.RbacV1().Roles(namespace).Delete(name, &metav1.DeleteOptions{})
+}
+
+// RoleBindingObject returns a role binding of a service account to a role in the service account's
+// namespace. The role kind is either "Role" or "ClusterRole".
+func RoleBindingObject(namespace, name, roleKind, roleName, serviceAccountName string) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     roleKind,
+			Name:     roleName,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      serviceAccountName,
+				Namespace: namespace,
+			},
+		},
+	}
+}
+
+// CreateRoleBinding binds a service account to a role in the service account's namespace.
+// The role kind is either "Role" or "ClusterRole".
+func CreateRoleBinding(client kubernetes.Interface, namespace, name, roleKind, roleName, serviceAccountName string) (*rbacv1.RoleBinding, error) {
+	log.Debugf("attempting to create role binding '%s' to %s '%s' in namespace %s", name, roleKind, roleName, namespace)
+	return client.RbacV1().RoleBindings(namespace).Create(RoleBindingObject(namespace, name, roleKind, roleName, serviceAccountName))
+}
+
+func DeleteRoleBinding(client kubernetes.Interface, namespace, name string) error {
+	log.Debugf("attempting to delete role binding '%s' in namespace %s", name, namespace)
+	return client.RbacV1().RoleBindings(namespace).Delete(name, &metav1.DeleteOptions{})
+}
+
+// ClusterRoleBindingObject returns a binding of a service account to a cluster role in all namespaces.
+func ClusterRoleBindingObject(name, clusterRoleName, namespace, serviceAccountName string) *rbacv1.ClusterRoleBinding {
+	return &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     clusterRoleName,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      serviceAccountName,
+				Namespace: namespace,
+			},
+		},
+	}
+}
+
+// CreateClusterRoleBinding binds a service account to a cluster role in all namespaces.
+func CreateClusterRoleBinding(client kubernetes.Interface, name, clusterRoleName, namespace, serviceAccountName string) (*rbacv1.ClusterRoleBinding, error) {
+	log.Debugf("attempting to create cluster role binding '%s' to ClusterRole '%s'", name, clusterRoleName)
+	return client.RbacV1().ClusterRoleBindings().Create(ClusterRoleBindingObject(name, clusterRoleName, namespace, serviceAccountName))
+}
+
+func DeleteClusterRoleBinding(client kubernetes.Interface, name string) error {
+	log.Debugf("attempting to delete cluster role binding '%s'", name)
+	return client.RbacV1().ClusterRoleBindings().Delete(name, &metav1.DeleteOptions{})
+}
+
+// bindsServiceAccount returns true if any of the subjects is the service account.
+func bindsServiceAccount(subjects []rbacv1.Subject, namespace, serviceAccountName string) bool {
+	for _, subject := range subjects {
+		if subject.Kind == rbacv1.ServiceAccountKind && subject.Name == serviceAccountName && subject.Namespace == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceAccountRoleBindings returns the role bindings in the service account's namespace that bind it.
+func ServiceAccountRoleBindings(client kubernetes.Interface, namespace, serviceAccountName string) ([]rbacv1.RoleBinding, error) {
+	list, err := client.RbacV1().RoleBindings(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	bindings := make([]rbacv1.RoleBinding, 0)
+	for _, binding := range list.Items {
+		if bindsServiceAccount(binding.Subjects, namespace, serviceAccountName) {
+			bindings = append(bindings, binding)
+		}
+	}
+	return bindings, nil
+}
+
+// ServiceAccountClusterRoleBindings returns the cluster role bindings that bind the service account.
+func ServiceAccountClusterRoleBindings(client kubernetes.Interface, namespace, serviceAccountName string) ([]rbacv1.ClusterRoleBinding, error) {
+	list, err := client.RbacV1().ClusterRoleBindings().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	bindings := make([]rbacv1.ClusterRoleBinding, 0)
+	for _, binding := range list.Items {
+		if bindsServiceAccount(binding.Subjects, namespace, serviceAccountName) {
+			bindings = append(bindings, binding)
+		}
+	}
+	return bindings, nil
+}
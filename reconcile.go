@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Operations on team service accounts made when reconciling.
+const (
+	ChangeCreate = "create"
+	ChangeRotate = "rotate"
+	ChangeRevoke = "revoke"
+)
+
+// Change is an operation on a team service account in a single cluster.
+type Change struct {
+	Team      string `json:"team"`
+	Cluster   string `json:"cluster"`
+	Operation string `json:"operation"`
+}
+
+// desiredClusters returns the configured clusters matching the clusters of a team.
+func desiredClusters(team DesiredTeam) ([]string, error) {
+	if len(team.Clusters) == 0 {
+		return config.Clusters, nil
+	}
+	clusters := make([]string, 0)
+	for _, cluster := range config.Clusters {
+		ok, err := matchesAny(team.Clusters, cluster)
+		if err != nil {
+			return nil, fmt.Errorf("team %s: %s", team.Name, err)
+		}
+		if ok {
+			clusters = append(clusters, cluster)
+		}
+	}
+	return clusters, nil
+}
+
+// desiredState returns the clusters each team should have a service account in.
+func desiredState(desired []DesiredTeam) (map[string][]string, error) {
+	state := make(map[string][]string)
+	for _, team := range desired {
+		clusters, err := desiredClusters(team)
+		if err != nil {
+			return nil, err
+		}
+		state[team.Name] = clusters
+	}
+	return state, nil
+}
+
+// reconcileChanges compares the desired state with the team service accounts in the clusters, and
+// returns the changes needed to make them match, ordered by team and cluster. With
+// --rotate-if-older-than, desired service accounts created or rotated longer ago are rotated.
+// Service accounts outside the configured clusters are left alone.
+func reconcileChanges(ctx context.Context, state map[string][]string) ([]Change, error) {
+	found, err := clusterServiceAccounts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("while listing team service users: %s", err)
+	}
+
+	changes := make([]Change, 0)
+	for i, cluster := range config.Clusters {
+		existing := make(map[string]bool)
+		for _, serviceAccount := range found[i] {
+			team := serviceAccountTeam(serviceAccount)
+			existing[team] = true
+			switch {
+			case !contains(state[team], cluster):
+				changes = append(changes, Change{Team: team, Cluster: cluster, Operation: ChangeRevoke})
+			case config.RotateIfOlderThan > 0 && time.Since(LastRotated(serviceAccount)) >= config.RotateIfOlderThan:
+				changes = append(changes, Change{Team: team, Cluster: cluster, Operation: ChangeRotate})
+			}
+		}
+		for team, clusters := range state {
+			if contains(clusters, cluster) && !existing[team] {
+				changes = append(changes, Change{Team: team, Cluster: cluster, Operation: ChangeCreate})
+			}
+		}
+	}
+
+	sort.SliceStable(changes, func(a, b int) bool {
+		if changes[a].Team != changes[b].Team {
+			return changes[a].Team < changes[b].Team
+		}
+		return clusterIndex(changes[a].Cluster) < clusterIndex(changes[b].Cluster)
+	})
+
+	return changes, nil
+}
+
+// clusterIndex returns the position of a cluster in the configured clusters.
+func clusterIndex(cluster string) int {
+	for i, c := range config.Clusters {
+		if c == cluster {
+			return i
+		}
+	}
+	return len(config.Clusters)
+}
+
+// printChanges writes a table of changes.
+func printChanges(out io.Writer, changes []Change) {
+	if len(changes) == 0 {
+		fmt.Fprintf(out, "No changes; the clusters match the desired state.\n")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "TEAM\tCLUSTER\tOPERATION\n")
+	for _, change := range changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", change.Team, change.Cluster, change.Operation)
+	}
+	w.Flush()
+}
+
+// operateTeam runs an operation on a team in the given clusters only, and adds the outcome to report.
+func operateTeam(ctx context.Context, team string, clusters []string, operation string, report *Report) error {
+	c := *configFrom(ctx)
+	c.Clusters = clusters
+	c.Create = operation != ChangeRevoke
+	c.Rotate = operation == ChangeRotate
+	c.Revoke = operation == ChangeRevoke
+	ctx = withConfig(ctx, &c)
+
+	teamReport := &TeamReport{Team: team}
+	report.Teams = append(report.Teams, teamReport)
+
+	log.Infof("team %s: %s in %s", team, operation, strings.Join(clusters, ", "))
+	err := generateTeam(ctx, team, teamReport)
+	if err != nil {
+		log.Errorf("team %s: %s", team, err)
+	}
+	return err
+}
+
+// applyChanges makes the changes, team by team. Revocations are made first. Afterwards, the
+// Kubeconfig file of every changed team that still has access is written again, covering all
+// the clusters it should have access to. Rotations are left to --rotate-if-older-than, so that
+// only the service accounts due are rotated in the same pass.
+func applyChanges(ctx context.Context, state map[string][]string, changes []Change, report *Report) error {
+	teams := make([]string, 0)
+	revoked := make(map[string][]string)
+	rotated := make(map[string]bool)
+	for _, change := range changes {
+		if len(teams) == 0 || teams[len(teams)-1] != change.Team {
+			teams = append(teams, change.Team)
+		}
+		switch change.Operation {
+		case ChangeRevoke:
+			revoked[change.Team] = append(revoked[change.Team], change.Cluster)
+		case ChangeRotate:
+			rotated[change.Team] = true
+		}
+	}
+
+	failures, skipped := 0, 0
+	for _, team := range teams {
+		if ctx.Err() != nil {
+			report.Teams = append(report.Teams, &TeamReport{Team: team, Skipped: true})
+			skipped++
+			continue
+		}
+
+		failed := false
+		if clusters := revoked[team]; len(clusters) > 0 {
+			failed = operateTeam(ctx, team, clusters, ChangeRevoke, report) != nil
+		}
+		if clusters := state[team]; len(clusters) > 0 {
+			operation := ChangeCreate
+			if rotated[team] {
+				operation = ChangeRotate
+			}
+			failed = operateTeam(ctx, team, clusters, operation, report) != nil || failed
+		}
+		if failed {
+			failures++
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d teams failed", failures, len(teams))
+	}
+	if skipped > 0 {
+		return fmt.Errorf("interrupted; %d of %d teams were skipped", skipped, len(teams))
+	}
+	return nil
+}
+
+// validateReconcile checks the configuration before any changes are made.
+func validateReconcile() error {
+	if len(config.StateFile) == 0 {
+		return fmt.Errorf("--state-file must be specified")
+	}
+	if err := validateGenerate(); err != nil {
+		return err
+	}
+	return validatePerTeamSinks()
+}
+
+// reconcile makes the team service accounts in the clusters match --state-file.
+func reconcile(ctx context.Context) error {
+	if err := validateReconcile(); err != nil {
+		return validationError(err)
+	}
+
+	desired, err := ReadStateFile(config.StateFile)
+	if err != nil {
+		return validationError(err)
+	}
+	state, err := desiredState(desired)
+	if err != nil {
+		return validationError(err)
+	}
+
+	changes, err := reconcileChanges(ctx, state)
+	if err != nil {
+		return err
+	}
+	printChanges(os.Stderr, changes)
+	if config.DryRun {
+		log.Infof("dry run complete; no changes were made")
+		return nil
+	}
+
+	teams := make([]string, 0, len(state))
+	for team := range state {
+		teams = append(teams, team)
+	}
+	finishExpiredRotations(ctx, teams)
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if err := openAuditor(); err != nil {
+		return err
+	}
+	if auditor != nil {
+		defer auditor.Close()
+	}
+	if err := openNotifiers(); err != nil {
+		return err
+	}
+	defer closeNotifiers()
+
+	report := NewReport(ctx)
+	report.Command = "reconcile"
+	err = applyChanges(ctx, state, changes, report)
+	writeReport(report, true)
+	if err != nil {
+		return &ExitError{Code: report.ExitCode(), Err: err}
+	}
+	return nil
+}
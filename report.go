@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Actions taken on a team service account in a single cluster.
+const (
+	ActionRetrieved  = "retrieved"
+	ActionCreated    = "created"
+	ActionRotated    = "rotated"
+	ActionRevoked    = "revoked"
+	ActionUnchanged  = "unchanged"
+	ActionNotFound   = "not found"
+	ActionPlanned    = "dry run"
+	ActionSkipped    = "skipped"
+	ActionRolledBack = "rolled back"
+)
+
+// ClusterResult describes the outcome of an operation on a team service account in a single cluster.
+type ClusterResult struct {
+	Cluster        string `json:"cluster"`
+	Namespace      string `json:"namespace"`
+	ServiceAccount string `json:"serviceAccount"`
+	Secret         string `json:"secret,omitempty"`
+	Action         string `json:"action,omitempty"`
+	Error          string `json:"error,omitempty"`
+
+	// AuthFailure is set if the cluster rejected our credentials or permissions.
+	AuthFailure bool `json:"authFailure,omitempty"`
+
+	// rollback describes a two-phase rotation in this cluster, so that --rollback-on-failure can undo it.
+	rollback *Rollback
+}
+
+func NewClusterResult(team, cluster string) *ClusterResult {
+	return &ClusterResult{
+		Cluster:        cluster,
+		Namespace:      config.serviceAccountNamespace(cluster),
+		ServiceAccount: ServiceAccountName(team),
+	}
+}
+
+// TeamReport describes the outcome of an operation on a single team.
+type TeamReport struct {
+	Team     string           `json:"team"`
+	Error    string           `json:"error,omitempty"`
+	Clusters []*ClusterResult `json:"clusters"`
+
+	// Skipped is set if the team was not processed, because the run was halted or interrupted.
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// Report is a machine readable description of a teamconfig run, written with --report.
+type Report struct {
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Command  string        `json:"command"`
+	DryRun   bool          `json:"dryRun"`
+	Success  bool          `json:"success"`
+	Teams    []*TeamReport `json:"teams"`
+}
+
+// NewReport starts a report of the operation running in ctx.
+func NewReport(ctx context.Context) *Report {
+	c := configFrom(ctx)
+	command := c.auditAction()
+	if len(command) == 0 {
+		command = "get"
+	}
+	return &Report{
+		Started: time.Now().UTC(),
+		Command: command,
+		DryRun:  c.DryRun,
+		Teams:   make([]*TeamReport, 0),
+	}
+}
+
+// Finish records when the run finished, and whether all teams were processed and succeeded.
+func (r *Report) Finish() {
+	r.Finished = time.Now().UTC()
+	r.Success = true
+	for _, team := range r.Teams {
+		if len(team.Error) > 0 || team.Skipped {
+			r.Success = false
+		}
+	}
+}
+
+// Write finishes the report, and writes it to path as JSON.
+func (r *Report) Write(path string) error {
+	r.Finish()
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(path, append(data, '\n'), true)
+}
+
+// details returns a short description of a cluster result for the summary table.
+func (c *ClusterResult) details() string {
+	switch {
+	case len(c.Error) > 0:
+		return c.Error
+	case len(c.Secret) > 0:
+		return fmt.Sprintf("%s/%s, secret %s", c.Namespace, c.ServiceAccount, c.Secret)
+	default:
+		return fmt.Sprintf("%s/%s", c.Namespace, c.ServiceAccount)
+	}
+}
+
+// result returns whether the operation in a cluster succeeded, for the summary table.
+func (c *ClusterResult) result() string {
+	switch {
+	case len(c.Error) > 0:
+		return "error"
+	case c.Action == ActionSkipped:
+		return "skipped"
+	case c.Action == ActionRolledBack:
+		return "rolled back"
+	default:
+		return "ok"
+	}
+}
+
+// PrintSummary writes a table of the outcome in each cluster. If teams is true, the team
+// of each row is included. Errors that are not specific to a cluster, such as failures
+// to write the Kubeconfig file, are shown on a separate row.
+func (r *Report) PrintSummary(out io.Writer, teams bool) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+
+	row := func(team string, columns ...interface{}) {
+		if teams {
+			fmt.Fprintf(w, "%s\t", team)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", columns...)
+	}
+
+	fmt.Fprintln(w)
+	row("TEAM", "CLUSTER", "ACTION", "RESULT", "DETAILS")
+	for _, team := range r.Teams {
+		failed := false
+		for _, c := range team.Clusters {
+			action := c.Action
+			if len(action) == 0 {
+				action = "-"
+			}
+			row(team.Team, c.Cluster, action, c.result(), c.details())
+			failed = failed || len(c.Error) > 0
+		}
+		switch {
+		case team.Skipped:
+			row(team.Team, "-", ActionSkipped, "skipped", "not processed")
+		case len(team.Error) > 0 && !failed:
+			row(team.Team, "-", "-", "error", team.Error)
+		}
+	}
+
+	w.Flush()
+}
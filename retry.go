@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxRetryInterval caps the exponential backoff between retries.
+const maxRetryInterval = 10 * time.Second
+
+// retryRoundTripper retries Kubernetes API requests that fail with transient errors,
+// such as timeouts, throttling and server errors, using exponential backoff.
+type retryRoundTripper struct {
+	retries  int
+	interval time.Duration
+	next     http.RoundTripper
+}
+
+// idempotent returns true if repeating the request has the same effect as sending it once.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// transientResponse returns true if the response indicates a transient failure. Creating
+// objects is only retried if the server did not process the request.
+func transientResponse(req *http.Request, resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent(req)
+	}
+	return false
+}
+
+// transientError returns true if a request failed because of a network error that might go away.
+func transientError(req *http.Request, err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	netErr, ok := err.(net.Error)
+	if !ok {
+		return false
+	}
+	if netErr.Timeout() {
+		return idempotent(req)
+	}
+	// Connections that could not be established never reached the server.
+	if opErr, ok := netErr.(*net.OpError); ok && opErr.Op == "dial" {
+		return true
+	}
+	return idempotent(req)
+}
+
+// retryAfter returns the delay requested by the server in a Retry-After header, if any.
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (rt *retryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	interval := rt.interval
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		resp, err := rt.next.RoundTrip(req)
+
+		var reason string
+		delay := interval
+		switch {
+		case err != nil && transientError(req, err):
+			reason = err.Error()
+		case err == nil && transientResponse(req, resp):
+			reason = resp.Status
+			if after := retryAfter(resp); after > delay {
+				delay = after
+			}
+		default:
+			return resp, err
+		}
+
+		if attempt >= rt.retries || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		log.Debugf("%s %s failed with %s; retrying in %s (%d of %d)", req.Method, req.URL.Path, reason, delay, attempt+1, rt.retries)
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+
+		interval *= 2
+		if interval > maxRetryInterval {
+			interval = maxRetryInterval
+		}
+	}
+}
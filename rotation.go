@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Annotations set on service accounts rotated with --two-phase.
+const (
+	// TokenSecretAnnotation names the token secret issued by the last two-phase rotation.
+	// It is used instead of the secret referenced by the service account.
+	TokenSecretAnnotation = "teamconfig.nais.io/token-secret"
+
+	// PreviousTokensAnnotation lists the token secrets replaced by two-phase rotations that are
+	// still valid, as a JSON list of PreviousToken.
+	PreviousTokensAnnotation = "teamconfig.nais.io/previous-tokens"
+)
+
+// PreviousToken is a token secret replaced by a two-phase rotation, which is deleted by finish-rotation.
+// With --grace-period, ExpiresAt is when the grace period ends. The token stays valid until the next
+// run of finish-rotation --expired-only, reconcile or rotate-daemon after that time.
+type PreviousToken struct {
+	Secret     string     `json:"secret"`
+	ReplacedAt time.Time  `json:"replacedAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+// expired returns true if the grace period of the previous token has ended.
+func (t PreviousToken) expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// previousTokens returns the token secrets waiting to be deleted by finish-rotation, oldest first.
+func previousTokens(serviceAccount v1.ServiceAccount) ([]PreviousToken, error) {
+	data := serviceAccount.Annotations[PreviousTokensAnnotation]
+	if len(data) == 0 {
+		return nil, nil
+	}
+	tokens := make([]PreviousToken, 0)
+	err := json.Unmarshal([]byte(data), &tokens)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing %s annotation: %s", PreviousTokensAnnotation, err)
+	}
+	return tokens, nil
+}
+
+// encodePreviousTokens returns a list of previous tokens as an annotation value.
+func encodePreviousTokens(tokens []PreviousToken) string {
+	if len(tokens) == 0 {
+		return "[]"
+	}
+	data, _ := json.Marshal(tokens)
+	return string(data)
+}
+
+// rotationAnnotations are the service account annotations changed by a two-phase rotation.
+var rotationAnnotations = []string{
+	TokenSecretAnnotation,
+	PreviousTokensAnnotation,
+	RotatedAtAnnotation,
+	HistoryAnnotation,
+}
+
+// Rollback describes a two-phase rotation in a single cluster, so that it can be undone
+// or completed with --rollback-on-failure.
+type Rollback struct {
+	// Secret is the token secret issued by the rotation.
+	Secret string
+
+	// Previous is the token secret replaced by the rotation, if any.
+	Previous string
+
+	// Annotations are the values of rotationAnnotations before the rotation.
+	Annotations map[string]string
+
+	// Absent are the rotationAnnotations that the service account did not have before the rotation.
+	Absent []string
+}
+
+// restore returns the annotations to set and remove to undo the changes to some of rotationAnnotations.
+func (r *Rollback) restore(annotations ...string) (map[string]string, []string) {
+	restored := make(map[string]string)
+	removed := make([]string, 0)
+	for _, annotation := range annotations {
+		if value, ok := r.Annotations[annotation]; ok {
+			restored[annotation] = value
+		} else if contains(r.Absent, annotation) {
+			removed = append(removed, annotation)
+		}
+	}
+	return restored, removed
+}
+
+// rotateTwoPhase issues a new token secret for an existing service account, without invalidating
+// the current one. The current secret is recorded as a previous token, to be deleted by
+// finish-rotation once the team has switched to the new token, or when --grace-period has passed.
+// If the service account does not exist, nothing is done and nil is returned.
+func rotateTwoPhase(ctx context.Context, cluster string, client kubernetes.Interface, namespace, serviceAccountName, team string) (*Rollback, error) {
+	config := configFrom(ctx)
+	serviceAccount, err := ServiceAccount(client, namespace, serviceAccountName)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("while retrieving service account: %s", err)
+	}
+
+	tokens, err := previousTokens(*serviceAccount)
+	if err != nil {
+		return nil, err
+	}
+	rollback := &Rollback{Annotations: make(map[string]string)}
+	for _, annotation := range rotationAnnotations {
+		if value, ok := serviceAccount.Annotations[annotation]; ok {
+			rollback.Annotations[annotation] = value
+		} else {
+			rollback.Absent = append(rollback.Absent, annotation)
+		}
+	}
+	history, err := serviceAccountHistory(*serviceAccount)
+	if err != nil {
+		log.Warnf("%s: discarding rotation history of service account '%s': %s", cluster, serviceAccountName, err)
+	}
+
+	// A service account without a token secret has nothing to keep valid.
+	previous, err := ServiceAccountSecret(client, *serviceAccount)
+	if err != nil {
+		log.Debugf("%s: no current token secret for service account '%s': %s", cluster, serviceAccountName, err)
+		previous = nil
+	}
+
+	secret, err := CreateTokenSecret(client, namespace, serviceAccountName, team)
+	if err != nil {
+		return nil, fmt.Errorf("while creating token secret: %s", err)
+	}
+	rollback.Secret = secret.Name
+	_, err = WaitForTokenSecret(ctx, client, namespace, secret.Name, config.TokenTimeout)
+	if err != nil {
+		if deleteErr := DeleteTokenSecret(client, namespace, secret.Name); deleteErr != nil {
+			log.Warnf("%s: while deleting unused token secret '%s': %s", cluster, secret.Name, deleteErr)
+		}
+		return nil, fmt.Errorf("while retrieving secret token: %s", err)
+	}
+
+	now := time.Now().UTC()
+	if previous != nil {
+		token := PreviousToken{Secret: previous.Name, ReplacedAt: now}
+		if config.GracePeriod > 0 {
+			expires := now.Add(config.GracePeriod)
+			token.ExpiresAt = &expires
+		}
+		tokens = append(tokens, token)
+		rollback.Previous = previous.Name
+	}
+	entry := HistoryEntry{
+		Time:     now,
+		Operator: config.operatorIdentity(),
+		Action:   ActionRotated,
+	}
+	err = AnnotateServiceAccount(client, namespace, serviceAccountName, map[string]string{
+		TokenSecretAnnotation:    secret.Name,
+		PreviousTokensAnnotation: encodePreviousTokens(tokens),
+		RotatedAtAnnotation:      now.Format(time.RFC3339),
+		HistoryAnnotation:        appendHistory(history, entry),
+	})
+	if err != nil {
+		if deleteErr := DeleteTokenSecret(client, namespace, secret.Name); deleteErr != nil {
+			log.Warnf("%s: while deleting unused token secret '%s': %s", cluster, secret.Name, deleteErr)
+		}
+		return nil, fmt.Errorf("while recording rotation: %s", err)
+	}
+
+	return rollback, nil
+}
+
+// rollBackRotation undoes a two-phase rotation, so that the service account uses its previous
+// token secret again, and deletes the token secret issued by the rotation.
+func rollBackRotation(client kubernetes.Interface, namespace, serviceAccountName string, rollback *Rollback) error {
+	restored, removed := rollback.restore(rotationAnnotations...)
+	err := PatchServiceAccountAnnotations(client, namespace, serviceAccountName, restored, removed)
+	if err != nil {
+		return fmt.Errorf("while restoring service account: %s", err)
+	}
+	err = DeleteTokenSecret(client, namespace, rollback.Secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("while deleting new token secret: %s", err)
+	}
+	return nil
+}
+
+// completeRotation invalidates the token replaced by a two-phase rotation right away, as if the
+// service account had been recreated.
+func completeRotation(client kubernetes.Interface, namespace, serviceAccountName string, rollback *Rollback) error {
+	if len(rollback.Previous) == 0 {
+		return nil
+	}
+	err := ReplaceServiceAccountSecret(client, namespace, serviceAccountName, rollback.Previous, rollback.Secret)
+	if err != nil {
+		return fmt.Errorf("while replacing previous token secret: %s", err)
+	}
+	err = DeleteTokenSecret(client, namespace, rollback.Previous)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("while deleting previous token secret: %s", err)
+	}
+	restored, removed := rollback.restore(PreviousTokensAnnotation)
+	return PatchServiceAccountAnnotations(client, namespace, serviceAccountName, restored, removed)
+}
+
+// forRotatedClusters calls fn for every cluster in which a team was rotated with a rollback.
+func forRotatedClusters(ctx context.Context, report *TeamReport, fn func(ctx context.Context, client kubernetes.Interface, result *ClusterResult) error) {
+	indices := make([]int, 0)
+	for i, result := range report.Clusters {
+		if result != nil && result.rollback != nil {
+			indices = append(indices, i)
+		}
+	}
+	forClusters(ctx, indices, func(ctx context.Context, i int, cluster string) error {
+		_, client, err := clusterClient(ctx, cluster)
+		if err != nil {
+			return err
+		}
+		return fn(ctx, client, report.Clusters[i])
+	})
+}
+
+// rollBackRotations undoes the rotations of a team that failed in some of its clusters, so that
+// the clusters where it succeeded keep using the previous token. Clusters that cannot be rolled
+// back are reported with an error.
+func rollBackRotations(ctx context.Context, report *TeamReport) {
+	forRotatedClusters(ctx, report, func(ctx context.Context, client kubernetes.Interface, result *ClusterResult) error {
+		err := rollBackRotation(client, result.Namespace, result.ServiceAccount, result.rollback)
+		if err != nil {
+			result.Error = fmt.Sprintf("while rolling back: %s", err)
+			return err
+		}
+		log.Infof("%s: rolled back rotation of service account '%s'; the previous token is still valid", result.Cluster, result.ServiceAccount)
+		result.Action = ActionRolledBack
+		result.Secret = result.rollback.Previous
+		recordEvent(ctx, result.Cluster, client, result.Namespace, result.ServiceAccount, result)
+		return nil
+	})
+}
+
+// completeRotations invalidates the previous tokens of a team that was rotated in all of its
+// clusters. Tokens that cannot be invalidated are left for finish-rotation.
+func completeRotations(ctx context.Context, report *TeamReport) {
+	forRotatedClusters(ctx, report, func(ctx context.Context, client kubernetes.Interface, result *ClusterResult) error {
+		err := completeRotation(client, result.Namespace, result.ServiceAccount, result.rollback)
+		if err != nil {
+			log.Warnf("%s: %s; run finish-rotation to invalidate the previous token", result.Cluster, err)
+		}
+		return nil
+	})
+}
+
+// finishClusterRotation deletes the previous tokens of the team service account in a single
+// cluster, and returns how many were deleted. With --expired-only, tokens still in their grace
+// period, or rotated without one, are kept.
+func finishClusterRotation(ctx context.Context, cluster string, client kubernetes.Interface, namespace, serviceAccountName string) (int, error) {
+	config := configFrom(ctx)
+	serviceAccount, err := ServiceAccount(client, namespace, serviceAccountName)
+	if errors.IsNotFound(err) {
+		log.Infof("%s: service account '%s' not found; nothing to finish", cluster, serviceAccountName)
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("while retrieving service account: %s", err)
+	}
+
+	tokens, err := previousTokens(*serviceAccount)
+	if err != nil {
+		return 0, err
+	}
+	if len(tokens) == 0 {
+		log.Infof("%s: no previous tokens for service account '%s'", cluster, serviceAccountName)
+		return 0, nil
+	}
+
+	now := time.Now()
+	remaining := make([]PreviousToken, 0)
+	deleted, failed := 0, 0
+	for _, token := range tokens {
+		if config.ExpiredOnly && !token.expired(now) {
+			log.Debugf("%s: previous token secret '%s' is still in its grace period", cluster, token.Secret)
+			remaining = append(remaining, token)
+			continue
+		}
+		if config.DryRun {
+			log.Infof("%s: [dry run] would delete previous token secret '%s', replaced %s", cluster, token.Secret, formatTime(token.ReplacedAt))
+			continue
+		}
+		err = ReplaceServiceAccountSecret(client, namespace, serviceAccountName, token.Secret, serviceAccount.Annotations[TokenSecretAnnotation])
+		if err == nil {
+			err = DeleteTokenSecret(client, namespace, token.Secret)
+		}
+		if err != nil && !errors.IsNotFound(err) {
+			log.Errorf("%s: while deleting previous token secret '%s': %s", cluster, token.Secret, err)
+			remaining = append(remaining, token)
+			failed++
+			continue
+		}
+		log.Infof("%s: deleted previous token secret '%s'", cluster, token.Secret)
+		deleted++
+	}
+
+	if deleted > 0 {
+		err = AnnotateServiceAccount(client, namespace, serviceAccountName, map[string]string{
+			PreviousTokensAnnotation: encodePreviousTokens(remaining),
+		})
+		if err != nil {
+			return deleted, fmt.Errorf("while recording deleted tokens: %s", err)
+		}
+	}
+	if failed > 0 {
+		return deleted, fmt.Errorf("%d previous tokens could not be deleted", failed)
+	}
+
+	return deleted, nil
+}
+
+// recordPreviousTokensRevoked records an event on a service account whose previous tokens were deleted.
+func recordPreviousTokensRevoked(ctx context.Context, cluster string, client kubernetes.Interface, namespace, serviceAccountName string) {
+	message := fmt.Sprintf("Previous tokens revoked by %s using teamconfig", configFrom(ctx).operatorIdentity())
+	if err := RecordServiceAccountEvent(client, namespace, serviceAccountName, "PreviousTokensRevoked", message); err != nil {
+		log.Warnf("%s: while recording event: %s", cluster, err)
+	}
+}
+
+// hasExpiredTokens returns true if the team service account has previous tokens whose grace period has ended.
+func hasExpiredTokens(client kubernetes.Interface, namespace, serviceAccountName string, now time.Time) (bool, error) {
+	serviceAccount, err := ServiceAccount(client, namespace, serviceAccountName)
+	if errors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("while retrieving service account: %s", err)
+	}
+	tokens, err := previousTokens(*serviceAccount)
+	if err != nil {
+		return false, err
+	}
+	for _, token := range tokens {
+		if token.expired(now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// finishExpiredRotations invalidates the previous tokens of teams whose --grace-period has ended,
+// as finish-rotation --expired-only does, so that they expire even if it is never scheduled. It
+// is run by reconcile and rotate-daemon. Errors are logged, and do not fail the caller.
+func finishExpiredRotations(ctx context.Context, teams []string) {
+	c := *configFrom(ctx)
+	c.ExpiredOnly = true
+	ctx = withConfig(ctx, &c)
+
+	forEachCluster(ctx, func(ctx context.Context, i int, cluster string) error {
+		_, client, err := clusterClient(ctx, cluster)
+		if err != nil {
+			return err
+		}
+		namespace := c.serviceAccountNamespace(cluster)
+
+		now := time.Now()
+		for _, team := range teams {
+			serviceAccountName := ServiceAccountName(team)
+			expired, err := hasExpiredTokens(client, namespace, serviceAccountName, now)
+			if err != nil {
+				log.Warnf("%s: team %s: while checking for expired tokens: %s", cluster, team, err)
+				continue
+			}
+			if !expired {
+				continue
+			}
+			deleted, err := finishClusterRotation(ctx, cluster, client, namespace, serviceAccountName)
+			if deleted > 0 {
+				recordPreviousTokensRevoked(ctx, cluster, client, namespace, serviceAccountName)
+			}
+			if err != nil {
+				log.Warnf("%s: team %s: while invalidating expired tokens: %s", cluster, team, err)
+			}
+		}
+		return nil
+	})
+}
+
+// finishRotation invalidates the tokens replaced by rotate --two-phase in all clusters.
+func finishRotation(ctx context.Context) error {
+	config := configFrom(ctx)
+	serviceAccountName := ServiceAccountName(config.Team)
+
+	err := forEachCluster(ctx, func(ctx context.Context, i int, cluster string) error {
+		_, client, err := clusterClient(ctx, cluster)
+		if err != nil {
+			return err
+		}
+		namespace := config.serviceAccountNamespace(cluster)
+
+		if !config.DryRun && !config.SkipPermissionCheck {
+			err = checkPermissions(ctx, client, namespace)
+			if err != nil {
+				return err
+			}
+		}
+
+		deleted, err := finishClusterRotation(ctx, cluster, client, namespace, serviceAccountName)
+		if deleted > 0 {
+			recordPreviousTokensRevoked(ctx, cluster, client, namespace, serviceAccountName)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("exiting due to errors")
+	}
+
+	if config.DryRun {
+		log.Infof("dry run complete; no changes were made")
+	}
+	return nil
+}
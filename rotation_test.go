@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPreviousTokens(t *testing.T) {
+	replaced := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	expires := replaced.Add(24 * time.Hour)
+
+	for _, test := range []struct {
+		name   string
+		tokens []PreviousToken
+		encode string
+	}{
+		{"none", nil, "[]"},
+		{"empty", []PreviousToken{}, "[]"},
+		{"without expiry", []PreviousToken{
+			{Secret: "serviceuser-aura-token-abcde", ReplacedAt: replaced},
+		}, `[{"secret":"serviceuser-aura-token-abcde","replacedAt":"2018-10-01T12:00:00Z"}]`},
+		{"with expiry", []PreviousToken{
+			{Secret: "serviceuser-aura-token-abcde", ReplacedAt: replaced, ExpiresAt: &expires},
+			{Secret: "serviceuser-aura-token-fghij", ReplacedAt: expires},
+		}, `[{"secret":"serviceuser-aura-token-abcde","replacedAt":"2018-10-01T12:00:00Z","expiresAt":"2018-10-02T12:00:00Z"},` +
+			`{"secret":"serviceuser-aura-token-fghij","replacedAt":"2018-10-02T12:00:00Z"}]`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			encoded := encodePreviousTokens(test.tokens)
+			if encoded != test.encode {
+				t.Errorf("encodePreviousTokens() = %s, want %s", encoded, test.encode)
+			}
+
+			serviceAccount := v1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{PreviousTokensAnnotation: encoded}},
+			}
+			decoded, err := previousTokens(serviceAccount)
+			if err != nil {
+				t.Fatalf("previousTokens() error: %s", err)
+			}
+			if len(decoded) != len(test.tokens) {
+				t.Fatalf("previousTokens() = %v, want %v", decoded, test.tokens)
+			}
+			for i := range decoded {
+				if decoded[i].Secret != test.tokens[i].Secret || !decoded[i].ReplacedAt.Equal(test.tokens[i].ReplacedAt) {
+					t.Errorf("previousTokens()[%d] = %v, want %v", i, decoded[i], test.tokens[i])
+				}
+				if (decoded[i].ExpiresAt == nil) != (test.tokens[i].ExpiresAt == nil) ||
+					decoded[i].ExpiresAt != nil && !decoded[i].ExpiresAt.Equal(*test.tokens[i].ExpiresAt) {
+					t.Errorf("previousTokens()[%d].ExpiresAt = %v, want %v", i, decoded[i].ExpiresAt, test.tokens[i].ExpiresAt)
+				}
+			}
+		})
+	}
+}
+
+func TestPreviousTokensAnnotation(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		value string
+		count int
+		err   bool
+	}{
+		{"missing", "", 0, false},
+		{"empty list", "[]", 0, false},
+		{"one token", `[{"secret":"serviceuser-aura-token-abcde","replacedAt":"2018-10-01T12:00:00Z"}]`, 1, false},
+		{"invalid json", "serviceuser-aura-token-abcde", 0, true},
+		{"not a list", `{"secret":"serviceuser-aura-token-abcde"}`, 0, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			serviceAccount := v1.ServiceAccount{}
+			if len(test.value) > 0 {
+				serviceAccount.Annotations = map[string]string{PreviousTokensAnnotation: test.value}
+			}
+			tokens, err := previousTokens(serviceAccount)
+			if (err != nil) != test.err {
+				t.Fatalf("previousTokens() error = %v, want error %t", err, test.err)
+			}
+			if len(tokens) != test.count {
+				t.Errorf("previousTokens() returned %d tokens, want %d", len(tokens), test.count)
+			}
+		})
+	}
+}
+
+func TestPreviousTokenExpired(t *testing.T) {
+	now := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	before := now.Add(-time.Second)
+	after := now.Add(time.Second)
+
+	for _, test := range []struct {
+		name    string
+		expires *time.Time
+		want    bool
+	}{
+		{"no expiry", nil, false},
+		{"expired", &before, true},
+		{"expires now", &now, true},
+		{"not yet expired", &after, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			token := PreviousToken{Secret: "serviceuser-aura-token-abcde", ExpiresAt: test.expires}
+			if got := token.expired(now); got != test.want {
+				t.Errorf("expired() = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+// rotatedObjects returns a team service account rotated with --two-phase, using the token secret
+// serviceuser-aura-token-new and keeping the previous tokens valid, and all of its token secrets.
+func rotatedObjects(tokens []PreviousToken) []runtime.Object {
+	objects := teamObjects("default", "aura", "serviceuser-aura-token-new")
+	serviceAccount := objects[0].(*v1.ServiceAccount)
+	serviceAccount.Annotations = map[string]string{
+		TokenSecretAnnotation:    "serviceuser-aura-token-new",
+		PreviousTokensAnnotation: encodePreviousTokens(tokens),
+	}
+	for _, token := range tokens {
+		serviceAccount.Secrets = append(serviceAccount.Secrets, v1.ObjectReference{Name: token.Secret})
+		objects = append(objects, teamObjects("default", "aura", token.Secret)[1])
+	}
+	return objects
+}
+
+// secretExists returns true if the fake clientset holds a secret.
+func secretExists(t *testing.T, client *fake.Clientset, name string) bool {
+	t.Helper()
+	_, err := client.CoreV1().Secrets("default").Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return true
+}
+
+func TestRotateTwoPhase(t *testing.T) {
+	c := *config
+	c.TokenTimeout = 5 * time.Second
+	ctx := withConfig(context.Background(), &c)
+
+	t.Run("service account not found", func(t *testing.T) {
+		rollback, err := rotateTwoPhase(ctx, "dev-fss", fakeClient(), "default", "serviceuser-aura", "aura")
+		if rollback != nil || err != nil {
+			t.Errorf("rotateTwoPhase() = %+v, %v, want nil, nil", rollback, err)
+		}
+	})
+
+	t.Run("rotated twice", func(t *testing.T) {
+		client := fakeClient(teamObjects("default", "aura", "serviceuser-aura-token-abcde")...)
+
+		first, err := rotateTwoPhase(ctx, "dev-fss", client, "default", "serviceuser-aura", "aura")
+		if err != nil {
+			t.Fatalf("rotateTwoPhase() error: %s", err)
+		}
+		if !strings.HasPrefix(first.Secret, "serviceuser-aura-token-") || first.Previous != "serviceuser-aura-token-abcde" {
+			t.Errorf("rotateTwoPhase() rollback = %+v", first)
+		}
+		if len(first.Annotations) != 0 || len(first.Absent) != len(rotationAnnotations) {
+			t.Errorf("rotateTwoPhase() recorded annotations %v, absent %v", first.Annotations, first.Absent)
+		}
+
+		second, err := rotateTwoPhase(ctx, "dev-fss", client, "default", "serviceuser-aura", "aura")
+		if err != nil {
+			t.Fatalf("rotateTwoPhase() error: %s", err)
+		}
+		if second.Previous != first.Secret || second.Annotations[TokenSecretAnnotation] != first.Secret {
+			t.Errorf("second rotation rollback = %+v, want previous %s", second, first.Secret)
+		}
+
+		serviceAccount, err := ServiceAccount(client, "default", "serviceuser-aura")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if secret := serviceAccount.Annotations[TokenSecretAnnotation]; secret != second.Secret {
+			t.Errorf("service account uses token secret %s, want %s", secret, second.Secret)
+		}
+		tokens, err := previousTokens(*serviceAccount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tokens) != 2 || tokens[0].Secret != "serviceuser-aura-token-abcde" || tokens[1].Secret != first.Secret {
+			t.Errorf("previous tokens = %v, want serviceuser-aura-token-abcde and %s", tokens, first.Secret)
+		}
+		for _, secret := range []string{"serviceuser-aura-token-abcde", first.Secret, second.Secret} {
+			if !secretExists(t, client, secret) {
+				t.Errorf("token secret %s was deleted", secret)
+			}
+		}
+	})
+}
+
+func TestFinishClusterRotation(t *testing.T) {
+	now := time.Now().UTC()
+	expired := now.Add(-time.Hour)
+	valid := now.Add(time.Hour)
+	tokens := []PreviousToken{
+		{Secret: "serviceuser-aura-token-abcde", ReplacedAt: now.Add(-2 * time.Hour), ExpiresAt: &expired},
+		{Secret: "serviceuser-aura-token-fghij", ReplacedAt: now.Add(-time.Minute), ExpiresAt: &valid},
+		{Secret: "serviceuser-aura-token-klmno", ReplacedAt: now},
+	}
+
+	for _, test := range []struct {
+		name        string
+		expiredOnly bool
+		dryRun      bool
+		deleted     []string
+		kept        []string
+	}{
+		{
+			name:    "all",
+			deleted: []string{"serviceuser-aura-token-abcde", "serviceuser-aura-token-fghij", "serviceuser-aura-token-klmno"},
+		},
+		{
+			name:        "expired only",
+			expiredOnly: true,
+			deleted:     []string{"serviceuser-aura-token-abcde"},
+			kept:        []string{"serviceuser-aura-token-fghij", "serviceuser-aura-token-klmno"},
+		},
+		{
+			name:   "dry run",
+			dryRun: true,
+			kept:   []string{"serviceuser-aura-token-abcde", "serviceuser-aura-token-fghij", "serviceuser-aura-token-klmno"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := *config
+			c.ExpiredOnly = test.expiredOnly
+			c.DryRun = test.dryRun
+			ctx := withConfig(context.Background(), &c)
+			client := fakeClient(rotatedObjects(tokens)...)
+
+			deleted, err := finishClusterRotation(ctx, "dev-fss", client, "default", "serviceuser-aura")
+			if err != nil {
+				t.Fatalf("finishClusterRotation() error: %s", err)
+			}
+			if deleted != len(test.deleted) {
+				t.Errorf("finishClusterRotation() deleted %d tokens, want %d", deleted, len(test.deleted))
+			}
+
+			serviceAccount, err := ServiceAccount(client, "default", "serviceuser-aura")
+			if err != nil {
+				t.Fatal(err)
+			}
+			remaining, err := previousTokens(*serviceAccount)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(remaining) != len(test.kept) {
+				t.Errorf("previous tokens = %v, want %v", remaining, test.kept)
+			}
+			referenced := make([]string, 0)
+			for _, ref := range serviceAccount.Secrets {
+				referenced = append(referenced, ref.Name)
+			}
+			for _, secret := range test.deleted {
+				if secretExists(t, client, secret) {
+					t.Errorf("token secret %s was not deleted", secret)
+				}
+				if contains(referenced, secret) {
+					t.Errorf("service account still references deleted token secret %s", secret)
+				}
+			}
+			for _, secret := range append(test.kept, "serviceuser-aura-token-new") {
+				if !secretExists(t, client, secret) {
+					t.Errorf("token secret %s was deleted", secret)
+				}
+			}
+		})
+	}
+
+	t.Run("service account not found", func(t *testing.T) {
+		deleted, err := finishClusterRotation(context.Background(), "dev-fss", fakeClient(), "default", "serviceuser-aura")
+		if deleted != 0 || err != nil {
+			t.Errorf("finishClusterRotation() = %d, %v, want 0, nil", deleted, err)
+		}
+	})
+}
+
+func TestHasExpiredTokens(t *testing.T) {
+	now := time.Now().UTC()
+	expired := now.Add(-time.Hour)
+	client := fakeClient(rotatedObjects([]PreviousToken{
+		{Secret: "serviceuser-aura-token-abcde", ReplacedAt: now.Add(-2 * time.Hour), ExpiresAt: &expired},
+		{Secret: "serviceuser-aura-token-fghij", ReplacedAt: now},
+	})...)
+
+	for _, test := range []struct {
+		team     string
+		expected bool
+	}{
+		{team: "aura", expected: true},
+		{team: "bris", expected: false},
+	} {
+		actual, err := hasExpiredTokens(client, "default", ServiceAccountName(test.team), now)
+		if err != nil {
+			t.Fatalf("hasExpiredTokens() error: %s", err)
+		}
+		if actual != test.expected {
+			t.Errorf("hasExpiredTokens(%s) = %t, want %t", test.team, actual, test.expected)
+		}
+	}
+}
+
+func TestRollbackRestore(t *testing.T) {
+	rollback := &Rollback{
+		Annotations: map[string]string{
+			TokenSecretAnnotation:    "serviceuser-aura-token-abcde",
+			PreviousTokensAnnotation: "[]",
+		},
+		Absent: []string{RotatedAtAnnotation, HistoryAnnotation},
+	}
+
+	for _, test := range []struct {
+		name        string
+		annotations []string
+		restored    map[string]string
+		removed     []string
+	}{
+		{"nothing", nil, map[string]string{}, []string{}},
+		{"present", []string{TokenSecretAnnotation}, map[string]string{
+			TokenSecretAnnotation: "serviceuser-aura-token-abcde",
+		}, []string{}},
+		{"absent", []string{RotatedAtAnnotation}, map[string]string{}, []string{RotatedAtAnnotation}},
+		{"all", rotationAnnotations, map[string]string{
+			TokenSecretAnnotation:    "serviceuser-aura-token-abcde",
+			PreviousTokensAnnotation: "[]",
+		}, []string{RotatedAtAnnotation, HistoryAnnotation}},
+		{"unknown", []string{ManagedChangeAnnotation}, map[string]string{}, []string{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			restored, removed := rollback.restore(test.annotations...)
+			if !reflect.DeepEqual(restored, test.restored) {
+				t.Errorf("restore() restored = %v, want %v", restored, test.restored)
+			}
+			if !reflect.DeepEqual(removed, test.removed) {
+				t.Errorf("restore() removed = %v, want %v", removed, test.removed)
+			}
+		})
+	}
+}
+
+// rotateForRollback rotates the team service account with --two-phase, after annotating it with its
+// token secret, and returns the fake clientset and the rollback of the rotation.
+func rotateForRollback(t *testing.T) (*fake.Clientset, *Rollback) {
+	t.Helper()
+	c := *config
+	c.TokenTimeout = 5 * time.Second
+	ctx := withConfig(context.Background(), &c)
+
+	objects := teamObjects("default", "aura", "serviceuser-aura-token-abcde")
+	objects[0].(*v1.ServiceAccount).Annotations = map[string]string{TokenSecretAnnotation: "serviceuser-aura-token-abcde"}
+	client := fakeClient(objects...)
+	rollback, err := rotateTwoPhase(ctx, "dev-fss", client, "default", "serviceuser-aura", "aura")
+	if err != nil {
+		t.Fatalf("rotateTwoPhase() error: %s", err)
+	}
+	return client, rollback
+}
+
+func TestRollBackRotation(t *testing.T) {
+	client, rollback := rotateForRollback(t)
+
+	err := rollBackRotation(client, "default", "serviceuser-aura", rollback)
+	if err != nil {
+		t.Fatalf("rollBackRotation() error: %s", err)
+	}
+
+	serviceAccount, err := ServiceAccount(client, "default", "serviceuser-aura")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret := serviceAccount.Annotations[TokenSecretAnnotation]; secret != "serviceuser-aura-token-abcde" {
+		t.Errorf("service account uses token secret %s, want serviceuser-aura-token-abcde", secret)
+	}
+	for _, annotation := range []string{PreviousTokensAnnotation, RotatedAtAnnotation, HistoryAnnotation} {
+		if value, ok := serviceAccount.Annotations[annotation]; ok {
+			t.Errorf("annotation %s = %q was not removed", annotation, value)
+		}
+	}
+	if secretExists(t, client, rollback.Secret) {
+		t.Errorf("new token secret %s was not deleted", rollback.Secret)
+	}
+	if !secretExists(t, client, "serviceuser-aura-token-abcde") {
+		t.Errorf("previous token secret was deleted")
+	}
+}
+
+func TestCompleteRotation(t *testing.T) {
+	client, rollback := rotateForRollback(t)
+
+	err := completeRotation(client, "default", "serviceuser-aura", rollback)
+	if err != nil {
+		t.Fatalf("completeRotation() error: %s", err)
+	}
+
+	serviceAccount, err := ServiceAccount(client, "default", "serviceuser-aura")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret := serviceAccount.Annotations[TokenSecretAnnotation]; secret != rollback.Secret {
+		t.Errorf("service account uses token secret %s, want %s", secret, rollback.Secret)
+	}
+	if value, ok := serviceAccount.Annotations[PreviousTokensAnnotation]; ok {
+		t.Errorf("annotation %s = %q was not removed", PreviousTokensAnnotation, value)
+	}
+	referenced := make([]string, 0)
+	for _, ref := range serviceAccount.Secrets {
+		referenced = append(referenced, ref.Name)
+	}
+	if !reflect.DeepEqual(referenced, []string{rollback.Secret}) {
+		t.Errorf("service account references %v, want %s", referenced, rollback.Secret)
+	}
+	if secretExists(t, client, "serviceuser-aura-token-abcde") {
+		t.Errorf("previous token secret was not deleted")
+	}
+	if !secretExists(t, client, rollback.Secret) {
+		t.Errorf("new token secret was deleted")
+	}
+}
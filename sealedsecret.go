@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// SealedSecretConfig configures writing the Kubeconfig file as a Bitnami SealedSecret, which only
+// the sealed-secrets controller in the target cluster can decrypt.
+type SealedSecretConfig struct {
+	Cert      string
+	Namespace string
+	Name      string
+	Key       string
+}
+
+// SealedSecret is a Bitnami SealedSecret in strict scope: it can only be unsealed to a secret
+// with the given name and namespace.
+type SealedSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              SealedSecretSpec `json:"spec"`
+}
+
+type SealedSecretSpec struct {
+	EncryptedData map[string]string    `json:"encryptedData"`
+	Template      SealedSecretTemplate `json:"template"`
+}
+
+type SealedSecretTemplate struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Type              string `json:"type,omitempty"`
+}
+
+// readSealingCert reads the public key of the sealed-secrets controller from a PEM certificate,
+// as fetched with kubeseal --fetch-cert.
+func readSealingCert(path string) (*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing %s: %s", path, err)
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("certificate in %s does not have an RSA public key", path)
+	}
+	return key, nil
+}
+
+// sealValue encrypts a value the way kubeseal does: a random AES-256-GCM session key encrypts the
+// value, and is itself encrypted with RSA-OAEP using the scope of the secret as label.
+func sealValue(key *rsa.PublicKey, value []byte, label string) ([]byte, error) {
+	sessionKey := make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, sessionKey, []byte(label))
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, 2, 2+len(encryptedKey)+len(value)+gcm.Overhead())
+	binary.BigEndian.PutUint16(sealed, uint16(len(encryptedKey)))
+	sealed = append(sealed, encryptedKey...)
+
+	// The session key is only used once, so a zero nonce is safe.
+	nonce := make([]byte, gcm.NonceSize())
+	return gcm.Seal(sealed, nonce, value, nil), nil
+}
+
+// renderSealedSecret wraps the Kubeconfig file of a team in a SealedSecret for --sealed-secret-namespace.
+func renderSealedSecret(team string, kubeconfig []byte, credentials []*Credentials) ([]byte, error) {
+	key, err := readSealingCert(config.SealedSecret.Cert)
+	if err != nil {
+		return nil, err
+	}
+
+	replacer := strings.NewReplacer("{team}", team)
+	namespace := replacer.Replace(config.SealedSecret.Namespace)
+	name := replacer.Replace(config.SealedSecret.Name)
+
+	sealed, err := sealValue(key, kubeconfig, namespace+"/"+name)
+	if err != nil {
+		return nil, fmt.Errorf("while sealing: %s", err)
+	}
+
+	labels := map[string]string{
+		ManagedByLabel: ManagedByValue,
+		TeamLabel:      team,
+	}
+	secret := SealedSecret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "bitnami.com/v1alpha1", Kind: "SealedSecret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: SealedSecretSpec{
+			EncryptedData: map[string]string{
+				config.SealedSecret.Key: base64.StdEncoding.EncodeToString(sealed),
+			},
+			Template: SealedSecretTemplate{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+					Labels:    labels,
+				},
+				Type: "Opaque",
+			},
+		},
+	}
+	return yaml.Marshal(secret)
+}
+
+// validateSealedSecret checks the sealed secret flags before any changes are made.
+func validateSealedSecret() error {
+	if len(config.SealedSecret.Cert) == 0 {
+		return fmt.Errorf("--output-format sealedsecret requires --sealed-secret-cert")
+	}
+	if len(config.SealedSecret.Namespace) == 0 || len(config.SealedSecret.Name) == 0 || len(config.SealedSecret.Key) == 0 {
+		return fmt.Errorf("--sealed-secret-namespace, --sealed-secret-name and --sealed-secret-key must not be empty")
+	}
+	if _, err := readSealingCert(config.SealedSecret.Cert); err != nil {
+		return fmt.Errorf("invalid --sealed-secret-cert: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// unseal decrypts a value sealed with sealValue, the way the sealed-secrets controller does.
+func unseal(t *testing.T, key *rsa.PrivateKey, sealed []byte, label string) ([]byte, error) {
+	t.Helper()
+	if len(sealed) < 2 {
+		t.Fatalf("sealed value is too short: %d bytes", len(sealed))
+	}
+	keyLength := int(binary.BigEndian.Uint16(sealed))
+	if len(sealed) < 2+keyLength {
+		t.Fatalf("sealed value is shorter than its session key: %d bytes", len(sealed))
+	}
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, sealed[2:2+keyLength], []byte(label))
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gcm.Open(nil, make([]byte, gcm.NonceSize()), sealed[2+keyLength:], nil)
+}
+
+// writeSealingCert writes a self-signed certificate for key to a temporary file.
+func writeSealingCert(t *testing.T, key *rsa.PrivateKey) string {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sealed-secret"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "cert.pem")
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSealValue(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name  string
+		value []byte
+	}{
+		{"empty", []byte{}},
+		{"kubeconfig", []byte("apiVersion: v1\nkind: Config\n")},
+		{"binary", bytes.Repeat([]byte{0, 1, 2, 255}, 1024)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			sealed, err := sealValue(&key.PublicKey, test.value, "team-aura/kubeconfig")
+			if err != nil {
+				t.Fatalf("sealValue() error: %s", err)
+			}
+			value, err := unseal(t, key, sealed, "team-aura/kubeconfig")
+			if err != nil {
+				t.Fatalf("unseal error: %s", err)
+			}
+			if !bytes.Equal(value, test.value) {
+				t.Errorf("unsealed %q, want %q", value, test.value)
+			}
+			if _, err := unseal(t, key, sealed, "team-other/kubeconfig"); err == nil {
+				t.Errorf("value could be unsealed with the scope of another secret")
+			}
+		})
+	}
+}
+
+func TestReadSealingCert(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	notCert := filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(notCert, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid.pem")
+	err = ioutil.WriteFile(invalid, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name string
+		path string
+		err  bool
+	}{
+		{"certificate", writeSealingCert(t, key), false},
+		{"missing file", filepath.Join(dir, "missing.pem"), true},
+		{"private key", notCert, true},
+		{"invalid certificate", invalid, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			public, err := readSealingCert(test.path)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readSealingCert() error: %s", err)
+			}
+			if public.N.Cmp(key.PublicKey.N) != 0 || public.E != key.PublicKey.E {
+				t.Errorf("readSealingCert() returned another key")
+			}
+		})
+	}
+}
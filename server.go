@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ClusterStatusReport is the JSON representation of the team service account in a single cluster.
+type ClusterStatusReport struct {
+	Cluster        string     `json:"cluster"`
+	Namespace      string     `json:"namespace"`
+	ServiceAccount string     `json:"serviceAccount"`
+	State          string     `json:"state"`
+	LastRotated    *time.Time `json:"lastRotated,omitempty"`
+	TokenCreated   *time.Time `json:"tokenCreated,omitempty"`
+	TokenExpires   *time.Time `json:"tokenExpires,omitempty"`
+}
+
+// TeamStatusReport is the JSON representation of the status command for a single team.
+type TeamStatusReport struct {
+	Team     string                `json:"team"`
+	Clusters []ClusterStatusReport `json:"clusters"`
+}
+
+// optionalTime returns nil for the zero time, so that it is left out of JSON output.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// serveJSON writes v as the JSON response body.
+func serveJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Errorf("while writing response: %s", err)
+	}
+}
+
+// serveError writes err as a JSON response.
+func serveError(w http.ResponseWriter, code int, err error) {
+	serveJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// teamPath splits a request path of the form /teams/<team>[/<operation>].
+func teamPath(path string) (team, operation string, ok bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "teams" {
+		return "", "", false
+	}
+	if len(parts) == 3 {
+		operation = parts[2]
+	}
+	return parts[1], operation, len(validation.IsDNS1123Label(parts[1])) == 0
+}
+
+// selectClusters returns the given clusters, which must be among the configured clusters.
+func selectClusters(clusters []string) ([]string, error) {
+	configured := make(map[string]bool)
+	for _, cluster := range config.Clusters {
+		configured[cluster] = true
+	}
+	for _, cluster := range clusters {
+		if !configured[cluster] {
+			return nil, fmt.Errorf("unknown cluster '%s'", cluster)
+		}
+	}
+	return clusters, nil
+}
+
+// Server runs team operations requested over HTTP or gRPC. Every operation runs with its own copy of
+// the configuration, but operations that change service accounts still run one at a time, so that
+// two callers never rotate the same team at once.
+type Server struct {
+	// ctx is used for operations instead of the request context, so that a client
+	// disconnecting does not abort a rotation half way through.
+	ctx   context.Context
+	auth  *Authenticator
+	mutex sync.Mutex
+}
+
+func NewServer(ctx context.Context, auth *Authenticator) *Server {
+	return &Server{ctx: ctx, auth: auth}
+}
+
+// operations maps the operations that change team service users to the configuration selecting them.
+var operations = map[string]func(c *Config){
+	OperationCreate: func(c *Config) { c.Create = true },
+	OperationRotate: func(c *Config) { c.Rotate = true },
+	OperationRevoke: func(c *Config) { c.Revoke = true },
+}
+
+// queryClusters returns the clusters given in the clusters query parameter, if any.
+func queryClusters(r *http.Request) []string {
+	clusters := r.URL.Query().Get("clusters")
+	if len(clusters) == 0 {
+		return nil
+	}
+	return strings.Split(clusters, ",")
+}
+
+// configure returns a copy of the global configuration for an operation on team by caller. If
+// clusters are given, the operation is limited to those of the configured clusters.
+func (s *Server) configure(caller *Caller, team string, clusters []string, operation string) (*Config, error) {
+	c := *config
+	c.Team = team
+	if caller != anonymous {
+		c.AuditOperator = caller.Name
+	}
+	if len(clusters) > 0 {
+		selected, err := selectClusters(clusters)
+		if err != nil {
+			return nil, err
+		}
+		c.Clusters = selected
+	}
+	if set, ok := operations[operation]; ok {
+		set(&c)
+	}
+
+	return &c, nil
+}
+
+// run runs a service account operation on a single team, and returns the report. Credentials are
+// written to the configured sinks. The returned error is only set if the team could not be
+// configured; failed operations are described in the report.
+func (s *Server) run(caller *Caller, team string, clusters []string, operation string) (*Report, error) {
+	c, err := s.configure(caller, team, clusters, operation)
+	if err != nil {
+		return nil, err
+	}
+	ctx := withConfig(s.ctx, c)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	report := NewReport(ctx)
+	teamReport := &TeamReport{Team: team}
+	report.Teams = append(report.Teams, teamReport)
+
+	log.Infof("team %s: %s requested by %s", team, report.Command, caller)
+	err = generateTeam(ctx, team, teamReport)
+	if err != nil {
+		log.Errorf("team %s: %s", team, err)
+	}
+	report.Finish()
+
+	return report, nil
+}
+
+// operate runs a service account operation on a single team, and responds with the report.
+// Credentials are never included in the response.
+func (s *Server) operate(w http.ResponseWriter, r *http.Request, caller *Caller, team, operation string) {
+	report, err := s.run(caller, team, queryClusters(r), operation)
+	if err != nil {
+		serveError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	code := http.StatusOK
+	if !report.Success {
+		code = http.StatusInternalServerError
+	}
+	serveJSON(w, code, report)
+}
+
+// status responds with the state of the team service account in each cluster.
+func (s *Server) status(w http.ResponseWriter, r *http.Request, caller *Caller, team string) {
+	c, err := s.configure(caller, team, queryClusters(r), OperationStatus)
+	if err != nil {
+		serveError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	statuses, err := teamStatus(withConfig(r.Context(), c))
+
+	report := TeamStatusReport{
+		Team:     team,
+		Clusters: make([]ClusterStatusReport, len(c.Clusters)),
+	}
+	for i, cluster := range c.Clusters {
+		report.Clusters[i] = ClusterStatusReport{
+			Cluster:        cluster,
+			Namespace:      c.serviceAccountNamespace(cluster),
+			ServiceAccount: ServiceAccountName(team),
+			State:          statuses[i].State,
+			LastRotated:    optionalTime(statuses[i].Created),
+			TokenCreated:   optionalTime(statuses[i].TokenCreated),
+			TokenExpires:   optionalTime(statuses[i].TokenExpires),
+		}
+	}
+
+	code := http.StatusOK
+	if err != nil {
+		code = http.StatusInternalServerError
+	}
+	serveJSON(w, code, report)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	team, operation, ok := teamPath(r.URL.Path)
+	if !ok {
+		serveError(w, http.StatusNotFound, fmt.Errorf("not found: %s", r.URL.Path))
+		return
+	}
+
+	switch {
+	case operation == "" && r.Method == http.MethodPost:
+		operation = OperationCreate
+	case operation == "" && r.Method == http.MethodDelete:
+		operation = OperationRevoke
+	case operation == OperationRotate && r.Method == http.MethodPost:
+	case operation == OperationStatus && r.Method == http.MethodGet:
+	default:
+		serveError(w, http.StatusNotFound, fmt.Errorf("no such operation: %s %s", r.Method, r.URL.Path))
+		return
+	}
+
+	caller, err := s.auth.Authenticate(r.Context(), r.TLS, r.Header.Get("Authorization"))
+	if err != nil {
+		log.Warnf("%s: unauthenticated request: %s", r.RemoteAddr, err)
+		serveError(w, http.StatusUnauthorized, fmt.Errorf("unauthenticated: %s", err))
+		return
+	}
+	err = s.auth.Authorize(caller, team, operation)
+	if err != nil {
+		log.Warnf("%s: %s", caller, err)
+		serveError(w, http.StatusForbidden, err)
+		return
+	}
+
+	if operation == OperationStatus {
+		s.status(w, r, caller, team)
+	} else {
+		s.operate(w, r, caller, team, operation)
+	}
+}
+
+// validateServe checks the configuration before the server starts.
+func validateServe() error {
+	if err := validateGenerate(); err != nil {
+		return err
+	}
+	if err := validateServerAuth(); err != nil {
+		return err
+	}
+	if err := validateSlack(); err != nil {
+		return err
+	}
+
+	return validatePerTeamSinks()
+}
+
+// serve runs the HTTP API until interrupted.
+func serve(ctx context.Context) error {
+	if err := validateServe(); err != nil {
+		return validationError(err)
+	}
+
+	if err := openAuditor(); err != nil {
+		return err
+	}
+	if auditor != nil {
+		defer auditor.Close()
+	}
+	if err := openNotifiers(); err != nil {
+		return err
+	}
+	defer closeNotifiers()
+
+	auth, err := NewAuthenticator(config.ServerAuth)
+	if err != nil {
+		return err
+	}
+	if !auth.Enabled() {
+		log.Warnf("API callers are not authenticated; anyone who can reach the API can manage any team")
+	}
+	tlsConfig, err := serverTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	startDebugServer(ctx)
+
+	apiServer := NewServer(ctx, auth)
+	mux := http.NewServeMux()
+	mux.Handle("/teams/", apiServer)
+	NewHealth().Register(mux)
+	if config.Slack.Enabled {
+		secret, _ := slackSigningSecret()
+		NewSlackHandler(apiServer, secret, config.Slack.Approvers).Register(mux)
+	}
+	server := &http.Server{
+		Addr:      config.ListenAddress,
+		Handler:   mux,
+		TLSConfig: tlsConfig,
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		log.Infof("serving API on %s", config.ListenAddress)
+		if tlsConfig != nil {
+			errs <- server.ListenAndServeTLS("", "")
+		} else {
+			errs <- server.ListenAndServe()
+		}
+	}()
+	if len(config.GRPCAddress) > 0 {
+		go func() {
+			if err := serveGRPC(ctx, apiServer, tlsConfig); err != nil {
+				errs <- fmt.Errorf("gRPC: %s", err)
+			}
+		}()
+	}
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("while serving API: %s", err)
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
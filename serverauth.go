@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ServerAuthConfig configures authentication and authorization of API callers.
+type ServerAuthConfig struct {
+	ClientCAFile      string
+	RequireClientCert bool
+	OIDCIssuerURL     string
+	OIDCAudience      string
+	OIDCUsernameClaim string
+	OIDCGroupsClaim   string
+	PolicyFile        string
+}
+
+// Operations that can be requested over the API, and granted in the policy.
+const (
+	OperationCreate = "create"
+	OperationRotate = "rotate"
+	OperationRevoke = "revoke"
+	OperationStatus = "status"
+)
+
+// Caller is the authenticated identity of an API request.
+type Caller struct {
+	Name   string
+	Groups []string
+}
+
+// anonymous is the caller of requests when authentication is disabled.
+var anonymous = &Caller{Name: "anonymous"}
+
+func (c *Caller) String() string {
+	if len(c.Groups) == 0 {
+		return c.Name
+	}
+	return fmt.Sprintf("%s (%s)", c.Name, strings.Join(c.Groups, ", "))
+}
+
+// PolicyRule grants callers matching any of the users or groups the given operations on the given teams.
+// {team} in user and group names is replaced with the team being operated on, and a team of '*' matches
+// all teams. If no operations are listed, all operations are granted.
+type PolicyRule struct {
+	Users      []string `json:"users,omitempty"`
+	Groups     []string `json:"groups,omitempty"`
+	Teams      []string `json:"teams"`
+	Operations []string `json:"operations,omitempty"`
+}
+
+// Policy is the YAML format of --policy-file. Callers are allowed an operation if any rule grants it.
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// ReadPolicy reads and parses a policy file.
+func ReadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := &Policy{}
+	err = yaml.Unmarshal(data, policy)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing %s: %s", path, err)
+	}
+	if len(policy.Rules) == 0 {
+		return nil, fmt.Errorf("no rules found in %s", path)
+	}
+	return policy, nil
+}
+
+// policyMatches returns true if any of the values matches any of the patterns, after replacing {team}.
+func policyMatches(patterns, values []string, team string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.Replace(pattern, "{team}", team, -1)
+		for _, value := range values {
+			if pattern == "*" || pattern == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Allowed returns true if the caller may perform the operation on team.
+func (p *Policy) Allowed(caller *Caller, team, operation string) bool {
+	for _, rule := range p.Rules {
+		if !policyMatches(rule.Users, []string{caller.Name}, team) && !policyMatches(rule.Groups, caller.Groups, team) {
+			continue
+		}
+		if !policyMatches(rule.Teams, []string{team}, team) {
+			continue
+		}
+		if len(rule.Operations) > 0 && !policyMatches(rule.Operations, []string{operation}, team) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// OIDCVerifier validates ID tokens issued by an OpenID Connect provider. Only RS256 signatures are supported.
+type OIDCVerifier struct {
+	IssuerURL     string
+	Audience      string
+	UsernameClaim string
+	GroupsClaim   string
+	Client        *http.Client
+
+	mutex   sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// jsonWebKey is a public key in the key set of the provider.
+type jsonWebKey struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+	Algorithm string `json:"alg"`
+}
+
+// publicKey decodes an RSA public key.
+func (k jsonWebKey) publicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.Modulus)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.Exponent)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// fetchKeys retrieves the signing keys of the provider, using OpenID Connect discovery.
+func (v *OIDCVerifier) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	discovery := struct {
+		JWKSURI string `json:"jwks_uri"`
+	}{}
+	discoveryURL := strings.TrimSuffix(v.IssuerURL, "/") + "/.well-known/openid-configuration"
+	err := jsonRequest(ctx, v.Client, http.MethodGet, discoveryURL, nil, nil, &discovery)
+	if err != nil {
+		return nil, err
+	}
+
+	keySet := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	err = jsonRequest(ctx, v.Client, http.MethodGet, discovery.JWKSURI, nil, nil, &keySet)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range keySet.Keys {
+		if key.KeyType != "RSA" {
+			continue
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("while decoding key %s: %s", key.KeyID, err)
+		}
+		keys[key.KeyID] = publicKey
+	}
+	return keys, nil
+}
+
+// key returns the signing key with the given ID. The key set is fetched again if the key
+// is unknown, as providers rotate their keys, but at most once a minute.
+func (v *OIDCVerifier) key(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if key, ok := v.keys[keyID]; ok {
+		return key, nil
+	}
+	if time.Since(v.fetched) < time.Minute {
+		return nil, fmt.Errorf("unknown signing key '%s'", keyID)
+	}
+
+	keys, err := v.fetchKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("while fetching signing keys: %s", err)
+	}
+	v.keys = keys
+	v.fetched = time.Now()
+
+	if key, ok := v.keys[keyID]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key '%s'", keyID)
+}
+
+// Verify checks the signature, issuer, audience and expiry of an ID token, and returns the caller it identifies.
+func (v *OIDCVerifier) Verify(ctx context.Context, token string) (*Caller, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("token is not a JSON Web Token")
+	}
+
+	header := struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}{}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err == nil {
+		err = json.Unmarshal(data, &header)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while decoding token header: %s", err)
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("unsupported signing algorithm '%s'", header.Algorithm)
+	}
+
+	key, err := v.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("while decoding token signature: %s", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+
+	claims, err := ParseTokenClaims(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Issuer != v.IssuerURL {
+		return nil, fmt.Errorf("token issued by '%s', not '%s'", claims.Issuer, v.IssuerURL)
+	}
+	audienceFound := false
+	for _, aud := range claims.Audiences {
+		audienceFound = audienceFound || aud == v.Audience
+	}
+	if !audienceFound {
+		return nil, fmt.Errorf("token not issued for audience '%s'", v.Audience)
+	}
+	now := time.Now()
+	if claims.ExpiresAt().IsZero() || now.After(claims.ExpiresAt()) {
+		return nil, fmt.Errorf("token has expired")
+	}
+	if now.Before(claims.ValidFrom()) {
+		return nil, fmt.Errorf("token is not valid before %s", formatTime(claims.ValidFrom()))
+	}
+
+	// the username and groups claims are configurable, so they are decoded separately
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("while decoding token claims: %s", err)
+	}
+	all := make(map[string]interface{})
+	err = json.Unmarshal(payload, &all)
+	if err != nil {
+		return nil, fmt.Errorf("while decoding token claims: %s", err)
+	}
+
+	name, _ := all[v.UsernameClaim].(string)
+	if len(name) == 0 {
+		return nil, fmt.Errorf("token has no '%s' claim", v.UsernameClaim)
+	}
+	caller := &Caller{Name: name}
+	groups, _ := all[v.GroupsClaim].([]interface{})
+	for _, group := range groups {
+		if group, ok := group.(string); ok {
+			caller.Groups = append(caller.Groups, group)
+		}
+	}
+
+	return caller, nil
+}
+
+// callerFromCertificate identifies a caller by the common name of its client certificate,
+// and its groups by the organizations, like the Kubernetes API server does.
+func callerFromCertificate(certificate *x509.Certificate) *Caller {
+	return &Caller{
+		Name:   certificate.Subject.CommonName,
+		Groups: certificate.Subject.Organization,
+	}
+}
+
+// Authenticator authenticates API callers with client certificates or OpenID Connect ID tokens,
+// and authorizes them according to a policy.
+type Authenticator struct {
+	ClientCertificates bool
+	OIDC               *OIDCVerifier
+	Policy             *Policy
+}
+
+// Enabled returns true if callers must authenticate.
+func (a *Authenticator) Enabled() bool {
+	return a.ClientCertificates || a.OIDC != nil
+}
+
+// Authenticate identifies the caller of a request from its verified client certificate, if any,
+// or else the bearer token in its authorization header.
+func (a *Authenticator) Authenticate(ctx context.Context, state *tls.ConnectionState, authorization string) (*Caller, error) {
+	if !a.Enabled() {
+		return anonymous, nil
+	}
+
+	if a.ClientCertificates && state != nil && len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+		return callerFromCertificate(state.VerifiedChains[0][0]), nil
+	}
+
+	if a.OIDC != nil && strings.HasPrefix(authorization, "Bearer ") {
+		return a.OIDC.Verify(ctx, strings.TrimPrefix(authorization, "Bearer "))
+	}
+
+	return nil, fmt.Errorf("no client certificate or bearer token")
+}
+
+// Authorize returns an error if the caller may not perform the operation on team.
+func (a *Authenticator) Authorize(caller *Caller, team, operation string) error {
+	if a.Policy == nil || a.Policy.Allowed(caller, team, operation) {
+		return nil
+	}
+	return fmt.Errorf("%s may not %s team %s", caller.Name, operation, team)
+}
+
+// NewAuthenticator sets up authentication and authorization of API callers as configured.
+func NewAuthenticator(auth ServerAuthConfig) (*Authenticator, error) {
+	a := &Authenticator{
+		ClientCertificates: len(auth.ClientCAFile) > 0,
+	}
+
+	if len(auth.OIDCIssuerURL) > 0 {
+		a.OIDC = &OIDCVerifier{
+			IssuerURL:     auth.OIDCIssuerURL,
+			Audience:      auth.OIDCAudience,
+			UsernameClaim: auth.OIDCUsernameClaim,
+			GroupsClaim:   auth.OIDCGroupsClaim,
+			Client:        &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+
+	if len(auth.PolicyFile) > 0 {
+		policy, err := ReadPolicy(auth.PolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("while reading policy: %s", err)
+		}
+		a.Policy = policy
+	}
+
+	return a, nil
+}
+
+// serverTLSConfig returns the TLS configuration of the API, or nil if it is served without TLS.
+// Client certificates are verified if given, but not required, so that health checks and
+// callers using bearer tokens can still connect.
+func serverTLSConfig() (*tls.Config, error) {
+	if len(config.TLSCertFile) == 0 {
+		return nil, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("while loading TLS certificate: %s", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+	}
+
+	if len(config.ServerAuth.ClientCAFile) > 0 {
+		data, err := ioutil.ReadFile(config.ServerAuth.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("while reading client CA: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", config.ServerAuth.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if config.ServerAuth.RequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// validateServerAuth checks the authentication flags of the API.
+func validateServerAuth() error {
+	auth := config.ServerAuth
+	if (len(config.TLSCertFile) > 0) != (len(config.TLSKeyFile) > 0) {
+		return fmt.Errorf("--tls-cert-file and --tls-key-file must be given together")
+	}
+	if len(auth.ClientCAFile) > 0 && len(config.TLSCertFile) == 0 {
+		return fmt.Errorf("--client-ca-file requires --tls-cert-file and --tls-key-file")
+	}
+	if auth.RequireClientCert && len(auth.ClientCAFile) == 0 {
+		return fmt.Errorf("--require-client-cert requires --client-ca-file")
+	}
+	if auth.RequireClientCert && config.Slack.Enabled {
+		return fmt.Errorf("--require-client-cert cannot be used with --slack-commands, since Slack does not present client certificates")
+	}
+	if len(auth.OIDCIssuerURL) > 0 && len(auth.OIDCAudience) == 0 {
+		return fmt.Errorf("--api-oidc-issuer-url requires --api-oidc-audience")
+	}
+	authenticated := len(auth.ClientCAFile) > 0 || len(auth.OIDCIssuerURL) > 0
+	if authenticated && len(auth.PolicyFile) == 0 {
+		return fmt.Errorf("--policy-file is required when callers are authenticated")
+	}
+	if !authenticated && len(auth.PolicyFile) > 0 {
+		return fmt.Errorf("--policy-file requires --client-ca-file or --api-oidc-issuer-url")
+	}
+	return nil
+}
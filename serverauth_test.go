@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPolicyAllowed(t *testing.T) {
+	policy := &Policy{Rules: []PolicyRule{
+		{Groups: []string{"platform"}, Teams: []string{"*"}},
+		{Groups: []string{"{team}-leads"}, Teams: []string{"*"}, Operations: []string{OperationRotate, OperationStatus}},
+		{Users: []string{"alice"}, Teams: []string{"aura"}, Operations: []string{OperationStatus}},
+		{Users: []string{"slack:U0123ABCD"}, Teams: []string{"aura", "tbd"}, Operations: []string{OperationRotate}},
+	}}
+
+	for _, test := range []struct {
+		name      string
+		caller    *Caller
+		team      string
+		operation string
+		expected  bool
+	}{
+		{"platform may do anything", &Caller{Name: "bob", Groups: []string{"platform"}}, "aura", OperationRevoke, true},
+		{"team leads may rotate their team", &Caller{Name: "carol", Groups: []string{"aura-leads"}}, "aura", OperationRotate, true},
+		{"team leads may not revoke their team", &Caller{Name: "carol", Groups: []string{"aura-leads"}}, "aura", OperationRevoke, false},
+		{"team leads may not rotate other teams", &Caller{Name: "carol", Groups: []string{"aura-leads"}}, "tbd", OperationRotate, false},
+		{"user granted status", &Caller{Name: "alice"}, "aura", OperationStatus, true},
+		{"user not granted rotate", &Caller{Name: "alice"}, "aura", OperationRotate, false},
+		{"user not granted other teams", &Caller{Name: "alice"}, "tbd", OperationStatus, false},
+		{"Slack user", &Caller{Name: "slack:U0123ABCD"}, "tbd", OperationRotate, true},
+		{"other Slack user", &Caller{Name: "slack:U0456EFGH"}, "tbd", OperationRotate, false},
+		{"unknown caller", &Caller{Name: "mallory", Groups: []string{"tbd-leads"}}, "aura", OperationStatus, false},
+		{"no groups", &Caller{Name: "mallory"}, "aura", OperationStatus, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := policy.Allowed(test.caller, test.team, test.operation); got != test.expected {
+				t.Errorf("got %t, expected %t", got, test.expected)
+			}
+		})
+	}
+}
+
+// signToken returns an RS256 signed JSON Web Token with the given header and claims.
+func signToken(t *testing.T, key *rsa.PrivateKey, header, claims map[string]interface{}) string {
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCVerifierVerify(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := &OIDCVerifier{
+		IssuerURL:     "https://issuer.example.com",
+		Audience:      "teamconfig",
+		UsernameClaim: "email",
+		GroupsClaim:   "groups",
+		keys:          map[string]*rsa.PublicKey{"key": &key.PublicKey},
+		fetched:       time.Now(),
+	}
+
+	now := time.Now()
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":    verifier.IssuerURL,
+			"aud":    verifier.Audience,
+			"exp":    now.Add(time.Hour).Unix(),
+			"nbf":    now.Add(-time.Minute).Unix(),
+			"email":  "alice@example.com",
+			"groups": []string{"platform"},
+		}
+	}
+	header := map[string]interface{}{"alg": "RS256", "kid": "key"}
+
+	for _, test := range []struct {
+		name   string
+		key    *rsa.PrivateKey
+		header map[string]interface{}
+		change func(claims map[string]interface{})
+		err    bool
+	}{
+		{name: "valid", change: func(claims map[string]interface{}) {}},
+		{name: "without nbf", change: func(claims map[string]interface{}) { delete(claims, "nbf") }},
+		{name: "audience list", change: func(claims map[string]interface{}) { claims["aud"] = []string{"other", "teamconfig"} }},
+		{name: "expired", change: func(claims map[string]interface{}) { claims["exp"] = now.Add(-time.Minute).Unix() }, err: true},
+		{name: "without exp", change: func(claims map[string]interface{}) { delete(claims, "exp") }, err: true},
+		{name: "not yet valid", change: func(claims map[string]interface{}) { claims["nbf"] = now.Add(time.Hour).Unix() }, err: true},
+		{name: "other issuer", change: func(claims map[string]interface{}) { claims["iss"] = "https://other.example.com" }, err: true},
+		{name: "other audience", change: func(claims map[string]interface{}) { claims["aud"] = "other" }, err: true},
+		{name: "no username", change: func(claims map[string]interface{}) { delete(claims, "email") }, err: true},
+		{name: "other key", key: other, change: func(claims map[string]interface{}) {}, err: true},
+		{name: "unknown key", header: map[string]interface{}{"alg": "RS256", "kid": "unknown"}, change: func(claims map[string]interface{}) {}, err: true},
+		{name: "unsupported algorithm", header: map[string]interface{}{"alg": "none", "kid": "key"}, change: func(claims map[string]interface{}) {}, err: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			signingKey, tokenHeader, claims := key, header, valid()
+			if test.key != nil {
+				signingKey = test.key
+			}
+			if test.header != nil {
+				tokenHeader = test.header
+			}
+			test.change(claims)
+
+			caller, err := verifier.Verify(context.Background(), signToken(t, signingKey, tokenHeader, claims))
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected an error, got caller %s", caller)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if caller.Name != "alice@example.com" || len(caller.Groups) != 1 || caller.Groups[0] != "platform" {
+				t.Errorf("got caller %s", caller)
+			}
+		})
+	}
+}
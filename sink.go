@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Sink is a destination for generated credentials.
+type Sink interface {
+	// Write stores the credentials of a team. The Kubeconfig file contains all clusters,
+	// and is not encrypted; credentials holds the token of each cluster, or nil for
+	// clusters that failed.
+	Write(ctx context.Context, team string, kubeconfig []byte, credentials []*Credentials) error
+}
+
+// fileSink is implemented by sinks that write local files. The files are checked
+// before any changes are made, so that rotated tokens are not lost.
+type fileSink interface {
+	Paths(ctx context.Context, team string) []string
+}
+
+// SinkType describes a kind of sink that can be selected with --sink.
+type SinkType struct {
+	Name string
+
+	// Flags names the flags configuring the sink, used in error messages.
+	Flags string
+
+	// Configured returns true if the flags for this sink are given.
+	Configured func() bool
+
+	// PerTeam returns true if the destination is different for every team.
+	PerTeam func() bool
+
+	// Location describes where a team can fetch its credentials, for notifications.
+	// It is nil for sinks that only the operator can read, such as local files.
+	Location func(team string) string
+
+	New func() Sink
+}
+
+func always() bool { return true }
+func never() bool  { return false }
+
+// hasTeam returns true if any of the given destinations contain the {team} placeholder.
+func hasTeam(destinations ...string) bool {
+	return strings.Contains(strings.Join(destinations, " "), "{team}")
+}
+
+// SinkTypes lists all sinks in the order they are written to. Sinks writing to
+// standard output come last, so that log messages from other sinks are not mixed in.
+var SinkTypes = []SinkType{
+	{
+		Name:       "file",
+		Flags:      "--output or --output-dir",
+		Configured: func() bool { return len(config.Output) > 0 || len(config.OutputDir) > 0 },
+		PerTeam:    func() bool { return len(config.OutputDir) > 0 },
+		New:        func() Sink { return &FileSink{} },
+	},
+	{
+		Name:       "split",
+		Flags:      "--split-output",
+		Configured: func() bool { return len(config.SplitOutput) > 0 },
+		PerTeam:    always,
+		New:        func() Sink { return &SplitFileSink{} },
+	},
+	{
+		Name:       "merge",
+		Flags:      "--merge",
+		Configured: func() bool { return len(config.Merge) > 0 },
+		PerTeam:    func() bool { return hasTeam(config.Merge) },
+		New:        func() Sink { return &MergeSink{} },
+	},
+	{
+		Name:       "bundle",
+		Flags:      "--bundle",
+		Configured: func() bool { return len(config.Bundle) > 0 },
+		PerTeam:    func() bool { return hasTeam(config.Bundle) },
+		New:        func() Sink { return &BundleSink{} },
+	},
+	{
+		Name:       "vault",
+		Flags:      "--vault-path",
+		Configured: func() bool { return len(config.VaultPath) > 0 },
+		PerTeam:    func() bool { return hasTeam(config.VaultPath) },
+		Location:   func(team string) string { return fmt.Sprintf("Vault at %s", vaultPath(team)) },
+		New:        func() Sink { return SinkFunc(writeVault) },
+	},
+	{
+		Name:       "secret",
+		Flags:      "--secret-cluster",
+		Configured: func() bool { return len(config.KubernetesSecret.Cluster) > 0 },
+		PerTeam:    func() bool { return hasTeam(config.KubernetesSecret.Name, config.KubernetesSecret.Namespace) },
+		Location: func(team string) string {
+			namespace := strings.Replace(config.KubernetesSecret.Namespace, "{team}", team, -1)
+			return fmt.Sprintf("secret %s in namespace %s in cluster %s", kubernetesSecretName(team), namespace, config.KubernetesSecret.Cluster)
+		},
+		New: func() Sink { return kubeconfigSink(writeKubernetesSecret) },
+	},
+	{
+		Name:       "github",
+		Flags:      "--github-repo or --github-org",
+		Configured: func() bool { return len(config.GitHub.Repo) > 0 || len(config.GitHub.Org) > 0 },
+		PerTeam:    func() bool { return hasTeam(config.GitHub.Repo) },
+		Location: func(team string) string {
+			if len(config.GitHub.Repo) > 0 {
+				return fmt.Sprintf("GitHub Actions secret %s in repository %s", config.GitHub.SecretName, strings.Replace(config.GitHub.Repo, "{team}", team, -1))
+			}
+			return fmt.Sprintf("GitHub Actions secret %s in organization %s", config.GitHub.SecretName, config.GitHub.Org)
+		},
+		New: func() Sink { return SinkFunc(writeGitHub) },
+	},
+	{
+		Name:       "gitlab",
+		Flags:      "--gitlab-project or --gitlab-group",
+		Configured: func() bool { return len(config.GitLab.Project) > 0 || len(config.GitLab.Group) > 0 },
+		PerTeam:    func() bool { return hasTeam(config.GitLab.Project, config.GitLab.Group) },
+		Location: func(team string) string {
+			if len(config.GitLab.Project) > 0 {
+				return fmt.Sprintf("GitLab CI/CD variable %s in project %s", config.GitLab.VariableName, strings.Replace(config.GitLab.Project, "{team}", team, -1))
+			}
+			return fmt.Sprintf("GitLab CI/CD variable %s in group %s", config.GitLab.VariableName, strings.Replace(config.GitLab.Group, "{team}", team, -1))
+		},
+		New: func() Sink { return SinkFunc(writeGitLab) },
+	},
+	{
+		Name:       "azure",
+		Flags:      "--azure-key-vault",
+		Configured: func() bool { return len(config.Azure.KeyVault) > 0 },
+		PerTeam:    func() bool { return hasTeam(config.Azure.SecretName) },
+		Location: func(team string) string {
+			return fmt.Sprintf("Azure Key Vault %s, secret %s", config.Azure.KeyVault, azureSecretName(team))
+		},
+		New: func() Sink { return SinkFunc(writeAzureKeyVault) },
+	},
+	{
+		Name:       "gcp",
+		Flags:      "--gcp-secret",
+		Configured: func() bool { return len(config.GCPSecret) > 0 },
+		PerTeam:    func() bool { return hasTeam(config.GCPSecret) },
+		Location: func(team string) string {
+			return fmt.Sprintf("Google Secret Manager secret %s", strings.Replace(config.GCPSecret, "{team}", team, -1))
+		},
+		New: func() Sink { return SinkFunc(writeSecretManager) },
+	},
+	{
+		Name:       "aws",
+		Flags:      "--aws-secret",
+		Configured: func() bool { return len(config.AWS.Secret) > 0 },
+		PerTeam:    func() bool { return hasTeam(config.AWS.Secret) },
+		Location: func(team string) string {
+			return fmt.Sprintf("AWS Secrets Manager secret %s", strings.Replace(config.AWS.Secret, "{team}", team, -1))
+		},
+		New: func() Sink { return kubeconfigSink(writeSecretsManager) },
+	},
+	{
+		Name:       "externalsecret",
+		Flags:      "--external-secret-dir",
+		Configured: func() bool { return len(config.ExternalSecret.Dir) > 0 },
+		PerTeam:    always,
+		New:        func() Sink { return &ExternalSecretSink{} },
+	},
+	{
+		Name:       "exec",
+		Flags:      "--sink-exec",
+		Configured: func() bool { return len(config.SinkExec) > 0 },
+		PerTeam:    always,
+		New:        func() Sink { return &ExecSink{Commands: config.SinkExec} },
+	},
+	{
+		Name:       "stdout",
+		Configured: always,
+		PerTeam:    never,
+		New:        func() Sink { return &StdoutSink{} },
+	},
+}
+
+// credentialLocations describes where a team can fetch the credentials written by the selected sinks.
+func credentialLocations(team string) []string {
+	sinkTypes, err := selectedSinkTypes()
+	if err != nil {
+		return nil
+	}
+	locations := make([]string, 0)
+	for _, sinkType := range sinkTypes {
+		if sinkType.Location != nil {
+			locations = append(locations, sinkType.Location(team))
+		}
+	}
+	return locations
+}
+
+// sinkTypeNames returns the names of all sinks, for usage and error messages.
+func sinkTypeNames() []string {
+	names := make([]string, len(SinkTypes))
+	for i, sinkType := range SinkTypes {
+		names[i] = sinkType.Name
+	}
+	return names
+}
+
+func knownSink(name string) bool {
+	for _, sinkType := range SinkTypes {
+		if sinkType.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// selectedSinkTypes returns the sinks given with --sink. If none are given, all sinks whose
+// flags are configured are used, and standard output only if there are no others.
+func selectedSinkTypes() ([]SinkType, error) {
+	selected := make([]SinkType, 0)
+
+	if len(config.Sinks) == 0 {
+		for _, sinkType := range SinkTypes {
+			if sinkType.Name != "stdout" && sinkType.Configured() {
+				selected = append(selected, sinkType)
+			}
+		}
+		if len(selected) == 0 {
+			selected = append(selected, SinkTypes[len(SinkTypes)-1])
+		}
+		return selected, nil
+	}
+
+	for _, sinkType := range SinkTypes {
+		for _, name := range config.Sinks {
+			if name != sinkType.Name {
+				continue
+			}
+			if !sinkType.Configured() {
+				return nil, fmt.Errorf("--sink %s requires %s", name, sinkType.Flags)
+			}
+			selected = append(selected, sinkType)
+			break
+		}
+	}
+
+	if len(selected) < len(config.Sinks) {
+		for _, name := range config.Sinks {
+			if !knownSink(name) {
+				return nil, fmt.Errorf("unknown sink '%s'; must be one of %s", name, strings.Join(sinkTypeNames(), ", "))
+			}
+		}
+	}
+
+	return selected, nil
+}
+
+// validateSinks checks the selected sinks before any changes are made.
+func validateSinks() error {
+	sinkTypes, err := selectedSinkTypes()
+	if err != nil {
+		return err
+	}
+
+	encrypted := len(config.EncryptGPG) > 0 || len(config.EncryptAge) > 0 || config.EncryptSOPS
+	for _, sinkType := range sinkTypes {
+		if sinkType.Name == "merge" && encrypted {
+			return fmt.Errorf("--merge writes a plain Kubeconfig file, and cannot be used with --encrypt-gpg, --encrypt-age or --encrypt-sops")
+		}
+	}
+
+	if len(config.TeamsFile) == 0 || config.Revoke || config.DryRun {
+		return nil
+	}
+	for _, sinkType := range sinkTypes {
+		if !sinkType.PerTeam() {
+			return fmt.Errorf("sink %s would write every team to the same destination; with --teams-file, use --output-dir, --split-output, or a destination containing {team}", sinkType.Name)
+		}
+	}
+
+	return nil
+}
+
+// validatePerTeamSinks checks that the selected sinks write each team to a different destination,
+// for commands operating on many teams that are not known in advance.
+func validatePerTeamSinks() error {
+	sinkTypes, err := selectedSinkTypes()
+	if err != nil {
+		return err
+	}
+	for _, sinkType := range sinkTypes {
+		if !sinkType.PerTeam() {
+			return fmt.Errorf("sink %s would write every team to the same destination; use --output-dir, --split-output, or a destination containing {team}", sinkType.Name)
+		}
+	}
+	return nil
+}
+
+// SinkFunc adapts a function to the Sink interface.
+type SinkFunc func(ctx context.Context, team string, kubeconfig []byte, credentials []*Credentials) error
+
+func (f SinkFunc) Write(ctx context.Context, team string, kubeconfig []byte, credentials []*Credentials) error {
+	return f(ctx, team, kubeconfig, credentials)
+}
+
+// kubeconfigSink adapts a function storing only the Kubeconfig file to the Sink interface.
+func kubeconfigSink(write func(ctx context.Context, team string, kubeconfig []byte) error) Sink {
+	return SinkFunc(func(ctx context.Context, team string, kubeconfig []byte, _ []*Credentials) error {
+		return write(ctx, team, kubeconfig)
+	})
+}
+
+// StdoutSink writes the Kubeconfig file in --output-format, encrypted if configured, to standard output.
+type StdoutSink struct{}
+
+func (s *StdoutSink) Write(ctx context.Context, team string, kubeconfig []byte, credentials []*Credentials) error {
+	output, err := renderOutput(team, kubeconfig, credentials)
+	if err != nil {
+		return err
+	}
+	return writeOutput("", output)
+}
+
+// FileSink writes the Kubeconfig file in --output-format, encrypted if configured, to --output or --output-dir.
+type FileSink struct{}
+
+func (s *FileSink) Paths(ctx context.Context, team string) []string {
+	return []string{outputPath(team)}
+}
+
+func (s *FileSink) Write(ctx context.Context, team string, kubeconfig []byte, credentials []*Credentials) error {
+	output, err := renderOutput(team, kubeconfig, credentials)
+	if err != nil {
+		return err
+	}
+	return writeOutput(outputPath(team), output)
+}
+
+// MergeSink merges the clusters, users and contexts of the Kubeconfig file into an existing
+// Kubeconfig file at --merge, replacing entries with the same names and keeping all others.
+// The current context is only set if the file has none.
+type MergeSink struct{}
+
+func (s *MergeSink) Write(ctx context.Context, team string, kubeconfig []byte, credentials []*Credentials) error {
+	generated, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	path := mergePath(team)
+	existing, err := clientcmd.LoadFromFile(path)
+	if os.IsNotExist(err) {
+		existing = clientcmdapi.NewConfig()
+	} else if err != nil {
+		return fmt.Errorf("while reading %s: %s", path, err)
+	}
+
+	for name, cluster := range generated.Clusters {
+		existing.Clusters[name] = cluster
+	}
+	for name, authInfo := range generated.AuthInfos {
+		existing.AuthInfos[name] = authInfo
+	}
+	for name, context := range generated.Contexts {
+		existing.Contexts[name] = context
+	}
+	if len(existing.CurrentContext) == 0 {
+		existing.CurrentContext = generated.CurrentContext
+	}
+
+	output, err := clientcmd.Write(*existing)
+	if err != nil {
+		return err
+	}
+	err = WriteFileAtomic(path, output, true)
+	if err != nil {
+		return err
+	}
+	log.Infof("%d contexts merged into %s", len(generated.Contexts), path)
+	return nil
+}
+
+// mergePath returns the path of the Kubeconfig file to merge the credentials of a team into.
+func mergePath(team string) string {
+	return strings.Replace(config.Merge, "{team}", team, -1)
+}
+
+// SplitFileSink writes one self-contained Kubeconfig file per cluster to --split-output.
+type SplitFileSink struct{}
+
+func (s *SplitFileSink) Paths(ctx context.Context, team string) []string {
+	clusters := configFrom(ctx).Clusters
+	paths := make([]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		paths = append(paths, splitOutputPath(team, cluster))
+	}
+	return paths
+}
+
+func (s *SplitFileSink) Write(ctx context.Context, team string, kubeconfig []byte, credentials []*Credentials) error {
+	config := configFrom(ctx)
+	for _, c := range credentials {
+		if c == nil {
+			continue
+		}
+		output, err := renderKubeconfig(ctx, team, []*Credentials{c}, false)
+		if err == nil {
+			output, err = renderOutput(team, output, []*Credentials{c})
+		}
+		if err != nil {
+			return fmt.Errorf("while generating output for %s: %s", c.Cluster, err)
+		}
+
+		path := splitOutputPath(team, c.Cluster)
+		err = WriteFileAtomic(path, output, config.Force)
+		if err != nil {
+			return fmt.Errorf("while writing output for %s: %s", c.Cluster, err)
+		}
+		log.Infof("%s: configuration file written to %s", c.Cluster, path)
+	}
+
+	return nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Limits on Slack requests and approvals.
+const (
+	// SlackMaxClockSkew is how old a signed Slack request may be, to prevent replays.
+	SlackMaxClockSkew = 5 * time.Minute
+
+	// SlackApprovalTimeout is how long a requested rotation waits for approval.
+	SlackApprovalTimeout = 15 * time.Minute
+)
+
+// SlackCommandConfig configures the Slack slash command handler of the API.
+type SlackCommandConfig struct {
+	Enabled   bool
+	Approvers []string
+}
+
+// slackCaller returns the caller for a Slack user, which is matched against --policy-file. The
+// user ID is used rather than the user name, which members can change themselves.
+func slackCaller(userID string) *Caller {
+	return &Caller{Name: "slack:" + userID}
+}
+
+// slackApproval is a rotation requested with a slash command, waiting for approval.
+type slackApproval struct {
+	Team        string
+	RequestedBy string
+	Requested   time.Time
+}
+
+// slackMessage is a message posted in response to a slash command or button click.
+type slackMessage struct {
+	ResponseType    string            `json:"response_type,omitempty"`
+	ReplaceOriginal bool              `json:"replace_original,omitempty"`
+	Text            string            `json:"text"`
+	Attachments     []slackAttachment `json:"attachments,omitempty"`
+}
+
+type slackAttachment struct {
+	Text       string        `json:"text"`
+	CallbackID string        `json:"callback_id,omitempty"`
+	Actions    []slackAction `json:"actions,omitempty"`
+}
+
+type slackAction struct {
+	Name  string `json:"name"`
+	Text  string `json:"text,omitempty"`
+	Type  string `json:"type,omitempty"`
+	Value string `json:"value"`
+	Style string `json:"style,omitempty"`
+}
+
+// slackInteraction is the payload sent by Slack when a button is clicked.
+type slackInteraction struct {
+	CallbackID string        `json:"callback_id"`
+	Actions    []slackAction `json:"actions"`
+	User       struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"user"`
+	ResponseURL string `json:"response_url"`
+}
+
+// SlackHandler handles `/teamconfig rotate <team>` slash commands. Rotations must be approved
+// by one of the approvers other than the requester before they are run, and both must be allowed
+// to rotate the team by the policy of the server.
+type SlackHandler struct {
+	Server        *Server
+	SigningSecret []byte
+	Approvers     []string
+	Client        *http.Client
+
+	mutex   sync.Mutex
+	pending map[string]*slackApproval
+}
+
+func NewSlackHandler(server *Server, signingSecret string, approvers []string) *SlackHandler {
+	return &SlackHandler{
+		Server:        server,
+		SigningSecret: []byte(signingSecret),
+		Approvers:     approvers,
+		Client:        &http.Client{Timeout: 10 * time.Second},
+		pending:       make(map[string]*slackApproval),
+	}
+}
+
+// verify checks the signature of a Slack request, and returns its body.
+func (h *SlackHandler) verify(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request timestamp")
+	}
+	age := time.Since(time.Unix(seconds, 0))
+	if age > SlackMaxClockSkew || age < -SlackMaxClockSkew {
+		return nil, fmt.Errorf("request timestamp is too old")
+	}
+
+	mac := hmac.New(sha256.New, h.SigningSecret)
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Slack-Signature"))) {
+		return nil, fmt.Errorf("invalid request signature")
+	}
+
+	return body, nil
+}
+
+// mayApprove returns true if the user may approve a rotation requested by someone else.
+func (h *SlackHandler) mayApprove(userID string) bool {
+	for _, approver := range h.Approvers {
+		if approver == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// request registers a rotation waiting for approval, and returns its ID.
+func (h *SlackHandler) request(team, requestedBy string) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(random)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for id, approval := range h.pending {
+		if time.Since(approval.Requested) > SlackApprovalTimeout {
+			delete(h.pending, id)
+		}
+	}
+	h.pending[id] = &slackApproval{
+		Team:        team,
+		RequestedBy: requestedBy,
+		Requested:   time.Now(),
+	}
+	return id, nil
+}
+
+// take removes a rotation waiting for approval, and returns it if it has not expired.
+func (h *SlackHandler) take(id string) *slackApproval {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	approval, ok := h.pending[id]
+	if !ok {
+		return nil
+	}
+	delete(h.pending, id)
+	if time.Since(approval.Requested) > SlackApprovalTimeout {
+		return nil
+	}
+	return approval
+}
+
+// ServeCommand handles slash commands.
+func (h *SlackHandler) ServeCommand(w http.ResponseWriter, r *http.Request) {
+	body, err := h.verify(w, r)
+	if err != nil {
+		log.Warnf("slack: %s", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "invalid command", http.StatusBadRequest)
+		return
+	}
+
+	userID := form.Get("user_id")
+	args := strings.Fields(form.Get("text"))
+	if len(args) != 2 || args[0] != "rotate" || len(validation.IsDNS1123Label(args[1])) > 0 {
+		serveJSON(w, http.StatusOK, slackMessage{
+			ResponseType: "ephemeral",
+			Text:         fmt.Sprintf("Usage: %s rotate <team>", form.Get("command")),
+		})
+		return
+	}
+	team := args[1]
+
+	err = h.Server.auth.Authorize(slackCaller(userID), team, OperationRotate)
+	if err != nil {
+		log.Warnf("slack: %s (%s): %s", form.Get("user_name"), userID, err)
+		serveJSON(w, http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: fmt.Sprintf("You may not rotate the tokens of team *%s*.", team)})
+		return
+	}
+
+	id, err := h.request(team, userID)
+	if err != nil {
+		serveError(w, http.StatusInternalServerError, err)
+		return
+	}
+	log.Infof("slack: %s (%s) requested rotation of team %s", form.Get("user_name"), userID, team)
+
+	serveJSON(w, http.StatusOK, slackMessage{
+		ResponseType: "in_channel",
+		Text:         fmt.Sprintf("<@%s> wants to rotate the tokens of team *%s*. This invalidates the current tokens.", userID, team),
+		Attachments: []slackAttachment{
+			{
+				Text:       fmt.Sprintf("Someone else must approve within %s.", formatDuration(SlackApprovalTimeout)),
+				CallbackID: id,
+				Actions: []slackAction{
+					{Name: "approve", Text: "Approve", Type: "button", Value: "approve", Style: "danger"},
+					{Name: "deny", Text: "Deny", Type: "button", Value: "deny"},
+				},
+			},
+		},
+	})
+}
+
+// ServeInteraction handles clicks on the approve and deny buttons.
+func (h *SlackHandler) ServeInteraction(w http.ResponseWriter, r *http.Request) {
+	body, err := h.verify(w, r)
+	if err != nil {
+		log.Warnf("slack: %s", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "invalid interaction", http.StatusBadRequest)
+		return
+	}
+	interaction := slackInteraction{}
+	err = json.Unmarshal([]byte(form.Get("payload")), &interaction)
+	if err != nil || len(interaction.Actions) == 0 {
+		http.Error(w, "invalid interaction", http.StatusBadRequest)
+		return
+	}
+
+	user := interaction.User
+	h.mutex.Lock()
+	approval, ok := h.pending[interaction.CallbackID]
+	h.mutex.Unlock()
+	if !ok {
+		serveJSON(w, http.StatusOK, slackMessage{ReplaceOriginal: true, Text: "This request has expired or was already handled."})
+		return
+	}
+
+	if interaction.Actions[0].Value == "deny" {
+		h.take(interaction.CallbackID)
+		log.Infof("slack: %s (%s) denied rotation of team %s", user.Name, user.ID, approval.Team)
+		serveJSON(w, http.StatusOK, slackMessage{ReplaceOriginal: true, ResponseType: "in_channel", Text: fmt.Sprintf("<@%s> denied rotating the tokens of team *%s*.", user.ID, approval.Team)})
+		return
+	}
+
+	caller := slackCaller(user.ID)
+	if user.ID == approval.RequestedBy || !h.mayApprove(user.ID) {
+		serveJSON(w, http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: "You may not approve this rotation."})
+		return
+	}
+	for _, c := range []*Caller{slackCaller(approval.RequestedBy), caller} {
+		if err := h.Server.auth.Authorize(c, approval.Team, OperationRotate); err != nil {
+			log.Warnf("slack: %s (%s) may not approve rotation of team %s: %s", user.Name, user.ID, approval.Team, err)
+			serveJSON(w, http.StatusOK, slackMessage{ResponseType: "ephemeral", Text: "You may not approve this rotation."})
+			return
+		}
+	}
+
+	approval = h.take(interaction.CallbackID)
+	if approval == nil {
+		serveJSON(w, http.StatusOK, slackMessage{ReplaceOriginal: true, Text: "This request has expired or was already handled."})
+		return
+	}
+	log.Infof("slack: %s (%s) approved rotation of team %s", user.Name, user.ID, approval.Team)
+	serveJSON(w, http.StatusOK, slackMessage{ReplaceOriginal: true, ResponseType: "in_channel", Text: fmt.Sprintf("<@%s> approved rotating the tokens of team *%s*, requested by <@%s>. Rotating...", user.ID, approval.Team, approval.RequestedBy)})
+
+	// Slack expects a response within three seconds, so the outcome is posted when done
+	go h.rotate(approval, caller, interaction.ResponseURL)
+}
+
+// rotate runs an approved rotation, and posts the outcome to the response URL of the interaction.
+func (h *SlackHandler) rotate(approval *slackApproval, caller *Caller, responseURL string) {
+	text := ""
+	report, err := h.Server.run(caller, approval.Team, nil, OperationRotate)
+	switch {
+	case err != nil:
+		text = fmt.Sprintf("Rotating the tokens of team *%s* failed: %s", approval.Team, err)
+	case !report.Success:
+		text = fmt.Sprintf("Rotating the tokens of team *%s* failed: %s", approval.Team, report.Teams[0].Error)
+	default:
+		text = fmt.Sprintf("Rotated the tokens of team *%s* in %d clusters.", approval.Team, len(report.Teams[0].Clusters))
+	}
+
+	ctx, cancel := context.WithTimeout(h.Server.ctx, 10*time.Second)
+	defer cancel()
+	err = jsonRequest(ctx, h.Client, http.MethodPost, responseURL, nil, slackMessage{ResponseType: "in_channel", Text: text}, nil)
+	if err != nil {
+		log.Errorf("slack: while posting outcome: %s", err)
+	}
+}
+
+// Register adds the slash command and interactivity endpoints to mux.
+func (h *SlackHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/slack/command", h.ServeCommand)
+	mux.HandleFunc("/slack/interaction", h.ServeInteraction)
+}
+
+// validateSlack checks the Slack slash command configuration before the server starts.
+func validateSlack() error {
+	if !config.Slack.Enabled {
+		return nil
+	}
+	if len(config.Slack.Approvers) == 0 {
+		return fmt.Errorf("--slack-commands requires --slack-approvers")
+	}
+	_, err := slackSigningSecret()
+	return err
+}
+
+// slackSigningSecret returns the signing secret of the Slack app.
+func slackSigningSecret() (string, error) {
+	secret := os.Getenv("SLACK_SIGNING_SECRET")
+	if len(secret) == 0 {
+		return "", fmt.Errorf("--slack-commands requires the SLACK_SIGNING_SECRET environment variable")
+	}
+	return secret, nil
+}
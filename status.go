@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ServiceAccountStatus describes the team service account in a single cluster.
+type ServiceAccountStatus struct {
+	State string
+
+	// Created is when the service account was created or its token was last rotated.
+	Created time.Time
+
+	// TokenCreated is when the token secret was created. Zero if there is no token secret.
+	TokenCreated time.Time
+
+	// TokenExpires is when the token expires. Zero if unknown, or if the token never expires.
+	TokenExpires time.Time
+
+	// Claims of the token secret. Nil if there is no token secret, or its token could not be parsed.
+	Claims *TokenClaims
+}
+
+// formatList returns a comma separated list, or a dash if the list is empty.
+func formatList(list []string) string {
+	if len(list) == 0 {
+		return "-"
+	}
+	return strings.Join(list, ",")
+}
+
+// formatClaims returns the columns describing token claims in the status table.
+func formatClaims(claims *TokenClaims) string {
+	if claims == nil {
+		return "-\t-\t-\t-"
+	}
+	issuer := claims.Issuer
+	if len(issuer) == 0 {
+		issuer = "-"
+	}
+	return fmt.Sprintf("%s\t%s\t%s\t%s", claims.Type(), issuer, formatList(claims.Audiences), formatList(claims.BoundTo()))
+}
+
+// formatDuration returns a short, human readable representation of d.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+}
+
+// formatAge returns a short, human readable representation of the time passed since t.
+func formatAge(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return formatDuration(time.Since(t))
+}
+
+// formatRemaining returns a short, human readable representation of the time left until t.
+func formatRemaining(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	remaining := time.Until(t)
+	if remaining <= 0 {
+		return "expired"
+	}
+	return formatDuration(remaining)
+}
+
+// formatTime returns t in RFC 3339 format, or a dash if t is zero.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// clusterStatus inspects the team service account and its token secret in a single cluster.
+func clusterStatus(ctx context.Context, cluster string) (*ServiceAccountStatus, error) {
+	config := configFrom(ctx)
+	_, client, err := clusterClient(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceAccount, err := ServiceAccount(client, config.serviceAccountNamespace(cluster), ServiceAccountName(config.Team))
+	if errors.IsNotFound(err) {
+		return &ServiceAccountStatus{State: "missing"}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("while retrieving service account: %s", err)
+	}
+
+	status := &ServiceAccountStatus{
+		State:   "ok",
+		Created: LastRotated(*serviceAccount),
+	}
+
+	status.TokenExpires = annotatedTime(*serviceAccount, TokenExpiresAnnotation)
+
+	secret, err := ServiceAccountSecret(client, *serviceAccount)
+	if err != nil {
+		if status.TokenExpires.IsZero() {
+			status.State = "no token"
+		}
+		return status, nil
+	}
+	status.TokenCreated = secret.CreationTimestamp.Time
+
+	claims, err := ParseTokenClaims(string(secret.Data["token"]))
+	if err != nil {
+		log.Debugf("%s: %s", cluster, err)
+		return status, nil
+	}
+	status.Claims = claims
+	if !claims.ExpiresAt().IsZero() {
+		status.TokenExpires = claims.ExpiresAt()
+	}
+
+	return status, nil
+}
+
+// teamStatus inspects the team service account in all clusters. Clusters that could not be
+// inspected have an error state, clusters not visited because the context was done are skipped,
+// and the first error is returned.
+func teamStatus(ctx context.Context) ([]*ServiceAccountStatus, error) {
+	statuses := make([]*ServiceAccountStatus, len(configFrom(ctx).Clusters))
+
+	err := forEachCluster(ctx, func(ctx context.Context, i int, cluster string) error {
+		status, err := clusterStatus(ctx, cluster)
+		if err != nil {
+			status = &ServiceAccountStatus{State: fmt.Sprintf("error: %s", err)}
+		}
+		statuses[i] = status
+		return err
+	})
+
+	for i, status := range statuses {
+		if status == nil {
+			statuses[i] = &ServiceAccountStatus{State: "skipped"}
+		}
+	}
+
+	return statuses, err
+}
+
+// status prints the state of the team service account in all clusters
+// to standard output, including when it was created or last rotated.
+// No changes are made to any cluster.
+func status(ctx context.Context) error {
+	statuses, err := teamStatus(ctx)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "CLUSTER\tNAMESPACE\tSERVICE ACCOUNT\tSTATUS\tLAST ROTATED\tTOKEN AGE\tEXPIRES IN")
+	if config.ShowClaims {
+		fmt.Fprintf(w, "\tTOKEN TYPE\tISSUER\tAUDIENCES\tBOUND TO")
+	}
+	fmt.Fprintf(w, "\n")
+	for i, cluster := range config.Clusters {
+		status := statuses[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s",
+			cluster,
+			config.serviceAccountNamespace(cluster),
+			ServiceAccountName(config.Team),
+			status.State,
+			formatTime(status.Created),
+			formatAge(status.TokenCreated),
+			formatRemaining(status.TokenExpires),
+		)
+		if config.ShowClaims {
+			fmt.Fprintf(w, "\t%s", formatClaims(status.Claims))
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	w.Flush()
+
+	if err != nil {
+		return fmt.Errorf("exiting due to errors")
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTeamStatusCancelled(t *testing.T) {
+	c := *config
+	c.Team = "aura"
+	c.Clusters = []string{"dev-fss", "dev-sbs", "prod-fss"}
+	c.Kubeconfig = "/nonexistent/kubeconfig"
+	c.Concurrency = 1
+	ctx, cancel := context.WithCancel(withConfig(context.Background(), &c))
+	cancel()
+
+	statuses, err := teamStatus(ctx)
+	if err == nil {
+		t.Errorf("teamStatus() succeeded with a cancelled context")
+	}
+	if len(statuses) != len(c.Clusters) {
+		t.Fatalf("teamStatus() returned %d statuses, want %d", len(statuses), len(c.Clusters))
+	}
+	for i, status := range statuses {
+		if status == nil || len(status.State) == 0 {
+			t.Errorf("%s: no status", c.Clusters[i])
+		}
+	}
+}
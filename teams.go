@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// teamsFile is the YAML format of --teams-file. A plain list of team names is also accepted.
+type teamsFile struct {
+	Teams []string `json:"teams"`
+}
+
+// ReadTeamsFile reads team names from a file. Files ending in .yaml or .yml are parsed as YAML,
+// either as a list of team names or as an object with a `teams` list. Other files contain one
+// team name per line; empty lines and lines starting with # are ignored.
+func ReadTeamsFile(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var teams []string
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &teams)
+		if err != nil {
+			file := teamsFile{}
+			err = yaml.Unmarshal(data, &file)
+			teams = file.Teams
+		}
+		if err != nil {
+			return nil, fmt.Errorf("while parsing %s: %s", path, err)
+		}
+	default:
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if len(line) == 0 || strings.HasPrefix(line, "#") {
+				continue
+			}
+			teams = append(teams, line)
+		}
+	}
+
+	if len(teams) == 0 {
+		return nil, fmt.Errorf("no teams found in %s", path)
+	}
+
+	seen := make(map[string]bool)
+	for _, team := range teams {
+		if seen[team] {
+			return nil, fmt.Errorf("team '%s' is listed more than once in %s", team, path)
+		}
+		seen[team] = true
+	}
+
+	return teams, nil
+}
+
+// DesiredTeam is a team that should have a service account in the clusters matching Clusters,
+// or in all clusters if none are given.
+type DesiredTeam struct {
+	Name     string   `json:"name"`
+	Clusters []string `json:"clusters,omitempty"`
+}
+
+// stateFile is the YAML format of --state-file.
+type stateFile struct {
+	Teams []DesiredTeam `json:"teams"`
+}
+
+// ReadStateFile reads the teams that should have access, and to which clusters, from a YAML file.
+func ReadStateFile(path string) ([]DesiredTeam, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := stateFile{}
+	err = yaml.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing %s: %s", path, err)
+	}
+
+	// An empty file would revoke every team, which is more likely a mistake than intended.
+	if len(file.Teams) == 0 {
+		return nil, fmt.Errorf("no teams found in %s", path)
+	}
+
+	seen := make(map[string]bool)
+	for _, team := range file.Teams {
+		if errs := validation.IsDNS1123Label(team.Name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid team name '%s' in %s: %s", team.Name, path, strings.Join(errs, ", "))
+		}
+		if seen[team.Name] {
+			return nil, fmt.Errorf("team '%s' is listed more than once in %s", team.Name, path)
+		}
+		seen[team.Name] = true
+	}
+
+	return file.Teams, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthenticationv1beta1 "k8s.io/client-go/pkg/apis/clientauthentication/v1beta1"
+)
+
+// token mints a short-lived token for the team service account in a single cluster, and
+// prints it to standard output as an ExecCredential. This makes teamconfig usable as the
+// credential plugin in Kubeconfig files generated with --auth exec.
+func token(ctx context.Context) error {
+	if len(config.Clusters) != 1 {
+		return validationError(fmt.Errorf("exactly one cluster must be given with --clusters"))
+	}
+	cluster := config.Clusters[0]
+
+	_, client, err := clusterClient(ctx, cluster)
+	if err != nil {
+		return err
+	}
+
+	status, err := RequestServiceAccountToken(client, config.serviceAccountNamespace(cluster), ServiceAccountName(config.Team), config.TokenAudiences, config.TokenTTL)
+	if err != nil {
+		return fmt.Errorf("while requesting token: %s", err)
+	}
+
+	credential := clientauthenticationv1beta1.ExecCredential{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: ExecCredentialAPIVersion,
+			Kind:       "ExecCredential",
+		},
+		Status: &clientauthenticationv1beta1.ExecCredentialStatus{
+			Token:               status.Token,
+			ExpirationTimestamp: &status.ExpirationTimestamp,
+		},
+	}
+
+	return json.NewEncoder(os.Stdout).Encode(credential)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates spans for cluster operations. Until tracing is enabled, spans are not recorded.
+var tracer = otel.Tracer("github.com/nais/teamconfig")
+
+// tracingEnabled returns true if an OTLP endpoint is configured, either with --otlp-endpoint
+// or the standard OpenTelemetry environment variables.
+func tracingEnabled() bool {
+	return len(config.OTLPEndpoint) > 0 ||
+		len(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")) > 0 ||
+		len(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")) > 0
+}
+
+// initTracing exports spans to the configured OTLP endpoint. The returned function
+// flushes any remaining spans, and must be called before exiting.
+func initTracing(ctx context.Context) (func(), error) {
+	if !tracingEnabled() {
+		return func() {}, nil
+	}
+
+	options := make([]otlptracehttp.Option, 0)
+	if len(config.OTLPEndpoint) > 0 {
+		options = append(options, otlptracehttp.WithEndpointURL(config.OTLPEndpoint))
+	}
+
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "teamconfig"))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return func() {
+		// The command context may already be cancelled, so flush with a fresh one.
+		err := provider.Shutdown(context.Background())
+		if err != nil {
+			log.Warnf("while exporting traces: %s", err)
+		}
+	}, nil
+}
+
+// startClusterSpan starts a span for an operation on a team in a single cluster.
+func startClusterSpan(ctx context.Context, name, team, cluster string) (context.Context, trace.Span) {
+	action := configFrom(ctx).auditAction()
+	if len(action) == 0 {
+		action = "get"
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(
+		attribute.String("team", team),
+		attribute.String("cluster", cluster),
+		attribute.String("action", action),
+	))
+}
+
+// endSpan records the outcome of an operation and ends its span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingRoundTripper creates a span for every Kubernetes API request.
+type tracingRoundTripper struct {
+	next http.RoundTripper
+}
+
+func (rt *tracingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := tracer.Start(req.Context(), req.Method+" "+req.URL.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", req.Method),
+			attribute.String("http.url", req.URL.String()),
+		),
+	)
+	defer span.End()
+
+	resp, err := rt.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+
+	return resp, nil
+}
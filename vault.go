@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultVaultAddress = "https://127.0.0.1:8200"
+
+// VaultClient is a minimal client for the Vault HTTP API.
+type VaultClient struct {
+	Address   string
+	Token     string
+	Namespace string
+	Client    *http.Client
+}
+
+// NewVaultClientFromEnvironment configures a Vault client the same way as the vault CLI does.
+// The address is read from VAULT_AGENT_ADDR or VAULT_ADDR, and the token from VAULT_TOKEN or
+// ~/.vault-token. When talking to a Vault agent with auto-auth, no token is needed.
+// VAULT_NAMESPACE and VAULT_CACERT are also honored.
+func NewVaultClientFromEnvironment() (*VaultClient, error) {
+	client := &VaultClient{
+		Address:   os.Getenv("VAULT_ADDR"),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+		Client:    &http.Client{},
+	}
+
+	if agent := os.Getenv("VAULT_AGENT_ADDR"); len(agent) > 0 {
+		client.Address = agent
+	}
+	if len(client.Address) == 0 {
+		client.Address = defaultVaultAddress
+	}
+
+	if len(client.Token) == 0 {
+		if home, err := os.UserHomeDir(); err == nil {
+			token, err := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+			if err == nil {
+				client.Token = strings.TrimSpace(string(token))
+			}
+		}
+	}
+
+	if caFile := os.Getenv("VAULT_CACERT"); len(caFile) > 0 {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("while reading VAULT_CACERT: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		client.Client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}
+	}
+
+	return client, nil
+}
+
+func (v *VaultClient) request(ctx context.Context, method, path string, body, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	url := strings.TrimSuffix(v.Address, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequest(method, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if len(v.Token) > 0 {
+		req.Header.Set("X-Vault-Token", v.Token)
+	}
+	if len(v.Namespace) > 0 {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		vaultErr := struct {
+			Errors []string `json:"errors"`
+		}{}
+		if json.Unmarshal(data, &vaultErr) == nil && len(vaultErr.Errors) > 0 {
+			return fmt.Errorf("%s %s: %s", method, path, strings.Join(vaultErr.Errors, "; "))
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+
+	if result != nil && len(data) > 0 {
+		return json.Unmarshal(data, result)
+	}
+
+	return nil
+}
+
+// kvMount returns the mount path and KV engine version of the secrets engine serving a path.
+func (v *VaultClient) kvMount(ctx context.Context, path string) (string, int, error) {
+	mount := struct {
+		Data struct {
+			Path    string `json:"path"`
+			Options struct {
+				Version string `json:"version"`
+			} `json:"options"`
+		} `json:"data"`
+	}{}
+
+	err := v.request(ctx, http.MethodGet, "sys/internal/ui/mounts/"+path, nil, &mount)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if mount.Data.Options.Version == "2" {
+		return mount.Data.Path, 2, nil
+	}
+	return mount.Data.Path, 1, nil
+}
+
+// WriteSecret writes key/value data to a path in a KV secrets engine,
+// creating a new version if the engine is KV version 2.
+func (v *VaultClient) WriteSecret(ctx context.Context, path string, data map[string]string) error {
+	mount, version, err := v.kvMount(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	if version == 1 {
+		return v.request(ctx, http.MethodPut, path, data, nil)
+	}
+
+	// KV version 2 nests the data under <mount>/data/<path>.
+	path = mount + "data/" + strings.TrimPrefix(path, mount)
+	body := map[string]interface{}{
+		"data": data,
+	}
+	return v.request(ctx, http.MethodPut, path, body, nil)
+}
+
+// vaultPath returns the Vault path to write the credentials of a team to.
+func vaultPath(team string) string {
+	return strings.Replace(config.VaultPath, "{team}", team, -1)
+}
+
+// writeVault stores the Kubeconfig file and, if configured, the token of each cluster in Vault.
+func writeVault(ctx context.Context, team string, kubeconfig []byte, credentials []*Credentials) error {
+	config := configFrom(ctx)
+	client, err := NewVaultClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	data := make(map[string]string)
+	for _, field := range config.VaultFields {
+		switch field {
+		case "kubeconfig":
+			data["kubeconfig"] = string(kubeconfig)
+		case "tokens":
+			for _, c := range credentials {
+				if c != nil {
+					data[fmt.Sprintf("token-%s", c.Cluster)] = c.Token
+				}
+			}
+		default:
+			return fmt.Errorf("unknown Vault field '%s'; must be one of kubeconfig, tokens", field)
+		}
+	}
+
+	path := vaultPath(team)
+	err = client.WriteSecret(ctx, path, data)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("credentials written to Vault at %s", path)
+	return nil
+}
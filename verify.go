@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/client-go/rest"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// verifyCredentials checks that freshly generated credentials are accepted by the cluster,
+// by listing pods in the service account namespace using the new token. The connection
+// settings of clientConfig are kept, so that the cluster is reached the same way as before.
+func verifyCredentials(ctx context.Context, clientConfig *rest.Config, credentials *Credentials, namespace string) error {
+	verifyConfig := rest.AnonymousClientConfig(clientConfig)
+	verifyConfig.BearerToken = credentials.Token
+
+	client, err := newClusterClient(ctx, credentials.Cluster, verifyConfig)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.CoreV1().Pods(namespace).List(metav1.ListOptions{Limit: 1})
+	if err != nil {
+		return fmt.Errorf("while verifying credentials: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ManagedChangeWindow is how long a service account or token secret may be deleted
+// after it has been marked with ManagedChangeAnnotation.
+const ManagedChangeWindow = time.Minute
+
+// Controllers in the cluster must be able to maintain service accounts and token secrets,
+// for instance to clean up token secrets when a service account is deleted.
+const (
+	controllerManagerUser = "system:kube-controller-manager"
+	controllerGroup       = "system:serviceaccounts:kube-system"
+)
+
+// trustedUser returns true if requests by the user are always admitted. Besides the controllers,
+// these are the users given with --trusted-users, such as the identity teamconfig runs as.
+func trustedUser(user authenticationv1.UserInfo, trustedUsers []string) bool {
+	if user.Username == controllerManagerUser {
+		return true
+	}
+	for _, group := range user.Groups {
+		if group == controllerGroup {
+			return true
+		}
+	}
+	for _, username := range trustedUsers {
+		if user.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeObject decodes a service account or secret from an admission request.
+// Objects of other kinds are returned as nil.
+func decodeObject(kind string, raw []byte) (runtime.Object, error) {
+	var obj runtime.Object
+	switch kind {
+	case "ServiceAccount":
+		obj = &v1.ServiceAccount{}
+	case "Secret":
+		obj = &v1.Secret{}
+	default:
+		return nil, nil
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("no object in admission request")
+	}
+	err := json.Unmarshal(raw, obj)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// fetchObject retrieves a service account or secret from the cluster.
+// Objects of other kinds are returned as nil.
+func fetchObject(client kubernetes.Interface, kind, namespace, name string) (runtime.Object, error) {
+	switch kind {
+	case "ServiceAccount":
+		return client.CoreV1().ServiceAccounts(namespace).Get(name, metav1.GetOptions{})
+	case "Secret":
+		return client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	default:
+		return nil, nil
+	}
+}
+
+// protected returns true if obj is a team service account or one of its token secrets.
+func protected(obj runtime.Object) bool {
+	switch obj := obj.(type) {
+	case *v1.ServiceAccount:
+		_, ok := TeamName(obj.Name)
+		return ok
+	case *v1.Secret:
+		if obj.Type != v1.SecretTypeServiceAccountToken {
+			return false
+		}
+		_, ok := TeamName(obj.Annotations[v1.ServiceAccountNameKey])
+		return ok
+	default:
+		return false
+	}
+}
+
+// managedChange returns the value of ManagedChangeAnnotation on obj.
+func managedChange(obj runtime.Object) string {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+	return accessor.GetAnnotations()[ManagedChangeAnnotation]
+}
+
+// onlyManagedChange returns true if the only difference between two versions of an object is
+// ManagedChangeAnnotation, disregarding metadata maintained by the API server.
+func onlyManagedChange(previous, current runtime.Object) bool {
+	previous, current = previous.DeepCopyObject(), current.DeepCopyObject()
+	for _, obj := range []runtime.Object{previous, current} {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return false
+		}
+		annotations := accessor.GetAnnotations()
+		delete(annotations, ManagedChangeAnnotation)
+		if len(annotations) == 0 {
+			accessor.SetAnnotations(nil)
+		}
+		accessor.SetResourceVersion("")
+		accessor.SetGeneration(0)
+	}
+	return equality.Semantic.DeepEqual(previous, current)
+}
+
+// admit decides whether an update or deletion of a team service account or token secret is allowed.
+// Updates may only set ManagedChangeAnnotation to a new value, and deletions must happen within
+// ManagedChangeWindow of the object being marked with it. Any other change must be made by a trusted
+// user. An empty reason means the request is admitted.
+func admit(client kubernetes.Interface, req *admissionv1beta1.AdmissionRequest, trustedUsers []string) (string, error) {
+	if trustedUser(req.UserInfo, trustedUsers) {
+		return "", nil
+	}
+
+	switch req.Operation {
+	case admissionv1beta1.Update:
+		old, err := decodeObject(req.Kind.Kind, req.OldObject.Raw)
+		if err != nil {
+			return "", fmt.Errorf("while decoding old object: %s", err)
+		}
+		if old == nil || !protected(old) {
+			return "", nil
+		}
+		obj, err := decodeObject(req.Kind.Kind, req.Object.Raw)
+		if err != nil {
+			return "", fmt.Errorf("while decoding object: %s", err)
+		}
+		changed := managedChange(obj)
+		if len(changed) > 0 && changed != managedChange(old) && onlyManagedChange(old, obj) {
+			return "", nil
+		}
+
+	case admissionv1beta1.Delete:
+		obj, err := fetchObject(client, req.Kind.Kind, req.Namespace, req.Name)
+		if errors.IsNotFound(err) {
+			return "", nil
+		} else if err != nil {
+			return "", fmt.Errorf("while retrieving object: %s", err)
+		}
+		if obj == nil || !protected(obj) {
+			return "", nil
+		}
+		changed, err := time.Parse(time.RFC3339, managedChange(obj))
+		if err == nil && time.Since(changed) <= ManagedChangeWindow {
+			return "", nil
+		}
+
+	default:
+		return "", nil
+	}
+
+	return fmt.Sprintf("%s %s/%s is managed by teamconfig; use teamconfig to rotate or revoke it", req.Kind.Kind, req.Namespace, req.Name), nil
+}
+
+// admissionHandler serves validating admission reviews, always admitting requests by trustedUsers.
+func admissionHandler(client kubernetes.Interface, trustedUsers []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		review := &admissionv1beta1.AdmissionReview{}
+		err := json.NewDecoder(r.Body).Decode(review)
+		if err != nil || review.Request == nil {
+			http.Error(w, "invalid admission review", http.StatusBadRequest)
+			return
+		}
+		req := review.Request
+
+		response := &admissionv1beta1.AdmissionResponse{
+			UID:     req.UID,
+			Allowed: true,
+		}
+		reason, err := admit(client, req, trustedUsers)
+		if err != nil {
+			log.Errorf("%s %s/%s: %s", req.Kind.Kind, req.Namespace, req.Name, err)
+			reason = err.Error()
+		}
+		if len(reason) > 0 {
+			log.Infof("%s: denied %s of %s %s/%s", req.UserInfo.Username, req.Operation, req.Kind.Kind, req.Namespace, req.Name)
+			response.Allowed = false
+			response.Result = &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Reason:  metav1.StatusReasonForbidden,
+				Code:    http.StatusForbidden,
+				Message: reason,
+			}
+		}
+
+		review.Response = response
+		review.Request = nil
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(review)
+	})
+}
+
+// webhook serves the validating admission webhook until interrupted.
+func webhook(ctx context.Context) error {
+	if len(config.WebhookCluster) == 0 {
+		return validationError(fmt.Errorf("--webhook-cluster must be specified"))
+	}
+	if len(config.TLSCertFile) == 0 || len(config.TLSKeyFile) == 0 {
+		return validationError(fmt.Errorf("--tls-cert-file and --tls-key-file must be specified"))
+	}
+
+	_, client, err := clusterClient(ctx, config.WebhookCluster)
+	if err != nil {
+		return err
+	}
+
+	startDebugServer(ctx)
+
+	health := NewHealth()
+	health.Completed(map[string]error{config.WebhookCluster: nil})
+
+	mux := http.NewServeMux()
+	mux.Handle("/validate", admissionHandler(client, config.TrustedUsers))
+	health.Register(mux)
+	server := &http.Server{
+		Addr:    config.ListenAddress,
+		Handler: mux,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Infof("serving admission webhook on %s/validate", config.ListenAddress)
+		errs <- server.ListenAndServeTLS(config.TLSCertFile, config.TLSKeyFile)
+	}()
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("while serving admission webhook: %s", err)
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
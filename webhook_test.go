@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func serviceAccount(name, changed string) *v1.ServiceAccount {
+	sa := &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+	}
+	if len(changed) > 0 {
+		sa.Annotations = map[string]string{ManagedChangeAnnotation: changed}
+	}
+	return sa
+}
+
+// withSecret returns the service account referencing a token secret.
+func withSecret(sa *v1.ServiceAccount, secret string) *v1.ServiceAccount {
+	sa.Secrets = append(sa.Secrets, v1.ObjectReference{Name: secret})
+	return sa
+}
+
+// withResourceVersion returns the service account with a resource version, as stored by the API server.
+func withResourceVersion(sa *v1.ServiceAccount, resourceVersion string) *v1.ServiceAccount {
+	sa.ResourceVersion = resourceVersion
+	return sa
+}
+
+// tokenSecret returns a token secret of a service account, marked with ManagedChangeAnnotation if changed is set.
+func tokenSecret(name, serviceAccountName, changed string) *v1.Secret {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: map[string]string{v1.ServiceAccountNameKey: serviceAccountName},
+		},
+		Type: v1.SecretTypeServiceAccountToken,
+	}
+	if len(changed) > 0 {
+		secret.Annotations[ManagedChangeAnnotation] = changed
+	}
+	return secret
+}
+
+func rawObject(t *testing.T, obj runtime.Object) runtime.RawExtension {
+	t.Helper()
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runtime.RawExtension{Raw: raw}
+}
+
+func TestProtected(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		obj  runtime.Object
+		want bool
+	}{
+		{"team service account", serviceAccount("serviceuser-aura", ""), true},
+		{"other service account", serviceAccount("default", ""), false},
+		{"team token secret", &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1.ServiceAccountNameKey: "serviceuser-aura"}},
+			Type:       v1.SecretTypeServiceAccountToken,
+		}, true},
+		{"other token secret", &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1.ServiceAccountNameKey: "default"}},
+			Type:       v1.SecretTypeServiceAccountToken,
+		}, false},
+		{"opaque secret", &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1.ServiceAccountNameKey: "serviceuser-aura"}},
+			Type:       v1.SecretTypeOpaque,
+		}, false},
+		{"other kind", &v1.ConfigMap{}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := protected(test.obj); got != test.want {
+				t.Errorf("protected() = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAdmitUpdate(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		user    authenticationv1.UserInfo
+		old     runtime.Object
+		obj     runtime.Object
+		kind    string
+		allowed bool
+	}{
+		{"unchanged annotation", authenticationv1.UserInfo{Username: "alice"},
+			serviceAccount("serviceuser-aura", "2018-01-01T00:00:00Z"), serviceAccount("serviceuser-aura", "2018-01-01T00:00:00Z"), "ServiceAccount", false},
+		{"no annotation", authenticationv1.UserInfo{Username: "alice"},
+			serviceAccount("serviceuser-aura", ""), serviceAccount("serviceuser-aura", ""), "ServiceAccount", false},
+		{"removed annotation", authenticationv1.UserInfo{Username: "alice"},
+			serviceAccount("serviceuser-aura", "2018-01-01T00:00:00Z"), serviceAccount("serviceuser-aura", ""), "ServiceAccount", false},
+		{"new annotation", authenticationv1.UserInfo{Username: "alice"},
+			serviceAccount("serviceuser-aura", "2018-01-01T00:00:00Z"), serviceAccount("serviceuser-aura", "2018-01-02T00:00:00Z"), "ServiceAccount", true},
+		{"new annotation and resource version", authenticationv1.UserInfo{Username: "alice"},
+			withResourceVersion(serviceAccount("serviceuser-aura", ""), "1"), withResourceVersion(serviceAccount("serviceuser-aura", "2018-01-02T00:00:00Z"), "2"), "ServiceAccount", true},
+		{"new annotation and secrets", authenticationv1.UserInfo{Username: "alice"},
+			serviceAccount("serviceuser-aura", "2018-01-01T00:00:00Z"), withSecret(serviceAccount("serviceuser-aura", "2018-01-02T00:00:00Z"), "serviceuser-aura-token-abcde"), "ServiceAccount", false},
+		{"new annotation and other annotation", authenticationv1.UserInfo{Username: "alice"},
+			serviceAccount("serviceuser-aura", "2018-01-01T00:00:00Z"), &v1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "serviceuser-aura",
+					Namespace:   "default",
+					Annotations: map[string]string{ManagedChangeAnnotation: "2018-01-02T00:00:00Z", RotatedAtAnnotation: "2018-01-02T00:00:00Z"},
+				},
+			}, "ServiceAccount", false},
+		{"new annotation on token secret", authenticationv1.UserInfo{Username: "alice"},
+			tokenSecret("serviceuser-aura-token-abcde", "serviceuser-aura", ""), tokenSecret("serviceuser-aura-token-abcde", "serviceuser-aura", "2018-01-02T00:00:00Z"), "Secret", true},
+		{"unprotected", authenticationv1.UserInfo{Username: "alice"},
+			serviceAccount("default", ""), serviceAccount("default", ""), "ServiceAccount", true},
+		{"other kind", authenticationv1.UserInfo{Username: "alice"},
+			&v1.ConfigMap{}, &v1.ConfigMap{}, "ConfigMap", true},
+		{"controller manager", authenticationv1.UserInfo{Username: controllerManagerUser},
+			serviceAccount("serviceuser-aura", ""), serviceAccount("serviceuser-aura", ""), "ServiceAccount", true},
+		{"kube-system service account", authenticationv1.UserInfo{Username: "system:serviceaccount:kube-system:tokens-controller", Groups: []string{controllerGroup}},
+			serviceAccount("serviceuser-aura", ""), serviceAccount("serviceuser-aura", ""), "ServiceAccount", true},
+		{"trusted user", authenticationv1.UserInfo{Username: "system:serviceaccount:nais:teamconfig"},
+			serviceAccount("serviceuser-aura", ""), withSecret(serviceAccount("serviceuser-aura", ""), "serviceuser-aura-token-abcde"), "ServiceAccount", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := &admissionv1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: test.kind},
+				Namespace: "default",
+				Name:      "serviceuser-aura",
+				Operation: admissionv1beta1.Update,
+				UserInfo:  test.user,
+				Object:    rawObject(t, test.obj),
+				OldObject: rawObject(t, test.old),
+			}
+			reason, err := admit(fake.NewSimpleClientset(), req, []string{"system:serviceaccount:nais:teamconfig"})
+			if err != nil {
+				t.Fatalf("admit() error: %s", err)
+			}
+			if allowed := len(reason) == 0; allowed != test.allowed {
+				t.Errorf("admit() allowed = %t, want %t (reason %q)", allowed, test.allowed, reason)
+			}
+		})
+	}
+}
+
+func TestAdmitDelete(t *testing.T) {
+	now := time.Now().UTC()
+	for _, test := range []struct {
+		name    string
+		kind    string
+		objName string
+		objects []runtime.Object
+		allowed bool
+	}{
+		{"not found", "ServiceAccount", "serviceuser-aura", nil, true},
+		{"no annotation", "ServiceAccount", "serviceuser-aura", []runtime.Object{serviceAccount("serviceuser-aura", "")}, false},
+		{"invalid annotation", "ServiceAccount", "serviceuser-aura", []runtime.Object{serviceAccount("serviceuser-aura", "yesterday")}, false},
+		{"within window", "ServiceAccount", "serviceuser-aura", []runtime.Object{serviceAccount("serviceuser-aura", now.Format(time.RFC3339))}, true},
+		{"outside window", "ServiceAccount", "serviceuser-aura", []runtime.Object{serviceAccount("serviceuser-aura", now.Add(-2*ManagedChangeWindow).Format(time.RFC3339))}, false},
+		{"token secret without annotation", "Secret", "serviceuser-aura-token-abcde",
+			[]runtime.Object{tokenSecret("serviceuser-aura-token-abcde", "serviceuser-aura", "")}, false},
+		{"token secret within window", "Secret", "serviceuser-aura-token-abcde",
+			[]runtime.Object{tokenSecret("serviceuser-aura-token-abcde", "serviceuser-aura", now.Format(time.RFC3339))}, true},
+		{"other token secret", "Secret", "default-token-abcde",
+			[]runtime.Object{tokenSecret("default-token-abcde", "default", "")}, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := &admissionv1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: test.kind},
+				Namespace: "default",
+				Name:      test.objName,
+				Operation: admissionv1beta1.Delete,
+				UserInfo:  authenticationv1.UserInfo{Username: "alice"},
+			}
+			reason, err := admit(fake.NewSimpleClientset(test.objects...), req, nil)
+			if err != nil {
+				t.Fatalf("admit() error: %s", err)
+			}
+			if allowed := len(reason) == 0; allowed != test.allowed {
+				t.Errorf("admit() allowed = %t, want %t (reason %q)", allowed, test.allowed, reason)
+			}
+			if !test.allowed && !strings.Contains(reason, test.kind+" default/"+test.objName) {
+				t.Errorf("reason %q does not name the object", reason)
+			}
+		})
+	}
+}